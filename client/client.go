@@ -57,5 +57,8 @@ func DefaultClients(nc *nats.Conn) (*Group, error) {
 	shellyIO := NewManager(nc, NewShellyIOClient)
 	g.Add(shellyIO)
 
+	discovery := NewManager(nc, NewDiscoveryClient)
+	g.Add(discovery)
+
 	return g, nil
 }
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed 5 field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field can be *, a number, a range (1-5), a list (1,3,5), or a step
+// (*/15, 0-30/10). Day of week is 0-6 (Sunday = 0, 7 is also accepted as
+// Sunday). The @yearly, @monthly, @weekly, @daily, and @hourly shortcuts
+// are also supported.
+type cronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// if either day field is *, both day fields must match, otherwise
+	// either can match (standard cron behavior)
+	domStar bool
+	dowStar bool
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := cronShortcuts[expr]; ok {
+		expr = s
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron: expected 5 fields: %v", expr)
+	}
+
+	var ret cronSpec
+	var err error
+
+	ret.minute, err = parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return cronSpec{}, fmt.Errorf("cron: minute: %w", err)
+	}
+
+	ret.hour, err = parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return cronSpec{}, fmt.Errorf("cron: hour: %w", err)
+	}
+
+	ret.dom, err = parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return cronSpec{}, fmt.Errorf("cron: day of month: %w", err)
+	}
+
+	ret.month, err = parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return cronSpec{}, fmt.Errorf("cron: month: %w", err)
+	}
+
+	ret.dow, err = parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return cronSpec{}, fmt.Errorf("cron: day of week: %w", err)
+	}
+
+	// 7 is Sunday
+	if ret.dow&(1<<7) != 0 {
+		ret.dow |= 1
+	}
+
+	ret.domStar = strings.HasPrefix(fields[2], "*")
+	ret.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return ret, nil
+}
+
+// parseCronField returns a bit mask of the values in a cron field
+func parseCronField(field string, min, max int) (uint64, error) {
+	var ret uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepS, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepS)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step: %v", part)
+			}
+		}
+
+		start, end := min, max
+
+		if rng != "*" {
+			startS, endS, isRange := strings.Cut(rng, "-")
+			var err error
+			start, err = strconv.Atoi(startS)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %v", part)
+			}
+
+			switch {
+			case isRange:
+				end, err = strconv.Atoi(endS)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value: %v", part)
+				}
+			case hasStep:
+				// 5/15 is the same as 5-max/15
+			default:
+				end = start
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("value out of range: %v", part)
+		}
+
+		for i := start; i <= end; i += step {
+			ret |= 1 << uint(i)
+		}
+	}
+
+	return ret, nil
+}
+
+func (cs cronSpec) dayMatches(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+
+	if cs.domStar || cs.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t that matches the cron spec. Times
+// are evaluated in the location of t.
+func (cs cronSpec) next(t time.Time) (time.Time, error) {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// if nothing matches in 5 years, it never will (ex: Feb 30)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !cs.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// DST transition, where adding an hour maps back to
+				// the same time
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("cron: no time matches expression")
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available: ", err)
+	}
+
+	tests := []struct {
+		expr     string
+		t        time.Time
+		expected time.Time
+	}{
+		{"0 */15 * * *", time.Date(2023, 5, 1, 10, 7, 30, 0, time.UTC),
+			time.Date(2023, 5, 1, 15, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 5, 1, 10, 7, 30, 0, time.UTC),
+			time.Date(2023, 5, 1, 10, 15, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 5, 1, 10, 15, 0, 0, time.UTC),
+			time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC)},
+		{"30 6 * * 1-5", time.Date(2023, 5, 5, 7, 0, 0, 0, time.UTC),
+			time.Date(2023, 5, 8, 6, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2023, 5, 5, 7, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 12, 31, 7, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		// day of month or day of week (Sunday as 7)
+		{"0 0 15 * 7", time.Date(2023, 5, 8, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC)},
+		{"5-10/5,20 8 * * *", time.Date(2023, 5, 8, 8, 6, 0, 0, time.UTC),
+			time.Date(2023, 5, 8, 8, 10, 0, 0, time.UTC)},
+		// 2:30 does not exist on the day DST starts
+		{"30 2 * * *", time.Date(2023, 3, 11, 12, 0, 0, 0, ny),
+			time.Date(2023, 3, 13, 2, 30, 0, 0, ny)},
+		// local time is used
+		{"0 8 * * *", time.Date(2023, 3, 12, 0, 0, 0, 0, ny),
+			time.Date(2023, 3, 12, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		spec, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("%v: parse error: %v", test.expr, err)
+			continue
+		}
+
+		next, err := spec.next(test.t)
+		if err != nil {
+			t.Errorf("%v: next error: %v", test.expr, err)
+			continue
+		}
+
+		if !next.Equal(test.expected) {
+			t.Errorf("%v: after %v, expected %v, got %v", test.expr,
+				test.t, test.expected, next)
+		}
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(expr)
+		if err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+
+	spec, err := parseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal("parse error: ", err)
+	}
+
+	_, err = spec.next(time.Now())
+	if err == nil {
+		t.Error("expected error for expression that never matches")
+	}
+}
@@ -0,0 +1,193 @@
+package client_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestDiscoverySync(t *testing.T) {
+	// peers are found by a fake mDNS query as multicast is not available
+	// everywhere tests run. Both peers point at the upstream test server.
+	entries := make(chan *mdns.ServiceEntry, 2)
+	restore := client.SetDiscoveryQuery(func(p *mdns.QueryParam) error {
+		for {
+			select {
+			case e := <-entries:
+				p.Entries <- e
+			default:
+				return nil
+			}
+		}
+	})
+	defer restore()
+
+	ncU, _, stopU, err := server.TestServer("2")
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stopD()
+
+	for _, id := range []string{"peer-rejected", "peer-approved"} {
+		entries <- &mdns.ServiceEntry{
+			Name:       id + "._siot._tcp.local.",
+			Host:       id + ".local.",
+			AddrV4:     net.ParseIP("127.0.0.1"),
+			Port:       8910,
+			InfoFields: []string{"id=" + id, "desc=" + id, "sync=1"},
+		}
+	}
+
+	disc := client.Discovery{
+		ID:          "ID-discovery",
+		Parent:      rootD.ID,
+		Description: "discovery",
+	}
+
+	err = client.SendNodeType(ncD, disc, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// wait for the discovery client to start before enabling auto sync,
+	// which runs a scan
+	time.Sleep(250 * time.Millisecond)
+
+	err = client.SendNodePoint(ncD, disc.ID, data.Point{Type: data.PointTypeAutoSync,
+		Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	// a disabled sync node is created for each peer
+	var peers map[string]string
+	start := time.Now()
+	for {
+		nodes, err := client.GetNodesType[client.Discovery](ncD, rootD.ID, disc.ID)
+		if err != nil {
+			t.Fatal("Error getting discovery node: ", err)
+		}
+		if len(nodes) > 0 && len(nodes[0].Peers) == 2 {
+			peers = nodes[0].Peers
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Timeout waiting for peers")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	getSync := func(id string) client.Sync {
+		t.Helper()
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, id)
+		if err != nil {
+			t.Fatal("Error getting sync node: ", err)
+		}
+		if len(nodes) < 1 {
+			t.Fatal("sync node not found: ", id)
+		}
+		return nodes[0]
+	}
+
+	for _, id := range peers {
+		sync := getSync(id)
+		if !sync.Disable || sync.URI != "nats://127.0.0.1:8910" {
+			t.Fatalf("sync node is not correct: %+v", sync)
+		}
+	}
+
+	approve := func(peer string) {
+		t.Helper()
+		err := client.SendNodePoint(ncD, disc.ID, data.Point{Type: data.PointTypePeerApprove,
+			Key: peer, Value: 1, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	// approving a peer whose sync node was deleted does not touch the
+	// deleted node
+	rejectedID := peers["peer-rejected"]
+	err = client.DeleteNode(ncD, rejectedID, rootD.ID, "test")
+	if err != nil {
+		t.Fatal("Error deleting sync node: ", err)
+	}
+
+	approve("peer-rejected")
+
+	// approving a peer enables the sync node, which then syncs upstream
+	approve("peer-approved")
+
+	start = time.Now()
+	for {
+		nodes, err := client.GetNodes(ncU, "all", rootD.ID, "", false)
+		if err != nil {
+			t.Fatal("Error getting upstream nodes: ", err)
+		}
+		if len(nodes) > 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Timeout waiting for sync to upstream")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	if getSync(peers["peer-approved"]).Disable {
+		t.Error("approved sync node is disabled")
+	}
+
+	// a new address for an approved peer must be approved again
+	entries <- &mdns.ServiceEntry{
+		Name:       "peer-approved._siot._tcp.local.",
+		Host:       "peer-approved.local.",
+		AddrV4:     net.ParseIP("127.0.0.2"),
+		Port:       8910,
+		InfoFields: []string{"id=peer-approved", "desc=peer-approved", "sync=1"},
+	}
+
+	err = client.SendNodePoint(ncD, disc.ID, data.Point{Type: data.PointTypeAutoSync,
+		Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start = time.Now()
+	for {
+		sync := getSync(peers["peer-approved"])
+		if sync.URI == "nats://127.0.0.2:8910" {
+			if !sync.Disable {
+				t.Error("sync node not disabled after address change")
+			}
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Timeout waiting for sync URI update")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	rejected, err := client.GetNodes(ncD, rootD.ID, rejectedID, "", true)
+	if err != nil {
+		t.Fatal("Error getting rejected sync node: ", err)
+	}
+
+	if len(rejected) < 1 {
+		t.Fatal("rejected sync node not found")
+	}
+
+	if disable, _ := rejected[0].Points.Value(data.PointTypeDisable, ""); disable != 1 {
+		t.Error("rejected sync node was enabled")
+	}
+}
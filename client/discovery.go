@@ -0,0 +1,394 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/mdns"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// discoveryService is the mDNS service SIOT instances are advertised as
+const discoveryService = "_siot._tcp"
+
+// discoveryQuery runs a mDNS query. It is replaced in tests, as multicast
+// is not available everywhere tests run.
+var discoveryQuery = mdns.Query
+
+// Discovery describes the discovery client config. If Advertise is set,
+// this instance is advertised on the local network using mDNS. If
+// AutoSync is set, a disabled sync node is created for each discovered
+// instance that accepts sync connections. The sync node is enabled once
+// it is approved.
+type Discovery struct {
+	ID          string            `node:"id"`
+	Parent      string            `node:"parent"`
+	Description string            `point:"description"`
+	Disable     bool              `point:"disable"`
+	Advertise   bool              `point:"advertise"`
+	AcceptSync  bool              `point:"acceptSync"`
+	Port        int               `point:"port"`
+	AutoSync    bool              `point:"autoSync"`
+	AuthToken   string            `point:"authToken"`
+	Peers       map[string]string `point:"peer"`
+}
+
+// discoveryPeer is a SIOT instance found on the network
+type discoveryPeer struct {
+	id          string
+	description string
+	uri         string
+	acceptSync  bool
+}
+
+// DiscoveryClient advertises this instance and discovers other SIOT
+// instances on the local network
+type DiscoveryClient struct {
+	nc            *nats.Conn
+	config        Discovery
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	root          data.NodeEdge
+	server        *mdns.Server
+}
+
+// NewDiscoveryClient constructor
+func NewDiscoveryClient(nc *nats.Conn, config Discovery) Client {
+	return &DiscoveryClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// Run runs the main logic for this client and blocks until stopped
+func (dc *DiscoveryClient) Run() error {
+	log.Println("Starting discovery client: ", dc.config.Description)
+
+	var err error
+	dc.root, err = GetRootNode(dc.nc)
+	if err != nil {
+		return fmt.Errorf("Error getting root node: %v", err)
+	}
+
+	entriesCh := make(chan *mdns.ServiceEntry, 4)
+
+	params := mdns.DefaultParams(discoveryService)
+	params.DisableIPv6 = true
+	params.Entries = entriesCh
+
+	scan := func() {
+		if dc.config.Disable || !dc.config.AutoSync {
+			return
+		}
+
+		go func() {
+			err := discoveryQuery(params)
+			if err != nil {
+				log.Println("Discovery mdns error: ", err)
+			}
+		}()
+	}
+
+	dc.advertise()
+	scan()
+
+	scanTicker := time.NewTicker(time.Minute * 1)
+
+done:
+	for {
+		select {
+		case <-dc.stop:
+			log.Println("Stopping discovery client: ", dc.config.Description)
+			break done
+		case pts := <-dc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &dc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeDisable,
+					data.PointTypeAdvertise,
+					data.PointTypeAcceptSync,
+					data.PointTypePort:
+					dc.advertise()
+				case data.PointTypeAutoSync:
+					scan()
+				case data.PointTypePeerApprove:
+					if p.Value != 0 {
+						dc.approve(p.Key)
+					}
+				}
+			}
+
+		case pts := <-dc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &dc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+		case <-scanTicker.C:
+			scan()
+
+		case e := <-entriesCh:
+			peer, ok := discoveryScanEntry(e)
+			if !ok || peer.id == dc.root.ID || !peer.acceptSync ||
+				dc.config.Disable || !dc.config.AutoSync {
+				break
+			}
+
+			dc.peerFound(peer)
+		}
+	}
+
+	// clean up
+	scanTicker.Stop()
+	dc.shutdown()
+
+	return nil
+}
+
+// Stop sends a signal to the Run function to exit
+func (dc *DiscoveryClient) Stop(_ error) {
+	close(dc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (dc *DiscoveryClient) Points(nodeID string, points []data.Point) {
+	dc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (dc *DiscoveryClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	dc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
+
+// advertise (re)starts the mDNS server with the current config
+func (dc *DiscoveryClient) advertise() {
+	dc.shutdown()
+
+	if dc.config.Disable || !dc.config.Advertise {
+		return
+	}
+
+	port := dc.config.Port
+	if port == 0 {
+		port = dc.natsPort()
+	}
+
+	txt := []string{"id=" + dc.root.ID, "desc=" + dc.root.Desc()}
+	if dc.config.AcceptSync {
+		txt = append(txt, "sync=1")
+	}
+
+	service, err := mdns.NewMDNSService(dc.root.ID, discoveryService, "", "",
+		port, nil, txt)
+	if err != nil {
+		log.Println("Discovery: error creating mdns service: ", err)
+		return
+	}
+
+	dc.server, err = mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		log.Println("Discovery: error starting mdns server: ", err)
+		return
+	}
+
+	log.Printf("Discovery: advertising %v on port %v\n", dc.root.Desc(), port)
+}
+
+func (dc *DiscoveryClient) shutdown() {
+	if dc.server == nil {
+		return
+	}
+
+	err := dc.server.Shutdown()
+	if err != nil {
+		log.Println("Discovery: error stopping mdns server: ", err)
+	}
+
+	dc.server = nil
+}
+
+// natsPort returns the port of the local NATS server
+func (dc *DiscoveryClient) natsPort() int {
+	uri, _, err := GetNatsURI(dc.nc)
+	if err != nil {
+		log.Println("Discovery: error getting NATS URI: ", err)
+		return 4222
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		log.Println("Discovery: error parsing NATS URI: ", err)
+		return 4222
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return 4222
+	}
+
+	return port
+}
+
+// peerFound creates a sync node for new peers, and updates the URI of the
+// sync node if the peer address has changed. Sync nodes are created
+// disabled, and must be approved before they connect. An approved sync
+// node is disabled again when the address changes.
+func (dc *DiscoveryClient) peerFound(peer discoveryPeer) {
+	syncID, ok := dc.config.Peers[peer.id]
+	if ok && syncID != "" {
+		nodes, err := GetNodesType[Sync](dc.nc, dc.config.Parent, syncID)
+		if err != nil {
+			log.Println("Discovery: error getting sync node: ", err)
+			return
+		}
+
+		// if the sync node was deleted, the peer was rejected
+		if len(nodes) < 1 || nodes[0].URI == peer.uri {
+			return
+		}
+
+		pts := data.Points{{
+			Type:   data.PointTypeURI,
+			Text:   peer.uri,
+			Origin: dc.config.ID,
+		}}
+
+		// anyone on the network can announce a known peer ID, so an
+		// approved sync must be approved again before it connects to the
+		// new address
+		if !nodes[0].Disable {
+			log.Printf("Discovery: %v moved to %v, sync disabled until approved\n",
+				peer.description, peer.uri)
+			pts = append(pts, data.Point{
+				Type:   data.PointTypeDisable,
+				Value:  1,
+				Origin: dc.config.ID,
+			})
+		}
+
+		err = SendNodePoints(dc.nc, syncID, pts, false)
+		if err != nil {
+			log.Println("Discovery: error updating sync URI: ", err)
+		}
+
+		return
+	}
+
+	sync := Sync{
+		ID:          uuid.New().String(),
+		Parent:      dc.config.Parent,
+		Description: peer.description + " (discovered)",
+		URI:         peer.uri,
+		AuthToken:   dc.config.AuthToken,
+		Disable:     true,
+	}
+
+	log.Printf("Discovery: found %v at %v, creating sync\n", peer.description, peer.uri)
+
+	err := SendNodeType(dc.nc, sync, dc.config.ID)
+	if err != nil {
+		log.Println("Discovery: error creating sync node: ", err)
+		return
+	}
+
+	if dc.config.Peers == nil {
+		dc.config.Peers = make(map[string]string)
+	}
+	dc.config.Peers[peer.id] = sync.ID
+
+	err = SendNodePoint(dc.nc, dc.config.ID, data.Point{
+		Type: data.PointTypePeer,
+		Key:  peer.id,
+		Text: sync.ID,
+	}, false)
+	if err != nil {
+		log.Println("Discovery: error sending peer point: ", err)
+	}
+}
+
+// approve enables the sync node for a peer
+func (dc *DiscoveryClient) approve(peerID string) {
+	syncID := dc.config.Peers[peerID]
+	if syncID == "" {
+		log.Println("Discovery: approve, unknown peer: ", peerID)
+		return
+	}
+
+	nodes, err := GetNodesType[Sync](dc.nc, dc.config.Parent, syncID)
+	if err != nil {
+		log.Println("Discovery: error getting sync node: ", err)
+		return
+	}
+
+	// if the sync node was deleted, the peer was rejected
+	if len(nodes) < 1 {
+		log.Println("Discovery: approve, sync node was deleted for peer: ", peerID)
+		return
+	}
+
+	err = SendNodePoint(dc.nc, syncID, data.Point{
+		Type:   data.PointTypeDisable,
+		Value:  0,
+		Origin: dc.config.ID,
+	}, false)
+	if err != nil {
+		log.Println("Discovery: error enabling sync node: ", err)
+	}
+}
+
+// discoveryScanEntry extracts the peer info from a mDNS entry
+func discoveryScanEntry(e *mdns.ServiceEntry) (discoveryPeer, bool) {
+	if !strings.Contains(e.Name, "."+discoveryService+".") {
+		return discoveryPeer{}, false
+	}
+
+	var peer discoveryPeer
+
+	for _, f := range e.InfoFields {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "id":
+			peer.id = v
+		case "desc":
+			peer.description = v
+		case "sync":
+			peer.acceptSync = v == "1"
+		}
+	}
+
+	var ip net.IP
+	if e.AddrV4 != nil {
+		ip = e.AddrV4
+	} else if e.AddrV6 != nil {
+		ip = e.AddrV6
+	}
+
+	if peer.id == "" || ip == nil || e.Port == 0 {
+		return discoveryPeer{}, false
+	}
+
+	if peer.description == "" {
+		peer.description = e.Host
+	}
+
+	peer.uri = "nats://" + net.JoinHostPort(ip.String(), strconv.Itoa(e.Port))
+
+	return peer, true
+}
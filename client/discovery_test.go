@@ -0,0 +1,65 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hashicorp/mdns"
+)
+
+func TestDiscoveryScanEntry(t *testing.T) {
+	tests := []struct {
+		entry mdns.ServiceEntry
+		peer  discoveryPeer
+		ok    bool
+	}{
+		{mdns.ServiceEntry{
+			Name:       "abc._siot._tcp.local.",
+			Host:       "site.local.",
+			AddrV4:     net.ParseIP("192.168.1.10"),
+			Port:       4222,
+			InfoFields: []string{"id=abc", "desc=Site server", "sync=1"},
+		}, discoveryPeer{
+			id:          "abc",
+			description: "Site server",
+			uri:         "nats://192.168.1.10:4222",
+			acceptSync:  true,
+		}, true},
+		{mdns.ServiceEntry{
+			Name:       "def._siot._tcp.local.",
+			Host:       "gateway.local.",
+			AddrV4:     net.ParseIP("192.168.1.11"),
+			Port:       4223,
+			InfoFields: []string{"id=def"},
+		}, discoveryPeer{
+			id:          "def",
+			description: "gateway.local.",
+			uri:         "nats://192.168.1.11:4223",
+		}, true},
+		// other service
+		{mdns.ServiceEntry{
+			Name:       "shelly1pm-B91754._http._tcp.local.",
+			AddrV4:     net.ParseIP("192.168.1.12"),
+			Port:       80,
+			InfoFields: []string{"id=shelly"},
+		}, discoveryPeer{}, false},
+		// missing ID
+		{mdns.ServiceEntry{
+			Name:   "ghi._siot._tcp.local.",
+			AddrV4: net.ParseIP("192.168.1.13"),
+			Port:   4222,
+		}, discoveryPeer{}, false},
+	}
+
+	for _, test := range tests {
+		e := test.entry
+		peer, ok := discoveryScanEntry(&e)
+		if ok != test.ok {
+			t.Errorf("%v: exp ok %v, got %v", e.Name, test.ok, ok)
+		}
+
+		if peer != test.peer {
+			t.Errorf("%v: exp %+v, got %+v", e.Name, test.peer, peer)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Edge connections can be authenticated with (in addition to the auth
+// token) a client certificate (mTLS), an NKey seed, or a NATS credentials
+// file (user JWT + NKey seed). Certificates and keys are PEM encoded and
+// stored as text so they can be kept in node points.
+
+// edgeTLSConfig returns a TLS config with the client certificate and CA
+// to verify the server, or nil if neither is set.
+func edgeTLSConfig(certPEM, keyPEM, caPEM string) (*tls.Config, error) {
+	if certPEM == "" && keyPEM == "" && caPEM == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certPEM != "" || keyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, errors.New("error loading CA certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// CertExpiry returns the expiration time of the first certificate in a
+// PEM encoded certificate chain
+func CertExpiry(certPEM string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return time.Time{}, errors.New("no PEM data found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
+
+// edgeAuthOptions returns the NATS options for the TLS and NKey/JWT
+// settings in the edge options
+func edgeAuthOptions(eo EdgeOptions) ([]nats.Option, error) {
+	var ret []nats.Option
+
+	tlsConfig, err := edgeTLSConfig(eo.TLSCert, eo.TLSKey, eo.TLSCA)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		ret = append(ret, nats.Secure(tlsConfig))
+	}
+
+	switch {
+	case eo.Creds != "":
+		jwt, err := nkeys.ParseDecoratedJWT([]byte(eo.Creds))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing creds JWT: %w", err)
+		}
+
+		kp, err := nkeys.ParseDecoratedUserNKey([]byte(eo.Creds))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing creds NKey: %w", err)
+		}
+
+		seed, err := kp.Seed()
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, nats.UserJWTAndSeed(jwt, string(seed)))
+
+	case eo.NKeySeed != "":
+		kp, err := nkeys.FromSeed([]byte(eo.NKeySeed))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing NKey seed: %w", err)
+		}
+
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, nats.Nkey(pub, kp.Sign))
+	}
+
+	return ret, nil
+}
@@ -9,10 +9,17 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-// EdgeOptions describes options for connecting edge devices
+// EdgeOptions describes options for connecting edge devices. TLSCert,
+// TLSKey, and TLSCA are PEM encoded. Creds is the contents of a NATS
+// credentials file (see edge-auth.go).
 type EdgeOptions struct {
 	URI          string
 	AuthToken    string
+	TLSCert      string
+	TLSKey       string
+	TLSCA        string
+	NKeySeed     string
+	Creds        string
 	NoEcho       bool
 	Connected    func()
 	Disconnected func()
@@ -25,7 +32,8 @@ type EdgeOptions struct {
 // and then exp backup to try to connect every 6m after that.
 func EdgeConnect(eo EdgeOptions) (*nats.Conn, error) {
 	authEnabled := "no"
-	if eo.AuthToken != "" {
+	if eo.AuthToken != "" || eo.TLSCert != "" || eo.NKeySeed != "" ||
+		eo.Creds != "" {
 		authEnabled = "yes"
 	}
 
@@ -81,8 +89,13 @@ func EdgeConnect(eo EdgeOptions) (*nats.Conn, error) {
 		return nil, err
 	}
 
+	authOptions, err := edgeAuthOptions(eo)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Printf("NATS edge connect to: %v, auth enabled: %v", uri, authEnabled)
-	nc, err := nats.Connect(uri, siotOptions)
+	nc, err := nats.Connect(uri, append([]nats.Option{siotOptions}, authOptions...)...)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,14 @@
+package client
+
+import "github.com/hashicorp/mdns"
+
+// SetDiscoveryQuery replaces the mDNS query used by the discovery client
+// and returns a function that restores it. It must be called before the
+// discovery client is started.
+func SetDiscoveryQuery(query func(*mdns.QueryParam) error) func() {
+	orig := discoveryQuery
+	discoveryQuery = query
+	return func() {
+		discoveryQuery = orig
+	}
+}
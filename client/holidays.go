@@ -0,0 +1,71 @@
+package client
+
+import (
+	"log"
+)
+
+// Holidays represents a list of dates that can be shared across rules
+// to exclude schedule conditions. Dates are formatted as 2023-12-25 or
+// as a range: 2023-12-24/2023-12-26.
+type Holidays struct {
+	ID          string   `node:"id"`
+	Parent      string   `node:"parent"`
+	Description string   `point:"description"`
+	Dates       []string `point:"date"`
+}
+
+type holidayWatcher struct {
+	get  func() Holidays
+	stop func()
+}
+
+// updateHolidayWatchers makes sure we are watching all holiday nodes
+// referenced by rule conditions, and stops watching ones that are no
+// longer used.
+func (rc *RuleClient) updateHolidayWatchers() {
+	used := make(map[string]bool)
+
+	for _, c := range rc.config.Conditions {
+		if c.HolidayNodeID == "" {
+			continue
+		}
+
+		used[c.HolidayNodeID] = true
+
+		if _, ok := rc.holidays[c.HolidayNodeID]; ok {
+			continue
+		}
+
+		get, stop, err := NodeWatcher[Holidays](rc.nc, c.HolidayNodeID, "all")
+		if err != nil {
+			log.Println("Rule error watching holiday node: ", err)
+			continue
+		}
+
+		rc.holidays[c.HolidayNodeID] = holidayWatcher{get: get, stop: stop}
+	}
+
+	for id, w := range rc.holidays {
+		if !used[id] {
+			w.stop()
+			delete(rc.holidays, id)
+		}
+	}
+}
+
+func (rc *RuleClient) stopHolidayWatchers() {
+	for id, w := range rc.holidays {
+		w.stop()
+		delete(rc.holidays, id)
+	}
+}
+
+// holidayDates returns the dates for a holiday node
+func (rc *RuleClient) holidayDates(id string) ([]dateRange, error) {
+	w, ok := rc.holidays[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return parseDateRanges(w.get().Dates)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// actionTemplateData is passed to the templates used in webhook and
+// publish actions. Templates can reference fields such as {{.NodeDesc}}
+// or look up point values in the trigger node with {{.Value "value"}}.
+type actionTemplateData struct {
+	RuleID   string      `json:"ruleID"`
+	RuleDesc string      `json:"ruleDesc"`
+	NodeID   string      `json:"nodeID"`
+	NodeDesc string      `json:"nodeDesc"`
+	Time     time.Time   `json:"time"`
+	Points   data.Points `json:"points"`
+}
+
+// Value returns the value of the point type in the trigger node
+func (d actionTemplateData) Value(typ string) float64 {
+	v, _ := d.Points.Value(typ, "")
+	return v
+}
+
+// Text returns the text of the point type in the trigger node
+func (d actionTemplateData) Text(typ string) string {
+	v, _ := d.Points.Text(typ, "")
+	return v
+}
+
+// templateData fetches the node that triggered the rule and
+// populates the data used to render action templates.
+func (rc *RuleClient) templateData(triggerNodeID string) (actionTemplateData, error) {
+	nodes, err := GetNodes(rc.nc, "all", triggerNodeID, "", false)
+	if err != nil {
+		return actionTemplateData{}, err
+	}
+
+	if len(nodes) < 1 {
+		return actionTemplateData{}, errors.New("trigger node not found")
+	}
+
+	return actionTemplateData{
+		RuleID:   rc.config.ID,
+		RuleDesc: rc.config.Description,
+		NodeID:   nodes[0].ID,
+		NodeDesc: nodes[0].Desc(),
+		Time:     time.Now(),
+		Points:   nodes[0].Points,
+	}, nil
+}
+
+// actionTemplateFuncs are the functions available in action templates.
+// json encodes a value as JSON so text can be safely used in a JSON body,
+// for example {"desc": {{json .NodeDesc}}}.
+var actionTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// render executes a template against the action data. If the template
+// is blank, the data is encoded as JSON.
+func (d actionTemplateData) render(name, tmpl string) ([]byte, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		return json.Marshal(d)
+	}
+
+	t, err := template.New(name).Funcs(actionTemplateFuncs).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v template: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, d)
+	if err != nil {
+		return nil, fmt.Errorf("error executing %v template: %w", name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookMaxBackoff is the max time between webhook retries
+const webhookMaxBackoff = time.Minute
+
+// runWebhook sends an HTTP request for a webhook action and retries with
+// backoff on failure. This function blocks until the request succeeds or
+// retries are exhausted, so it should typically be run in a goroutine.
+func runWebhook(a Action, body []byte) error {
+	method := a.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	var err error
+
+	for attempt := 0; attempt <= a.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ExpBackoff(attempt-1, webhookMaxBackoff))
+		}
+
+		err = sendWebhook(method, a.URI, a.Headers, body)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func sendWebhook(method, uri string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// drain body so connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v returned status: %v", uri, resp.Status)
+	}
+
+	return nil
+}
+
+// runActionWebhook renders the webhook body and sends it in the background
+func (rc *RuleClient) runActionWebhook(a Action, triggerNodeID string) error {
+	if a.URI == "" {
+		return fmt.Errorf("webhook action %v: uri must be set", a.ID)
+	}
+
+	d, err := rc.templateData(triggerNodeID)
+	if err != nil {
+		return err
+	}
+
+	body, err := d.render("body", a.Body)
+	if err != nil {
+		return err
+	}
+
+	// copy headers as the config may be modified while the request runs
+	headers := make(map[string]string, len(a.Headers))
+	for k, v := range a.Headers {
+		headers[k] = v
+	}
+	a.Headers = headers
+
+	desc := rc.config.Description
+
+	go func() {
+		err := runWebhook(a, body)
+		if err != nil {
+			log.Printf("Rule %v webhook error: %v\n", desc, err)
+		}
+	}()
+
+	return nil
+}
+
+// runActionPublish renders the subject and message and publishes to NATS
+func (rc *RuleClient) runActionPublish(a Action, triggerNodeID string) error {
+	if a.Subject == "" {
+		return fmt.Errorf("publish action %v: subject must be set", a.ID)
+	}
+
+	d, err := rc.templateData(triggerNodeID)
+	if err != nil {
+		return err
+	}
+
+	subject, err := d.render("subject", a.Subject)
+	if err != nil {
+		return err
+	}
+
+	msg, err := d.render("body", a.Body)
+	if err != nil {
+		return err
+	}
+
+	return rc.nc.Publish(string(subject), msg)
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestActionTemplateJSON(t *testing.T) {
+	d := actionTemplateData{
+		RuleDesc: "rule",
+		NodeDesc: "tank \"A\"\nlevel",
+		Points: data.Points{
+			{Type: data.PointTypeValue, Value: 2.5},
+			{Type: data.PointTypeDescription, Text: `back\slash`},
+		},
+	}
+
+	body, err := d.render("body",
+		`{"desc":{{json .NodeDesc}},"text":{{json (.Text "description")}},"value":{{.Value "value"}}}`)
+	if err != nil {
+		t.Fatal("Error rendering: ", err)
+	}
+
+	var v struct {
+		Desc  string
+		Text  string
+		Value float64
+	}
+
+	err = json.Unmarshal(body, &v)
+	if err != nil {
+		t.Fatalf("Error decoding body: %v: %s", err, body)
+	}
+
+	if v.Desc != d.NodeDesc || v.Text != `back\slash` || v.Value != 2.5 {
+		t.Errorf("Body is not correct: %s", body)
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// The alarm workflow is enabled by setting the alarm point on a rule. The
+// alarm state is tracked in points on the rule node so that it is
+// persisted and synced like any other node state:
+//
+//   - unacknowledged: the rule went active and nobody has acknowledged it
+//     yet. If the rule goes inactive, it stays unacknowledged until acked.
+//   - acknowledged: the rule is active and has been acknowledged
+//   - cleared: the rule is inactive and has been acknowledged
+//   - shelved: notifications are suppressed until shelvedUntil
+//
+// The ack, shelve, and unshelve points are commands sent to the rule node
+// (typically from the UI). The user that acknowledged the alarm is taken
+// from the point origin, or the point text if origin is not set.
+
+func unixToTime(v float64) time.Time {
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+func timeToUnix(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// setAlarmState sends the alarm state and any extra points to the rule
+// node and updates the local config. The points are sent in one message
+// so the state is never seen without the points that go with it.
+func (rc *RuleClient) setAlarmState(state string, now time.Time, extra ...data.Point) {
+	pts := append(data.Points{{Type: data.PointTypeAlarmState, Text: state}}, extra...)
+
+	for i := range pts {
+		pts[i].Time = now
+	}
+
+	if !rc.simulate {
+		err := SendNodePoints(rc.nc, rc.config.ID, pts, false)
+		if err != nil {
+			log.Println("Rule error sending alarm points: ", err)
+		}
+	}
+
+	err := data.MergePoints(rc.config.ID, pts, &rc.config)
+	if err != nil {
+		log.Println("Rule error merging alarm points: ", err)
+	}
+}
+
+// alarmShelved returns true if alarm notifications are currently
+// suppressed
+func (rc *RuleClient) alarmShelved(now time.Time) bool {
+	return rc.config.Alarm &&
+		rc.config.AlarmState == data.PointValueAlarmShelved &&
+		now.Before(unixToTime(rc.config.ShelvedUntil))
+}
+
+// alarmRuleChanged updates the alarm state when the rule active state
+// changes
+func (rc *RuleClient) alarmRuleChanged(active bool, now time.Time) {
+	if !rc.config.Alarm {
+		return
+	}
+
+	if active {
+		if rc.alarmShelved(now) {
+			return
+		}
+
+		rc.lastNotify = now
+		rc.setAlarmState(data.PointValueAlarmUnacknowledged, now,
+			data.Point{Type: data.PointTypeAckBy},
+			data.Point{Type: data.PointTypeAckTime})
+		return
+	}
+
+	if rc.config.AlarmState == data.PointValueAlarmAcknowledged {
+		rc.setAlarmState(data.PointValueAlarmCleared, now)
+	}
+}
+
+// alarmCommand processes ack, shelve, and unshelve points sent to the rule
+func (rc *RuleClient) alarmCommand(p data.Point, now time.Time) {
+	if !rc.config.Alarm || p.Value == 0 {
+		return
+	}
+
+	switch p.Type {
+	case data.PointTypeAck:
+		if rc.config.AlarmState != data.PointValueAlarmUnacknowledged {
+			return
+		}
+
+		by := p.Origin
+		if by == "" {
+			by = p.Text
+		}
+
+		state := data.PointValueAlarmCleared
+		if rc.config.Active {
+			state = data.PointValueAlarmAcknowledged
+		}
+
+		rc.setAlarmState(state, now,
+			data.Point{Type: data.PointTypeAckBy, Text: by},
+			data.Point{Type: data.PointTypeAckTime, Value: timeToUnix(now)})
+
+	case data.PointTypeShelve:
+		if p.Value < 0 {
+			return
+		}
+
+		until := now.Add(time.Duration(p.Value * float64(time.Minute)))
+		rc.setAlarmState(data.PointValueAlarmShelved, now,
+			data.Point{Type: data.PointTypeShelvedUntil, Value: timeToUnix(until)})
+
+	case data.PointTypeUnshelve:
+		rc.alarmUnshelve(now)
+	}
+}
+
+// alarmUnshelve ends shelving. If the rule is still active, the alarm is
+// raised again.
+func (rc *RuleClient) alarmUnshelve(now time.Time) {
+	if rc.config.AlarmState != data.PointValueAlarmShelved {
+		return
+	}
+
+	if !rc.config.Active {
+		rc.setAlarmState(data.PointValueAlarmCleared, now,
+			data.Point{Type: data.PointTypeShelvedUntil})
+		return
+	}
+
+	rc.setAlarmState(data.PointValueAlarmUnacknowledged, now,
+		data.Point{Type: data.PointTypeShelvedUntil},
+		data.Point{Type: data.PointTypeAckBy},
+		data.Point{Type: data.PointTypeAckTime})
+
+	rc.alarmNotify(now)
+}
+
+// alarmNotify runs the notification actions of the rule
+func (rc *RuleClient) alarmNotify(now time.Time) {
+	rc.lastNotify = now
+
+	var actions []Action
+	for _, a := range rc.config.Actions {
+		if a.Action == data.PointValueNotify {
+			actions = append(actions, a)
+		}
+	}
+
+	err := rc.ruleRunActions(actions, rc.config.ID)
+	if err != nil {
+		log.Println("Error running rule notify actions: ", err)
+	}
+}
+
+func (rc *RuleClient) renotifyTime() (time.Time, bool) {
+	if rc.config.AlarmState != data.PointValueAlarmUnacknowledged ||
+		!rc.config.Active || rc.config.Renotify <= 0 {
+		return time.Time{}, false
+	}
+
+	return rc.lastNotify.Add(time.Duration(rc.config.Renotify * float64(time.Minute))), true
+}
+
+// alarmDeadline returns the next time the alarm needs to be processed
+// (shelve expiring or re-notification)
+func (rc *RuleClient) alarmDeadline() (time.Time, bool) {
+	if !rc.config.Alarm {
+		return time.Time{}, false
+	}
+
+	if rc.config.AlarmState == data.PointValueAlarmShelved {
+		return unixToTime(rc.config.ShelvedUntil), true
+	}
+
+	return rc.renotifyTime()
+}
+
+// alarmTimeout handles shelve expiration and re-notification
+func (rc *RuleClient) alarmTimeout(now time.Time) {
+	if !rc.config.Alarm {
+		return
+	}
+
+	if rc.config.AlarmState == data.PointValueAlarmShelved {
+		if !now.Before(unixToTime(rc.config.ShelvedUntil)) {
+			rc.alarmUnshelve(now)
+		}
+		return
+	}
+
+	if t, ok := rc.renotifyTime(); ok && !now.Before(t) {
+		rc.alarmNotify(now)
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"math"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// conditionState holds run time state for a rule condition that is not
+// stored in points.
+type conditionState struct {
+	// raw is the condition state before min active/inactive
+	// debouncing is applied
+	raw       bool
+	rawChange time.Time
+
+	// last matching point, used for rate of change calculations
+	lastValue float64
+	lastTime  time.Time
+
+	// time we last received a matching point, used for stale detection
+	lastUpdate time.Time
+
+	// matching points in the sliding window, used for window conditions
+	window []data.Point
+
+	// used by cron and sun conditions. created is the start time for
+	// occurrences if the condition has never run.
+	created     time.Time
+	activeUntil time.Time
+	lastErr     string
+	occurrence  occurrenceCache
+}
+
+// newConditionState creates the run time state for a condition. The raw
+// state starts at the persisted active state, so a condition that was
+// active before the rule client started (or the condition was changed)
+// stays active until it is evaluated. This is also the hysteresis state.
+func newConditionState(active bool, now time.Time) *conditionState {
+	return &conditionState{
+		raw:        active,
+		rawChange:  now,
+		lastUpdate: now,
+		created:    now,
+	}
+}
+
+func (cs *conditionState) setRaw(raw bool, now time.Time) {
+	if raw != cs.raw {
+		cs.raw = raw
+		cs.rawChange = now
+	}
+}
+
+// debounce returns the condition state after the min active and min
+// inactive times have been applied to the raw state.
+func (cs *conditionState) debounce(c Condition, now time.Time) bool {
+	if cs.raw == c.Active {
+		return c.Active
+	}
+
+	if now.Sub(cs.rawChange) < c.debounceTime(cs.raw) {
+		return c.Active
+	}
+
+	return cs.raw
+}
+
+// deadline returns the next time this condition needs to be evaluated
+// even if no new points arrive. Returns false if no evaluation is needed.
+func (cs *conditionState) deadline(c Condition) (time.Time, bool) {
+	var ret time.Time
+	found := false
+
+	check := func(t time.Time) {
+		if !found || t.Before(ret) {
+			ret = t
+			found = true
+		}
+	}
+
+	if cs.raw != c.Active {
+		if d := c.debounceTime(cs.raw); d > 0 {
+			check(cs.rawChange.Add(d))
+		}
+	}
+
+	if c.ConditionType == data.PointValueStale && !cs.raw && c.StaleTimeout > 0 {
+		check(cs.lastUpdate.Add(c.staleTimeout()))
+	}
+
+	// window aggregates change as points expire out of the window
+	if c.ConditionType == data.PointValueWindow {
+		for _, p := range cs.window {
+			check(p.Time.Add(c.windowLength()))
+		}
+	}
+
+	return ret, found
+}
+
+// debounceTime returns how long the raw state must be stable before the
+// condition transitions to that state.
+func (c Condition) debounceTime(raw bool) time.Duration {
+	if raw {
+		return time.Duration(c.MinActive * float64(time.Minute))
+	}
+	return time.Duration(c.MinInactive * float64(time.Minute))
+}
+
+func (c Condition) windowLength() time.Duration {
+	return time.Duration(c.WindowLength * float64(time.Minute))
+}
+
+func (c Condition) staleTimeout() time.Duration {
+	return time.Duration(c.StaleTimeout * float64(time.Second))
+}
+
+// matchPoint returns true if the point matches the node ID, key, and
+// type qualifiers in the condition
+func (c Condition) matchPoint(nodeID string, p data.Point) bool {
+	if c.NodeID != "" && c.NodeID != nodeID {
+		return false
+	}
+
+	if c.PointKey != "" && c.PointKey != p.Key {
+		return false
+	}
+
+	if c.PointType != "" && c.PointType != p.Type {
+		return false
+	}
+
+	return true
+}
+
+// compareNumber applies the condition operator to a numeric value. active
+// is the current state and is used by the hysteresis operators.
+func (c Condition) compareNumber(v float64, active bool) bool {
+	switch c.Operator {
+	case data.PointValueGreaterThan:
+		return v > c.Value
+	case data.PointValueLessThan:
+		return v < c.Value
+	case data.PointValueEqual:
+		return v == c.Value
+	case data.PointValueNotEqual:
+		return v != c.Value
+	case data.PointValueHysteresisHigh:
+		if active {
+			return v >= c.ValueClear
+		}
+		return v > c.Value
+	case data.PointValueHysteresisLow:
+		if active {
+			return v <= c.ValueClear
+		}
+		return v < c.Value
+	}
+
+	return false
+}
+
+// rateOfChange returns the magnitude of the rate of change in units per
+// minute between the last point and p. Returns false if there is not
+// enough history to calculate a rate.
+func (cs *conditionState) rateOfChange(p data.Point) (float64, bool) {
+	defer func() {
+		cs.lastValue = p.Value
+		cs.lastTime = p.Time
+	}()
+
+	if cs.lastTime.IsZero() {
+		return 0, false
+	}
+
+	dt := p.Time.Sub(cs.lastTime).Minutes()
+	if dt <= 0 {
+		return 0, false
+	}
+
+	return math.Abs(p.Value-cs.lastValue) / dt, true
+}
+
+// windowAggregate adds p (if not nil) to the sliding window, removes points
+// that have expired out of the window, and returns the aggregate value
+// configured in the condition. Returns false if there are not any points
+// in the window to calculate the aggregate.
+func (cs *conditionState) windowAggregate(c Condition, p *data.Point, now time.Time) (float64, bool) {
+	if p != nil {
+		pt := *p
+		if pt.Time.IsZero() {
+			pt.Time = now
+		}
+		cs.window = append(cs.window, pt)
+	}
+
+	windowLen := c.windowLength()
+	pa := data.NewPointAverager(c.PointType)
+
+	w := cs.window[:0]
+	for _, pt := range cs.window {
+		if now.Sub(pt.Time) >= windowLen {
+			continue
+		}
+		w = append(w, pt)
+		pa.AddPoint(pt)
+	}
+	cs.window = w
+
+	if c.Aggregate == data.PointValueCount {
+		return float64(pa.GetCount()), true
+	}
+
+	if pa.GetCount() <= 0 {
+		return 0, false
+	}
+
+	switch c.Aggregate {
+	case data.PointValueMin:
+		return pa.GetMin().Value, true
+	case data.PointValueMax:
+		return pa.GetMax().Value, true
+	default:
+		return pa.GetAverage().Value, true
+	}
+}
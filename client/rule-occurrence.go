@@ -0,0 +1,275 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// occurrenceGrace is how far in the past we look for occurrences that have
+// not fired yet. This allows an occurrence that happens while the rule
+// client is restarting to still fire.
+const occurrenceGrace = 5 * time.Minute
+
+// Position is used to read a location from a node for sun conditions. A
+// GPS client can populate these points from data.GpsPos.
+type Position struct {
+	ID        string  `node:"id"`
+	Parent    string  `node:"parent"`
+	Latitude  float64 `point:"latitude"`
+	Longitude float64 `point:"longitude"`
+}
+
+type positionWatcher struct {
+	get  func() Position
+	stop func()
+}
+
+// updatePositionWatchers makes sure we are watching nodes that provide
+// the position for sun conditions, and stops watching ones that are no
+// longer used.
+func (rc *RuleClient) updatePositionWatchers() {
+	used := make(map[string]bool)
+
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType != data.PointValueSun || c.hasPosition() ||
+			c.NodeID == "" {
+			continue
+		}
+
+		used[c.NodeID] = true
+
+		if _, ok := rc.positions[c.NodeID]; ok {
+			continue
+		}
+
+		get, stop, err := NodeWatcher[Position](rc.nc, c.NodeID, "all")
+		if err != nil {
+			log.Println("Rule error watching position node: ", err)
+			continue
+		}
+
+		rc.positions[c.NodeID] = positionWatcher{get: get, stop: stop}
+	}
+
+	for id, w := range rc.positions {
+		if !used[id] {
+			w.stop()
+			delete(rc.positions, id)
+		}
+	}
+}
+
+func (rc *RuleClient) stopPositionWatchers() {
+	for id, w := range rc.positions {
+		w.stop()
+		delete(rc.positions, id)
+	}
+}
+
+func (c Condition) hasPosition() bool {
+	return c.Latitude != 0 || c.Longitude != 0
+}
+
+// conditionPosition returns the position used for a sun condition
+func (rc *RuleClient) conditionPosition(c Condition) (data.GpsPos, error) {
+	if c.hasPosition() {
+		return data.GpsPos{Lat: c.Latitude, Long: c.Longitude}, nil
+	}
+
+	w, ok := rc.positions[c.NodeID]
+	if !ok {
+		return data.GpsPos{}, errors.New("latitude/longitude or position node must be set")
+	}
+
+	p := w.get()
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return data.GpsPos{}, errors.New("position node does not have a position")
+	}
+
+	return data.GpsPos{Lat: p.Latitude, Long: p.Longitude}, nil
+}
+
+// occurrenceKey is the condition configuration that determines when the
+// next occurrence is
+type occurrenceKey struct {
+	conditionType string
+	cron          string
+	timeZone      string
+	sunEvent      string
+	offset        float64
+	pos           data.GpsPos
+}
+
+// occurrenceCache holds the last next occurrence calculated for a
+// condition. Deadlines are calculated for every point the rule receives,
+// and loading the location and calculating a cron or sun time is too
+// expensive to do that often.
+type occurrenceCache struct {
+	valid bool
+	key   occurrenceKey
+	base  time.Time
+	next  time.Time
+	err   error
+
+	loc     *time.Location
+	locZone string
+}
+
+// nextOccurrence returns the first occurrence of a cron or sun condition
+// after t. The result is cached and only recalculated when the schedule
+// points or position of the condition change, or t moves past the cached
+// occurrence.
+func (rc *RuleClient) nextOccurrence(c Condition, cs *conditionState, t time.Time) (time.Time, error) {
+	key := occurrenceKey{
+		conditionType: c.ConditionType,
+		cron:          c.Cron,
+		timeZone:      c.TimeZone,
+	}
+
+	switch c.ConditionType {
+	case data.PointValueCron:
+	case data.PointValueSun:
+		pos, err := rc.conditionPosition(c)
+		if err != nil {
+			return time.Time{}, err
+		}
+		key.sunEvent = c.SunEvent
+		key.offset = c.Offset
+		key.pos = pos
+	default:
+		return time.Time{}, errors.New("not an occurrence condition")
+	}
+
+	o := &cs.occurrence
+	if o.valid && o.key == key &&
+		(o.err != nil || (!t.Before(o.base) && t.Before(o.next))) {
+		return o.next, o.err
+	}
+
+	o.valid = true
+	o.key = key
+	o.base = t
+	o.next, o.err = o.calc(key, t)
+
+	return o.next, o.err
+}
+
+func (o *occurrenceCache) calc(key occurrenceKey, t time.Time) (time.Time, error) {
+	if o.loc == nil || o.locZone != key.timeZone {
+		loc, err := scheduleLocation(key.timeZone)
+		if err != nil {
+			return time.Time{}, err
+		}
+		o.loc = loc
+		o.locZone = key.timeZone
+	}
+
+	t = t.In(o.loc)
+
+	if key.conditionType == data.PointValueCron {
+		spec, err := parseCron(key.cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return spec.next(t)
+	}
+
+	offset := time.Duration(key.offset * float64(time.Minute))
+	return nextSunEvent(key.sunEvent, offset, key.pos, t)
+}
+
+// occurrenceBase returns the time after which we look for the next
+// occurrence. This is the last occurrence that fired (persisted in the
+// lastRun point so we don't fire twice across restarts), but we never
+// look further back than occurrenceGrace so that occurrences missed while
+// the system was down don't all fire at once.
+func (cs *conditionState) occurrenceBase(c Condition, now time.Time) time.Time {
+	base := cs.created
+	if c.LastRun > 0 {
+		base = unixToTime(c.LastRun)
+	}
+
+	if oldest := now.Add(-occurrenceGrace); base.Before(oldest) {
+		base = oldest
+	}
+
+	return base
+}
+
+func (c Condition) activeTime() time.Duration {
+	return time.Duration(c.ActiveTime * float64(time.Minute))
+}
+
+// evalOccurrence updates the raw state for a cron or sun condition. If an
+// occurrence is due, the condition fires, c.LastRun is updated, and true
+// is returned.
+func (rc *RuleClient) evalOccurrence(c *Condition, cs *conditionState, now time.Time) (bool, error) {
+	next, err := rc.nextOccurrence(*c, cs, cs.occurrenceBase(*c, now))
+	if err != nil {
+		cs.setRaw(now.Before(cs.activeUntil), now)
+		return false, err
+	}
+
+	if next.After(now) {
+		cs.setRaw(now.Before(cs.activeUntil), now)
+		return false, nil
+	}
+
+	// if several occurrences were missed, only fire the latest one
+	for {
+		n, err := rc.nextOccurrence(*c, cs, next)
+		if err != nil || n.After(now) {
+			break
+		}
+		next = n
+	}
+
+	// set raw even if the active time has already expired so that each
+	// occurrence is seen by the rule at least once
+	cs.activeUntil = next.Add(c.activeTime())
+	cs.raw = true
+	cs.rawChange = now
+	c.LastRun = float64(next.Unix())
+
+	return true, nil
+}
+
+// occurrenceDeadline returns the next time a cron or sun condition needs
+// to be evaluated
+func (rc *RuleClient) occurrenceDeadline(c Condition, cs *conditionState, now time.Time) (time.Time, bool) {
+	if c.ConditionType != data.PointValueCron &&
+		c.ConditionType != data.PointValueSun {
+		return time.Time{}, false
+	}
+
+	next, err := rc.nextOccurrence(c, cs, cs.occurrenceBase(c, now))
+	if err != nil {
+		cs.logError(c, err)
+		if cs.raw {
+			return cs.activeUntil, true
+		}
+		return time.Time{}, false
+	}
+
+	// the active time expires before the next occurrence. A momentary
+	// occurrence is cleared on the next evaluation.
+	if cs.raw && cs.activeUntil.Before(next) {
+		return cs.activeUntil, true
+	}
+
+	return next, true
+}
+
+// logError logs condition errors, but only when the error changes so we
+// don't flood the log when a condition is evaluated often.
+func (cs *conditionState) logError(c Condition, err error) {
+	if err.Error() == cs.lastErr {
+		return
+	}
+
+	cs.lastErr = err.Error()
+	log.Printf("Rule condition %v error: %v\n", c.Description, err)
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// sequenceStep is one step of a sequence action. The point is set (if a
+// node ID is specified) and then we wait before running the next step.
+type sequenceStep struct {
+	nodeID    string
+	pointType string
+	value     float64
+	wait      time.Duration
+}
+
+// sequenceSteps extracts the steps from the step arrays in a sequence
+// action. Step arrays that are shorter than the others are filled in with
+// defaults from the action.
+func (a Action) sequenceSteps() []sequenceStep {
+	count := len(a.StepNodeIDs)
+	for _, l := range []int{len(a.StepPointTypes), len(a.StepValues), len(a.StepWaits)} {
+		if l > count {
+			count = l
+		}
+	}
+
+	ret := make([]sequenceStep, count)
+
+	for i := range ret {
+		s := sequenceStep{
+			nodeID:    a.NodeID,
+			pointType: a.PointType,
+		}
+
+		if i < len(a.StepNodeIDs) && a.StepNodeIDs[i] != "" {
+			s.nodeID = a.StepNodeIDs[i]
+		}
+
+		if i < len(a.StepPointTypes) && a.StepPointTypes[i] != "" {
+			s.pointType = a.StepPointTypes[i]
+		}
+
+		if s.pointType == "" {
+			s.pointType = data.PointTypeValue
+		}
+
+		if i < len(a.StepValues) {
+			s.value = a.StepValues[i]
+		}
+
+		if i < len(a.StepWaits) {
+			s.wait = time.Duration(a.StepWaits[i] * float64(time.Second))
+		}
+
+		ret[i] = s
+	}
+
+	return ret
+}
+
+// sequence tracks a running sequence action
+type sequence struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startSequence starts running a sequence action in the background. If
+// the sequence is already running, it is restarted.
+func (rc *RuleClient) startSequence(a Action) {
+	rc.stopSequence(a.ID)
+
+	steps := a.sequenceSteps()
+	if len(steps) <= 0 {
+		log.Println("Rule sequence action has no steps: ", a.ID)
+		return
+	}
+
+	var totalWait time.Duration
+	for _, s := range steps {
+		totalWait += s.wait
+	}
+
+	repeat := a.Repeat
+	if repeat < 0 && totalWait <= 0 {
+		log.Println("Rule sequence action with no wait time cannot repeat forever: ", a.ID)
+		repeat = 1
+	}
+
+	if repeat == 0 {
+		repeat = 1
+	}
+
+	seq := &sequence{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	rc.sequences[a.ID] = seq
+
+	go rc.runSequence(a.ID, steps, repeat, seq)
+}
+
+// stopSequence cancels a running sequence and waits for it to exit
+func (rc *RuleClient) stopSequence(id string) {
+	seq, ok := rc.sequences[id]
+	if !ok {
+		return
+	}
+
+	close(seq.stop)
+	<-seq.done
+	delete(rc.sequences, id)
+}
+
+func (rc *RuleClient) stopSequences() {
+	for id := range rc.sequences {
+		rc.stopSequence(id)
+	}
+}
+
+// runSequence runs the steps of a sequence. If repeat is < 0, the steps
+// are repeated until the sequence is stopped.
+func (rc *RuleClient) runSequence(id string, steps []sequenceStep, repeat int, seq *sequence) {
+	defer close(seq.done)
+
+	sendProgress := func(typ string, value float64) {
+		err := rc.sendPoint(id, data.Point{
+			Time:  time.Now(),
+			Type:  typ,
+			Value: value,
+		})
+		if err != nil {
+			log.Println("Error sending rule sequence progress: ", err)
+		}
+	}
+
+	sendProgress(data.PointTypeSequenceRunning, 1)
+	defer sendProgress(data.PointTypeSequenceRunning, 0)
+
+	for cycle := 0; repeat < 0 || cycle < repeat; cycle++ {
+		sendProgress(data.PointTypeSequenceCycle, float64(cycle))
+
+		for i, s := range steps {
+			sendProgress(data.PointTypeSequenceStep, float64(i))
+
+			if s.nodeID != "" {
+				err := rc.sendPoint(s.nodeID, data.Point{
+					Time:  time.Now(),
+					Type:  s.pointType,
+					Value: s.value,
+				})
+				if err != nil {
+					log.Println("Error sending rule sequence point: ", err)
+				}
+			}
+
+			if s.wait <= 0 {
+				select {
+				case <-seq.stop:
+					return
+				default:
+				}
+				continue
+			}
+
+			select {
+			case <-seq.stop:
+				return
+			case <-time.After(s.wait):
+			}
+		}
+	}
+}
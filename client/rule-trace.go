@@ -0,0 +1,227 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// RuleTrace describes the evaluation of a rule. It is published to
+// SubjectRuleTrace when the rule debug point is set, and returned by
+// rule simulation requests.
+type RuleTrace struct {
+	RuleID string    `json:"ruleID"`
+	Time   time.Time `json:"time"`
+	// NodeID is the node that sent the points that triggered the evaluation
+	NodeID     string           `json:"nodeID"`
+	Points     data.Points      `json:"points"`
+	Conditions []ConditionTrace `json:"conditions"`
+	Active     bool             `json:"active"`
+	Changed    bool             `json:"changed"`
+	// Actions that ran (or would run for a simulation) as a result of
+	// the evaluation
+	Actions []ActionTrace `json:"actions"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// ConditionTrace describes the evaluation of a rule condition
+type ConditionTrace struct {
+	ID            string `json:"id"`
+	Description   string `json:"description"`
+	ConditionType string `json:"conditionType"`
+	// Evaluated is true if a point was used to evaluate the condition
+	Evaluated bool `json:"evaluated"`
+	// Value is the evaluated value (point value, rate, aggregate, etc)
+	Value float64 `json:"value"`
+	// Raw is the condition state before min active/inactive is applied
+	Raw    bool `json:"raw"`
+	Active bool `json:"active"`
+}
+
+// ActionTrace describes an action that ran
+type ActionTrace struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Action      string `json:"action"`
+}
+
+// init populates the condition traces with the current rule state
+func (rt *RuleTrace) init(config Rule) {
+	if rt.Conditions != nil {
+		return
+	}
+
+	rt.RuleID = config.ID
+	rt.Active = config.Active
+	rt.Conditions = make([]ConditionTrace, len(config.Conditions))
+	for i, c := range config.Conditions {
+		rt.Conditions[i] = ConditionTrace{
+			ID:            c.ID,
+			Description:   c.Description,
+			ConditionType: c.ConditionType,
+			Raw:           c.Active,
+			Active:        c.Active,
+		}
+	}
+}
+
+// addActions records actions that ran. rt may be nil.
+func (rt *RuleTrace) addActions(actions []Action) {
+	if rt == nil {
+		return
+	}
+
+	for _, a := range actions {
+		rt.Actions = append(rt.Actions, ActionTrace{
+			ID:          a.ID,
+			Description: a.Description,
+			Action:      a.Action,
+		})
+	}
+}
+
+// evaluated returns true if anything interesting happened in the evaluation
+func (rt *RuleTrace) evaluated() bool {
+	if rt.Changed {
+		return true
+	}
+
+	for _, c := range rt.Conditions {
+		if c.Evaluated {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rc *RuleClient) publishTrace(trace *RuleTrace) {
+	if !trace.evaluated() {
+		return
+	}
+
+	d, err := json.Marshal(trace)
+	if err != nil {
+		log.Println("Error encoding rule trace: ", err)
+		return
+	}
+
+	err = rc.nc.Publish(SubjectRuleTrace(rc.config.ID), d)
+	if err != nil {
+		log.Println("Error publishing rule trace: ", err)
+	}
+}
+
+// runSimulation evaluates the rule against the supplied nodes/points without
+// running actions or modifying the state of the rule.
+func (rc *RuleClient) runSimulation(nodes []data.NodeEdge) RuleTrace {
+	now := time.Now()
+
+	// holidays and positions are shared with the simulation, as they are
+	// only read while evaluating conditions
+	sim := &RuleClient{
+		nc:         rc.nc,
+		config:     rc.config,
+		condStates: make(map[string]*conditionState),
+		holidays:   rc.holidays,
+		positions:  rc.positions,
+		simulate:   true,
+	}
+
+	sim.config.Conditions = append([]Condition(nil), rc.config.Conditions...)
+
+	for id, cs := range rc.condStates {
+		csSim := *cs
+		csSim.window = append([]data.Point(nil), cs.window...)
+		sim.condStates[id] = &csSim
+	}
+
+	trace := RuleTrace{Time: now}
+
+	for _, n := range nodes {
+		for i := range n.Points {
+			if n.Points[i].Time.IsZero() {
+				n.Points[i].Time = now
+			}
+		}
+
+		trace.NodeID = n.ID
+		trace.Points = append(trace.Points, n.Points...)
+
+		_, _, err := sim.ruleProcessPoints(n.ID, n.Points, &trace)
+		if err != nil {
+			trace.Error = err.Error()
+			return trace
+		}
+	}
+
+	trace.init(sim.config)
+
+	trace.Active = sim.config.Active
+	trace.Changed = sim.config.Active != rc.config.Active
+
+	if trace.Changed {
+		if trace.Active {
+			trace.addActions(rc.config.Actions)
+		} else {
+			trace.addActions(rc.config.ActionsInactive)
+		}
+	}
+
+	return trace
+}
+
+func (rc *RuleClient) handleSimRequest(msg *nats.Msg) {
+	var trace RuleTrace
+
+	nodes, err := data.PbDecodeNodes(msg.Data)
+	if err != nil {
+		trace.Error = fmt.Sprintf("error decoding nodes: %v", err)
+	} else {
+		trace = rc.runSimulation(nodes)
+	}
+
+	d, err := json.Marshal(trace)
+	if err != nil {
+		log.Println("Error encoding rule simulation trace: ", err)
+		return
+	}
+
+	err = msg.Respond(d)
+	if err != nil {
+		log.Println("Error responding to rule simulation request: ", err)
+	}
+}
+
+// RuleSimulate evaluates a rule against hypothetical node points without
+// running any actions. Each node in nodes should have its ID and the
+// points to evaluate populated.
+func RuleSimulate(nc *nats.Conn, ruleID string, nodes []data.NodeEdge) (RuleTrace, error) {
+	nodesSend := data.Nodes(nodes)
+	d, err := nodesSend.ToPb()
+	if err != nil {
+		return RuleTrace{}, err
+	}
+
+	msg, err := nc.Request(SubjectRuleSim(ruleID), d, time.Second*20)
+	if err != nil {
+		return RuleTrace{}, err
+	}
+
+	var trace RuleTrace
+	err = json.Unmarshal(msg.Data, &trace)
+	if err != nil {
+		return RuleTrace{}, err
+	}
+
+	if trace.Error != "" {
+		return trace, errors.New(trace.Error)
+	}
+
+	return trace, nil
+}
@@ -16,13 +16,22 @@ import (
 	"github.com/simpleiot/simpleiot/data"
 )
 
-// Rule represent a rule node config
+// Rule represent a rule node config. The Alarm fields are used by the alarm
+// workflow (see rule-alarm.go). AckTime and ShelvedUntil are unix seconds,
+// Renotify is in minutes.
 type Rule struct {
 	ID              string      `node:"id"`
 	Parent          string      `node:"parent"`
 	Description     string      `point:"description"`
 	Disable         bool        `point:"disable"`
 	Active          bool        `point:"active"`
+	Debug           int         `point:"debug"`
+	Alarm           bool        `point:"alarm"`
+	AlarmState      string      `point:"alarmState"`
+	AckBy           string      `point:"ackBy"`
+	AckTime         float64     `point:"ackTime"`
+	ShelvedUntil    float64     `point:"shelvedUntil"`
+	Renotify        float64     `point:"renotify"`
 	Conditions      []Condition `child:"condition"`
 	Actions         []Action    `child:"action"`
 	ActionsInactive []Action    `child:"actionInactive"`
@@ -31,6 +40,9 @@ type Rule struct {
 func (r Rule) String() string {
 	ret := fmt.Sprintf("Rule: %v\n", r.Description)
 	ret += fmt.Sprintf("  active: %v\n", r.Active)
+	if r.Alarm {
+		ret += fmt.Sprintf("  alarm: %v\n", r.AlarmState)
+	}
 	for _, c := range r.Conditions {
 		ret += fmt.Sprintf("%v", c)
 	}
@@ -46,6 +58,8 @@ func (r Rule) String() string {
 }
 
 // Condition defines parameters to look for in a point or a schedule.
+// MinActive, MinInactive, and WindowLength are in minutes, StaleTimeout is in
+// seconds.
 type Condition struct {
 	// general parameters
 	ID            string  `node:"id"`
@@ -53,6 +67,7 @@ type Condition struct {
 	Description   string  `point:"description"`
 	ConditionType string  `point:"conditionType"`
 	MinActive     float64 `point:"minActive"`
+	MinInactive   float64 `point:"minInactive"`
 	Active        bool    `point:"active"`
 
 	// used with point value rules
@@ -65,10 +80,36 @@ type Condition struct {
 	Value      float64 `point:"value"`
 	ValueText  string  `point:"valueText"`
 
-	// used with shedule rules
-	Start    string `point:"start"`
-	End      string `point:"end"`
-	Weekdays []bool `point:"weekday"`
+	// used with hysteresis operators and stale rules
+	ValueClear   float64 `point:"valueClear"`
+	StaleTimeout float64 `point:"staleTimeout"`
+
+	// used with window rules. Aggregate: avg, min, max, count
+	Aggregate    string  `point:"aggregate"`
+	WindowLength float64 `point:"windowLength"`
+
+	// used with shedule rules. TimeZone is an IANA time zone name (ex:
+	// America/New_York), and defaults to the system time zone. Dates are
+	// formatted as 2023-12-25 or as a range: 2023-12-24/2023-12-26.
+	Start         string   `point:"start"`
+	End           string   `point:"end"`
+	Weekdays      []bool   `point:"weekday"`
+	TimeZone      string   `point:"timeZone"`
+	Dates         []string `point:"date"`
+	HolidayNodeID string   `point:"holidayNodeID"`
+
+	// used with cron and sun rules. SunEvent is sunrise or sunset, Offset
+	// and ActiveTime are in minutes. If Latitude and Longitude are not
+	// set, they are read from the node specified by NodeID. LastRun is
+	// the last occurrence (unix seconds) and is used to make sure each
+	// occurrence only fires once.
+	Cron       string  `point:"cron"`
+	SunEvent   string  `point:"sunEvent"`
+	Offset     float64 `point:"offset"`
+	Latitude   float64 `point:"latitude"`
+	Longitude  float64 `point:"longitude"`
+	ActiveTime float64 `point:"activeTime"`
+	LastRun    float64 `point:"lastRun"`
 }
 
 func (c Condition) String() string {
@@ -91,9 +132,28 @@ func (c Condition) String() string {
 	if c.NodeID != "" {
 		ret += fmt.Sprintf("  NODEID:%v", c.NodeID)
 	}
+	if c.Operator == data.PointValueHysteresisHigh ||
+		c.Operator == data.PointValueHysteresisLow {
+		ret += fmt.Sprintf("  CLR:%v", c.ValueClear)
+	}
 	if c.MinActive > 0 {
 		ret += fmt.Sprintf("  MINACT:%v", c.MinActive)
 	}
+	if c.MinInactive > 0 {
+		ret += fmt.Sprintf("  MININACT:%v", c.MinInactive)
+	}
+	if c.StaleTimeout > 0 {
+		ret += fmt.Sprintf("  STALE:%v", c.StaleTimeout)
+	}
+	if c.ConditionType == data.PointValueWindow {
+		ret += fmt.Sprintf("  AGG:%v  WIN:%v", c.Aggregate, c.WindowLength)
+	}
+	if c.ConditionType == data.PointValueCron {
+		ret += fmt.Sprintf("  CRON:%v", c.Cron)
+	}
+	if c.ConditionType == data.PointValueSun {
+		ret += fmt.Sprintf("  SUN:%v  OFF:%v", c.SunEvent, c.Offset)
+	}
 	ret += fmt.Sprintf("  A:%v", c.Active)
 	ret += "\n"
 	return ret
@@ -105,7 +165,7 @@ type Action struct {
 	Parent      string `node:"parent"`
 	Description string `point:"description"`
 	Active      bool   `point:"active"`
-	// Action: notify, setValue, playAudio
+	// Action: notify, setValue, playAudio, webhook, publish, sequence
 	Action    string `point:"action"`
 	NodeID    string `point:"nodeID"`
 	PointType string `point:"pointType"`
@@ -117,6 +177,22 @@ type Action struct {
 	PointChannel  int    `point:"pointChannel"`
 	PointDevice   string `point:"pointDevice"`
 	PointFilePath string `point:"pointFilePath"`
+	// the following are used for webhook and publish actions. Body
+	// and Subject are Go templates.
+	Method  string            `point:"method"`
+	URI     string            `point:"uri"`
+	Headers map[string]string `point:"header"`
+	Body    string            `point:"body"`
+	Retries int               `point:"retries"`
+	Subject string            `point:"subject"`
+	// the following are used for sequence actions. Waits are in seconds.
+	// Repeat is the number of times the sequence is run (0 runs once, -1
+	// repeats until the action is deactivated).
+	StepNodeIDs    []string  `point:"stepNodeID"`
+	StepPointTypes []string  `point:"stepPointType"`
+	StepValues     []float64 `point:"stepValue"`
+	StepWaits      []float64 `point:"stepWait"`
+	Repeat         int       `point:"repeat"`
 }
 
 func (a Action) String() string {
@@ -138,6 +214,12 @@ func (a Action) String() string {
 	if a.NodeID != "" {
 		ret += fmt.Sprintf("  NODEID:%v", a.NodeID)
 	}
+	if a.URI != "" {
+		ret += fmt.Sprintf("  URI:%v", a.URI)
+	}
+	if a.Subject != "" {
+		ret += fmt.Sprintf("  SUBJ:%v", a.Subject)
+	}
 	ret += fmt.Sprintf("  A:%v", a.Active)
 	ret += "\n"
 	return ret
@@ -150,7 +232,7 @@ type ActionInactive struct {
 	Parent      string `node:"parent"`
 	Description string `point:"description"`
 	Active      bool   `point:"active"`
-	// Action: notify, setValue, playAudio
+	// Action: notify, setValue, playAudio, webhook, publish, sequence
 	Action    string `point:"action"`
 	NodeID    string `point:"nodeID"`
 	PointType string `point:"pointType"`
@@ -162,6 +244,22 @@ type ActionInactive struct {
 	PointChannel  int    `point:"pointChannel"`
 	PointDevice   string `point:"pointDevice"`
 	PointFilePath string `point:"pointFilePath"`
+	// the following are used for webhook and publish actions. Body
+	// and Subject are Go templates.
+	Method  string            `point:"method"`
+	URI     string            `point:"uri"`
+	Headers map[string]string `point:"header"`
+	Body    string            `point:"body"`
+	Retries int               `point:"retries"`
+	Subject string            `point:"subject"`
+	// the following are used for sequence actions. Waits are in seconds.
+	// Repeat is the number of times the sequence is run (0 runs once, -1
+	// repeats until the action is deactivated).
+	StepNodeIDs    []string  `point:"stepNodeID"`
+	StepPointTypes []string  `point:"stepPointType"`
+	StepValues     []float64 `point:"stepValue"`
+	StepWaits      []float64 `point:"stepWait"`
+	Repeat         int       `point:"repeat"`
 }
 
 // RuleClient is a SIOT client used to run rules
@@ -172,7 +270,18 @@ type RuleClient struct {
 	newPoints     chan NewPoints
 	newEdgePoints chan NewPoints
 	newRulePoints chan NewPoints
+	simRequests   chan *nats.Msg
 	upSub         *nats.Subscription
+	simSub        *nats.Subscription
+	condStates    map[string]*conditionState
+	sequences     map[string]*sequence
+	holidays      map[string]holidayWatcher
+	positions     map[string]positionWatcher
+	// last time alarm notifications were sent
+	lastNotify time.Time
+	// simulate is set when evaluating hypothetical points. No points
+	// are sent in this mode.
+	simulate bool
 }
 
 // NewRuleClient constructor ...
@@ -184,6 +293,11 @@ func NewRuleClient(nc *nats.Conn, config Rule) Client {
 		newPoints:     make(chan NewPoints),
 		newEdgePoints: make(chan NewPoints),
 		newRulePoints: make(chan NewPoints),
+		simRequests:   make(chan *nats.Msg),
+		condStates:    make(map[string]*conditionState),
+		sequences:     make(map[string]*sequence),
+		holidays:      make(map[string]holidayWatcher),
+		positions:     make(map[string]positionWatcher),
 	}
 }
 
@@ -216,6 +330,14 @@ func (rc *RuleClient) Run() error {
 		return fmt.Errorf("Rule error subscribing to upsub: %v", err)
 	}
 
+	rc.simSub, err = rc.nc.Subscribe(SubjectRuleSim(rc.config.ID), func(msg *nats.Msg) {
+		rc.simRequests <- msg
+	})
+
+	if err != nil {
+		return fmt.Errorf("Rule error subscribing to sim: %v", err)
+	}
+
 	// TODO schedule ticker is a brute force way to do this
 	// we could optimize at some point by creating a timer to expire
 	// on the next schedule change
@@ -225,8 +347,45 @@ func (rc *RuleClient) Run() error {
 		scheduleTicker.Stop()
 	}
 
+	// condTimer is used to re-evaluate conditions that have timing
+	// requirements (min active, stale, etc) when no points arrive
+	condTimer := time.NewTimer(time.Hour)
+	condTimer.Stop()
+
+	// alarmTimer is used for alarm shelve expiration and re-notification
+	alarmTimer := time.NewTimer(time.Hour)
+	alarmTimer.Stop()
+
+	resetCondTimer := func() {
+		if t, ok := rc.conditionDeadline(); ok {
+			condTimer.Reset(time.Until(t))
+		} else {
+			condTimer.Stop()
+		}
+
+		if t, ok := rc.alarmDeadline(); ok {
+			alarmTimer.Reset(time.Until(t))
+		} else {
+			alarmTimer.Stop()
+		}
+	}
+
+	if rc.config.AlarmState == data.PointValueAlarmUnacknowledged {
+		rc.lastNotify = time.Now()
+	}
+
+	rc.updateHolidayWatchers()
+	rc.updatePositionWatchers()
+	resetCondTimer()
+
 	run := func(id string, pts data.Points) {
-		active, changed, err := rc.ruleProcessPoints(id, pts)
+		var trace *RuleTrace
+		if rc.config.Debug > 0 {
+			trace = &RuleTrace{Time: time.Now(), NodeID: id, Points: pts}
+			defer rc.publishTrace(trace)
+		}
+
+		active, changed, err := rc.ruleProcessPoints(id, pts, trace)
 
 		if err != nil {
 			log.Println("Error processing rule point: ", err)
@@ -236,7 +395,10 @@ func (rc *RuleClient) Run() error {
 			return
 		}
 
+		rc.alarmRuleChanged(active, time.Now())
+
 		if active {
+			trace.addActions(rc.config.Actions)
 			err := rc.ruleRunActions(rc.config.Actions, id)
 			if err != nil {
 				log.Println("Error running rule actions: ", err)
@@ -247,6 +409,7 @@ func (rc *RuleClient) Run() error {
 				log.Println("Error running rule inactive actions: ", err)
 			}
 		} else {
+			trace.addActions(rc.config.ActionsInactive)
 			err := rc.ruleRunActions(rc.config.ActionsInactive, id)
 			if err != nil {
 				log.Println("Error running rule actions: ", err)
@@ -266,31 +429,68 @@ done:
 			break done
 		case pts := <-rc.newRulePoints:
 			run(pts.ID, pts.Points)
+			resetCondTimer()
+
+		case msg := <-rc.simRequests:
+			rc.handleSimRequest(msg)
 
 		case <-scheduleTicker.C:
 			run(rc.config.ID, data.Points{{
 				Time: time.Now(),
 				Type: data.PointTypeTrigger,
 			}})
+			resetCondTimer()
+
+		case <-condTimer.C:
+			run(rc.config.ID, data.Points{{
+				Time: time.Now(),
+				Type: data.PointTypeTrigger,
+			}})
+			resetCondTimer()
+
+		case <-alarmTimer.C:
+			rc.alarmTimeout(time.Now())
+			resetCondTimer()
 
 		case pts := <-rc.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &rc.config)
 			if err != nil {
 				log.Println("error merging rule points: ", err)
 			}
+			if pts.ID == rc.config.ID {
+				now := time.Now()
+				for _, p := range pts.Points {
+					rc.alarmCommand(p, now)
+				}
+			}
 			if rc.hasSchedule() {
 				scheduleTicker = time.NewTicker(scheduleTickTime)
 			} else {
 				scheduleTicker.Stop()
 			}
+			rc.updateHolidayWatchers()
+			rc.updatePositionWatchers()
+			resetCondTimer()
 		case pts := <-rc.newEdgePoints:
 			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &rc.config)
 			if err != nil {
 				log.Println("error merging rule edge points: ", err)
 			}
+			rc.updateHolidayWatchers()
+			rc.updatePositionWatchers()
+			resetCondTimer()
 		}
 	}
 
+	rc.stopSequences()
+	rc.stopHolidayWatchers()
+	rc.stopPositionWatchers()
+
+	err = rc.simSub.Unsubscribe()
+	if err != nil {
+		log.Println("Error unsubscribing rule sim sub: ", err)
+	}
+
 	return rc.upSub.Unsubscribe()
 }
 
@@ -313,6 +513,10 @@ func (rc *RuleClient) EdgePoints(nodeID, parentID string, points []data.Point) {
 
 // sendPoint sets origin to the rule node
 func (rc *RuleClient) sendPoint(id string, point data.Point) error {
+	if rc.simulate {
+		return nil
+	}
+
 	if id != rc.config.ID {
 		// we must set origin as we are sending a point to something
 		// other than the client root node
@@ -333,66 +537,180 @@ func (rc *RuleClient) hasSchedule() bool {
 	return false
 }
 
+// conditionSchedule creates a schedule for a schedule condition
+func (rc *RuleClient) conditionSchedule(c Condition, weekdays []time.Weekday) (*schedule, error) {
+	sched := newSchedule(c.Start, c.End, weekdays)
+
+	var err error
+	sched.loc, err = scheduleLocation(c.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	sched.dates, err = parseDateRanges(c.Dates)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.HolidayNodeID != "" {
+		sched.exclude, err = rc.holidayDates(c.HolidayNodeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sched, nil
+}
+
+// conditionState returns the run time state for a condition, creating it
+// if needed
+func (rc *RuleClient) conditionState(c Condition, now time.Time) *conditionState {
+	cs, ok := rc.condStates[c.ID]
+	if !ok {
+		cs = newConditionState(c.Active, now)
+		rc.condStates[c.ID] = cs
+	}
+	return cs
+}
+
+// conditionDeadline returns the soonest time any condition needs to be
+// re-evaluated due to timing requirements
+func (rc *RuleClient) conditionDeadline() (time.Time, bool) {
+	var ret time.Time
+	found := false
+	now := time.Now()
+
+	check := func(t time.Time) {
+		if !found || t.Before(ret) {
+			ret = t
+			found = true
+		}
+	}
+
+	for _, c := range rc.config.Conditions {
+		cs := rc.conditionState(c, now)
+		if t, ok := cs.deadline(c); ok {
+			check(t)
+		}
+		if t, ok := rc.occurrenceDeadline(c, cs, now); ok {
+			check(t)
+		}
+	}
+
+	return ret, found
+}
+
 // ruleProcessPoints runs points through a rules conditions and and updates condition
 // and rule active status. Returns true if point was processed and active is true.
 // Currently, this function only processes the first point that matches -- this should
 // handle all current uses.
-func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool, bool, error) {
+func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points, trace *RuleTrace) (bool, bool, error) {
 	pointsProcessed := false
+	now := time.Now()
+
+	if trace != nil {
+		trace.init(rc.config)
+	}
 
 	for _, p := range points {
+		trigger := p.Type == data.PointTypeTrigger
+
 		for i, c := range rc.config.Conditions {
-			var active bool
+			cs := rc.conditionState(c, now)
+
+			// evaluated is set if the point was used to evaluate the
+			// condition, and value is the value that was evaluated
+			evaluated := false
+			var value float64
 
 			switch c.ConditionType {
 			case data.PointValuePointValue:
-				if c.NodeID != "" && c.NodeID != nodeID {
-					continue
+				if trigger {
+					// only timing (min active/inactive) is evaluated
+					break
 				}
 
-				if c.PointKey != "" && c.PointKey != p.Key {
-					continue
-				}
-
-				if c.PointType != "" && c.PointType != p.Type {
+				if !c.matchPoint(nodeID, p) {
 					continue
 				}
 
 				// conditions match, so check value
 				switch c.ValueType {
 				case data.PointValueNumber:
-					pointsProcessed = true
-					switch c.Operator {
-					case data.PointValueGreaterThan:
-						active = p.Value > c.Value
-					case data.PointValueLessThan:
-						active = p.Value < c.Value
-					case data.PointValueEqual:
-						active = p.Value == c.Value
-					case data.PointValueNotEqual:
-						active = p.Value != c.Value
-					}
+					evaluated = true
+					value = p.Value
+					cs.setRaw(c.compareNumber(p.Value, cs.raw), now)
 				case data.PointValueText:
-					pointsProcessed = true
+					evaluated = true
 					switch c.Operator {
 					case data.PointValueEqual:
 					case data.PointValueNotEqual:
 					case data.PointValueContains:
 					}
 				case data.PointValueOnOff:
-					pointsProcessed = true
+					evaluated = true
+					value = p.Value
 					condValue := c.Value != 0
 					pointValue := p.Value != 0
-					active = condValue == pointValue
+					cs.setRaw(condValue == pointValue, now)
 				default:
 					log.Printf("unknown point type for rule: %v: %v\n",
 						rc.config.Description, c.ValueType)
 				}
+			case data.PointValueRateOfChange:
+				if trigger {
+					break
+				}
+
+				if !c.matchPoint(nodeID, p) {
+					continue
+				}
+
+				evaluated = true
+
+				rate, ok := cs.rateOfChange(p)
+				if !ok {
+					continue
+				}
+
+				value = rate
+				cs.setRaw(c.compareNumber(rate, cs.raw), now)
+			case data.PointValueStale:
+				if trigger {
+					evaluated = true
+					value = now.Sub(cs.lastUpdate).Seconds()
+					cs.setRaw(c.StaleTimeout > 0 &&
+						now.Sub(cs.lastUpdate) >= c.staleTimeout(), now)
+					break
+				}
+
+				if !c.matchPoint(nodeID, p) {
+					continue
+				}
+
+				evaluated = true
+				cs.lastUpdate = now
+				cs.setRaw(false, now)
+			case data.PointValueWindow:
+				var pWin *data.Point
+
+				if !trigger {
+					if !c.matchPoint(nodeID, p) {
+						continue
+					}
+					pWin = &p
+				}
+
+				evaluated = true
+
+				var ok bool
+				value, ok = cs.windowAggregate(c, pWin, now)
+				cs.setRaw(ok && c.compareNumber(value, cs.raw), now)
 			case data.PointValueSchedule:
-				if p.Type != data.PointTypeTrigger {
+				if !trigger {
 					continue
 				}
-				pointsProcessed = true
+				evaluated = true
 
 				weekdays := []time.Weekday{}
 				for i, v := range c.Weekdays {
@@ -400,21 +718,68 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 						weekdays = append(weekdays, time.Weekday(i))
 					}
 				}
-				sched := newSchedule(c.Start, c.End, weekdays)
+				sched, err := rc.conditionSchedule(c, weekdays)
+				if err != nil {
+					log.Println("Error setting up schedule: ", err)
+					continue
+				}
 
-				var err error
-				active, err = sched.activeForTime(p.Time)
+				active, err := sched.activeForTime(p.Time)
 				if err != nil {
 					log.Println("Error parsing schedule time: ", err)
 					continue
 				}
+				value = data.BoolToFloat(active)
+				cs.setRaw(active, now)
+			case data.PointValueCron, data.PointValueSun:
+				if !trigger {
+					continue
+				}
+				evaluated = true
+
+				fired, err := rc.evalOccurrence(&rc.config.Conditions[i], cs, now)
+				if err != nil {
+					cs.logError(c, err)
+				}
+
+				if fired {
+					c = rc.config.Conditions[i]
+					err := rc.sendPoint(c.ID, data.Point{
+						Type:  data.PointTypeLastRun,
+						Time:  now,
+						Value: c.LastRun,
+					})
+					if err != nil {
+						log.Println("Rule error sending point: ", err)
+					}
+				}
+
+				value = data.BoolToFloat(cs.raw)
+			}
+
+			if evaluated {
+				pointsProcessed = true
+			}
+
+			active := cs.debounce(c, now)
+
+			if trace != nil {
+				ct := &trace.Conditions[i]
+				if evaluated {
+					ct.Evaluated = true
+					ct.Value = value
+				}
+				ct.Raw = cs.raw
+				ct.Active = active
 			}
 
 			if active != c.Active {
+				pointsProcessed = true
+
 				// update condition
 				p := data.Point{
 					Type:  data.PointTypeActive,
-					Time:  time.Now(),
+					Time:  now,
 					Value: data.BoolToFloat(active),
 				}
 
@@ -456,6 +821,11 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 			rc.config.Active = allActive
 		}
 
+		if trace != nil {
+			trace.Active = allActive
+			trace.Changed = changed
+		}
+
 		return allActive, changed, nil
 	}
 
@@ -483,8 +853,18 @@ func (rc *RuleClient) ruleRunActions(actions []Action, triggerNodeID string) err
 				log.Println("Error sending rule action point: ", err)
 			}
 		case data.PointValueNotify:
-			// get node that fired the rule
-			nodes, err := GetNodes(rc.nc, "none", triggerNodeID, "", false)
+			if rc.alarmShelved(time.Now()) {
+				break
+			}
+
+			// get node that fired the rule. Alarm notifications are
+			// fired by the rule node, which is looked up under all of its
+			// parents.
+			parent := "none"
+			if triggerNodeID == rc.config.ID {
+				parent = "all"
+			}
+			nodes, err := GetNodes(rc.nc, parent, triggerNodeID, "", false)
 			if err != nil {
 				return err
 			}
@@ -514,6 +894,18 @@ func (rc *RuleClient) ruleRunActions(actions []Action, triggerNodeID string) err
 			if err != nil {
 				return err
 			}
+		case data.PointValueWebhook:
+			err := rc.runActionWebhook(a, triggerNodeID)
+			if err != nil {
+				log.Println("Error running rule webhook action: ", err)
+			}
+		case data.PointValuePublish:
+			err := rc.runActionPublish(a, triggerNodeID)
+			if err != nil {
+				log.Println("Error running rule publish action: ", err)
+			}
+		case data.PointValueSequence:
+			rc.startSequence(a)
 		case data.PointValuePlayAudio:
 			f, err := os.Open(a.PointFilePath)
 			if err != nil {
@@ -561,6 +953,8 @@ func (rc *RuleClient) ruleRunActions(actions []Action, triggerNodeID string) err
 
 func (rc *RuleClient) ruleRunInactiveActions(actions []Action) error {
 	for i, a := range actions {
+		rc.stopSequence(a.ID)
+
 		p := data.Point{
 			Type:  data.PointTypeActive,
 			Value: 0,
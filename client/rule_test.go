@@ -1,9 +1,14 @@
 package client_test
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/client"
 	"github.com/simpleiot/simpleiot/data"
 	"github.com/simpleiot/simpleiot/server"
@@ -158,3 +163,749 @@ func TestRules(t *testing.T) {
 		<-time.After(time.Millisecond * 10)
 	}
 }
+
+// setupRuleTest creates vin and vout variables and a rule with the
+// provided condition that sets vout to 1 when active and 0 when inactive.
+// The condition ID, parent, and node ID are filled in. Any extra nodes
+// (typically actions with parent set to ID-rule) are also created.
+func setupRuleTest(t *testing.T, c client.Condition, extra ...any) (*nats.Conn, client.Variable, func() client.Variable, func()) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	vin := client.Variable{
+		ID:          "ID-varin",
+		Parent:      root.ID,
+		Description: "var in",
+	}
+
+	vout := client.Variable{
+		ID:          "ID-varout",
+		Parent:      root.ID,
+		Description: "var out",
+	}
+
+	r := client.Rule{
+		ID:          "ID-rule",
+		Parent:      root.ID,
+		Description: "test rule",
+	}
+
+	c.ID = "ID-condition"
+	c.Parent = r.ID
+	c.NodeID = vin.ID
+
+	a := client.Action{
+		ID:          "ID-action-active",
+		Parent:      r.ID,
+		Description: "action active",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      vout.ID,
+		Value:       1,
+	}
+
+	a2 := client.ActionInactive{
+		ID:          "ID-action-inactive",
+		Parent:      r.ID,
+		Description: "action inactive",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      vout.ID,
+		Value:       0,
+	}
+
+	for _, n := range append([]any{vin, vout, r, c, a, a2}, extra...) {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			stop()
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	voutGet, voutStop, err := client.NodeWatcher[client.Variable](nc, vout.ID, vout.Parent)
+	if err != nil {
+		stop()
+		t.Fatal("Error setting up watcher")
+	}
+
+	// wait for rule to get set up
+	time.Sleep(250 * time.Millisecond)
+
+	return nc, vin, voutGet, func() {
+		voutStop()
+		stop()
+	}
+}
+
+func sendRuleTestPoint(t *testing.T, nc *nats.Conn, id string, value float64, ts time.Time) {
+	err := client.SendNodePoint(nc, id, data.Point{Type: data.PointTypeValue,
+		Time: ts, Value: value, Origin: "test"}, true)
+
+	if err != nil {
+		t.Errorf("Error sending point: %v", err)
+	}
+}
+
+func waitRuleTestValue(t *testing.T, get func() client.Variable, value float64, timeout time.Duration, msg string) {
+	start := time.Now()
+	for {
+		if get().Value == value {
+			return
+		}
+		if time.Since(start) > timeout {
+			t.Fatal("Timeout waiting for: ", msg)
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+func TestRuleHysteresis(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueNumber,
+		Operator:      data.PointValueHysteresisHigh,
+		Value:         10,
+		ValueClear:    5,
+	})
+	defer stop()
+
+	sendRuleTestPoint(t, nc, vin.ID, 11, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	// in the dead band, so rule should stay active
+	sendRuleTestPoint(t, nc, vin.ID, 7, time.Now())
+	time.Sleep(100 * time.Millisecond)
+	if voutGet().Value != 1 {
+		t.Fatal("rule cleared in dead band")
+	}
+
+	sendRuleTestPoint(t, nc, vin.ID, 4, time.Now())
+	waitRuleTestValue(t, voutGet, 0, time.Second, "vout cleared")
+
+	// in the dead band, so rule should stay inactive
+	sendRuleTestPoint(t, nc, vin.ID, 7, time.Now())
+	time.Sleep(100 * time.Millisecond)
+	if voutGet().Value != 0 {
+		t.Fatal("rule set in dead band")
+	}
+}
+
+// TestRuleTriggerActiveCondition checks that a condition that is already
+// active stays active when the rule is evaluated by a timing trigger
+// instead of a point for that condition.
+func TestRuleTriggerActiveCondition(t *testing.T) {
+	_, _, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueNumber,
+		Operator:      data.PointValueGreaterThan,
+		Value:         30,
+		Active:        true,
+	}, client.Condition{
+		ID:            "ID-condition-stale",
+		Parent:        "ID-rule",
+		Description:   "cond stale",
+		ConditionType: data.PointValueStale,
+		NodeID:        "ID-varstale",
+		PointType:     data.PointTypeValue,
+		StaleTimeout:  0.3,
+	})
+	defer stop()
+
+	// the stale condition is evaluated by a trigger, and the rule goes
+	// active as the vin condition is still active
+	waitRuleTestValue(t, voutGet, 1, 2*time.Second, "vout set")
+}
+
+func TestRuleMinInactive(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin on",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueOnOff,
+		Value:         1,
+		// 0.5s
+		MinInactive: 0.5 / 60,
+	})
+	defer stop()
+
+	sendRuleTestPoint(t, nc, vin.ID, 1, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	// short glitch should be ignored
+	sendRuleTestPoint(t, nc, vin.ID, 0, time.Now())
+	time.Sleep(100 * time.Millisecond)
+	sendRuleTestPoint(t, nc, vin.ID, 1, time.Now())
+	time.Sleep(600 * time.Millisecond)
+	if voutGet().Value != 1 {
+		t.Fatal("rule cleared before min inactive time")
+	}
+
+	start := time.Now()
+	sendRuleTestPoint(t, nc, vin.ID, 0, time.Now())
+	waitRuleTestValue(t, voutGet, 0, 2*time.Second, "vout cleared")
+	if time.Since(start) < 400*time.Millisecond {
+		t.Fatal("rule cleared too soon")
+	}
+}
+
+func TestRuleRateOfChange(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin changing fast",
+		ConditionType: data.PointValueRateOfChange,
+		PointType:     data.PointTypeValue,
+		Operator:      data.PointValueGreaterThan,
+		// units per minute
+		Value: 60,
+	})
+	defer stop()
+
+	start := time.Now()
+
+	sendRuleTestPoint(t, nc, vin.ID, 0, start)
+	// 10 units/sec = 600 units/min
+	sendRuleTestPoint(t, nc, vin.ID, 10, start.Add(time.Second))
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	// 0.5 units/sec = 30 units/min
+	sendRuleTestPoint(t, nc, vin.ID, 9.5, start.Add(2*time.Second))
+	waitRuleTestValue(t, voutGet, 0, time.Second, "vout cleared")
+}
+
+func TestRuleStale(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin stale",
+		ConditionType: data.PointValueStale,
+		PointType:     data.PointTypeValue,
+		StaleTimeout:  0.5,
+	})
+	defer stop()
+
+	sendRuleTestPoint(t, nc, vin.ID, 1, time.Now())
+	time.Sleep(200 * time.Millisecond)
+	if voutGet().Value != 0 {
+		t.Fatal("rule active before stale timeout")
+	}
+
+	waitRuleTestValue(t, voutGet, 1, 2*time.Second, "vout set on stale")
+
+	sendRuleTestPoint(t, nc, vin.ID, 2, time.Now())
+	waitRuleTestValue(t, voutGet, 0, time.Second, "vout cleared on update")
+}
+
+func TestRuleWindow(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin avg low",
+		ConditionType: data.PointValueWindow,
+		PointType:     data.PointTypeValue,
+		Aggregate:     data.PointValueAverage,
+		Operator:      data.PointValueLessThan,
+		Value:         5,
+		// 1s
+		WindowLength: 1.0 / 60,
+	})
+	defer stop()
+
+	sendRuleTestPoint(t, nc, vin.ID, 10, time.Now())
+	sendRuleTestPoint(t, nc, vin.ID, 0, time.Now())
+	time.Sleep(100 * time.Millisecond)
+	if voutGet().Value != 0 {
+		t.Fatal("rule active when avg is not below threshold")
+	}
+
+	sendRuleTestPoint(t, nc, vin.ID, 0, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	// all points expire out of the window, so rule should go inactive
+	waitRuleTestValue(t, voutGet, 0, 2*time.Second, "vout cleared")
+}
+
+func TestRuleWebhookPublish(t *testing.T) {
+	hookBody := make(chan []byte, 1)
+	hookAuth := make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hookAuth <- r.Header.Get("Authorization")
+		hookBody <- body
+	}))
+	defer ts.Close()
+
+	hook := client.Action{
+		ID:          "ID-action-webhook",
+		Parent:      "ID-rule",
+		Description: "webhook",
+		Action:      data.PointValueWebhook,
+		URI:         ts.URL,
+		Headers:     map[string]string{"Authorization": "Bearer 1234"},
+		Body:        `{"desc":{{json .NodeDesc}},"value":{{.Value "value"}}}`,
+	}
+
+	pub := client.Action{
+		ID:          "ID-action-publish",
+		Parent:      "ID-rule",
+		Description: "publish",
+		Action:      data.PointValuePublish,
+		Subject:     "test.{{.NodeID}}",
+		Body:        "{{.RuleDesc}} fired",
+	}
+
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueNumber,
+		Operator:      data.PointValueGreaterThan,
+		Value:         10,
+	}, hook, pub)
+	defer stop()
+
+	pubMsg := make(chan string, 1)
+	sub, err := nc.Subscribe("test."+vin.ID, func(msg *nats.Msg) {
+		pubMsg <- string(msg.Data)
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	sendRuleTestPoint(t, nc, vin.ID, 11, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	select {
+	case body := <-hookBody:
+		var v struct {
+			Desc  string
+			Value float64
+		}
+		err := json.Unmarshal(body, &v)
+		if err != nil {
+			t.Fatalf("Error decoding webhook body: %v: %s", err, body)
+		}
+		if v.Desc != "var in" || v.Value != 11 {
+			t.Errorf("Webhook body is not correct: %s", body)
+		}
+		if auth := <-hookAuth; auth != "Bearer 1234" {
+			t.Error("Webhook header is not correct: ", auth)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timeout waiting for webhook")
+	}
+
+	select {
+	case msg := <-pubMsg:
+		if msg != "test rule fired" {
+			t.Error("Published message is not correct: ", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timeout waiting for publish")
+	}
+}
+
+func TestRuleSequence(t *testing.T) {
+	vseq := client.Variable{
+		ID:          "ID-varseq",
+		Description: "var seq",
+	}
+
+	seq := client.Action{
+		ID:          "ID-action-sequence",
+		Parent:      "ID-rule",
+		Description: "sequence",
+		Action:      data.PointValueSequence,
+		NodeID:      vseq.ID,
+		PointType:   data.PointTypeValue,
+		StepValues:  []float64{1, 2},
+		StepWaits:   []float64{0.2, 0.2},
+		Repeat:      -1,
+	}
+
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin on",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueOnOff,
+		Value:         1,
+	}, seq)
+	defer stop()
+
+	// put vseq under the same parent as the other variables
+	vseq.Parent = vin.Parent
+
+	err := client.SendNodeType(nc, vseq, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	vseqGet, vseqStop, err := client.NodeWatcher[client.Variable](nc, vseq.ID, vseq.Parent)
+	if err != nil {
+		t.Fatal("Error setting up watcher")
+	}
+	defer vseqStop()
+
+	sendRuleTestPoint(t, nc, vin.ID, 1, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	// sequence should cycle through values multiple times
+	for i := 0; i < 2; i++ {
+		waitRuleTestValue(t, vseqGet, 1, time.Second, "vseq step 0")
+		waitRuleTestValue(t, vseqGet, 2, time.Second, "vseq step 1")
+	}
+
+	// sequence should stop when rule goes inactive
+	sendRuleTestPoint(t, nc, vin.ID, 0, time.Now())
+	waitRuleTestValue(t, voutGet, 0, time.Second, "vout cleared")
+
+	v := vseqGet().Value
+	time.Sleep(500 * time.Millisecond)
+	if vseqGet().Value != v {
+		t.Fatal("sequence did not stop when rule went inactive")
+	}
+
+	nodes, err := client.GetNodes(nc, "all", seq.ID, "", false)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting sequence node: ", err)
+	}
+
+	running, ok := nodes[0].Points.Value(data.PointTypeSequenceRunning, "")
+	if !ok || running != 0 {
+		t.Fatal("sequence running point is not correct")
+	}
+}
+
+func TestRuleTraceSimulate(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueNumber,
+		Operator:      data.PointValueGreaterThan,
+		Value:         10,
+	})
+	defer stop()
+
+	trace, err := client.RuleSimulate(nc, "ID-rule", []data.NodeEdge{
+		{ID: vin.ID, Points: data.Points{{Type: data.PointTypeValue, Value: 11}}},
+	})
+	if err != nil {
+		t.Fatal("Error simulating rule: ", err)
+	}
+
+	if !trace.Active || !trace.Changed {
+		t.Fatal("simulated rule did not go active")
+	}
+
+	if len(trace.Conditions) != 1 || !trace.Conditions[0].Evaluated ||
+		trace.Conditions[0].Value != 11 {
+		t.Fatalf("simulated condition trace is not correct: %+v", trace.Conditions)
+	}
+
+	if len(trace.Actions) != 1 || trace.Actions[0].ID != "ID-action-active" {
+		t.Fatalf("simulated action trace is not correct: %+v", trace.Actions)
+	}
+
+	// simulation should not run actions
+	time.Sleep(100 * time.Millisecond)
+	if voutGet().Value != 0 {
+		t.Fatal("simulation ran actions")
+	}
+
+	// now enable debug and look for trace
+	traces := make(chan client.RuleTrace, 10)
+	sub, err := nc.Subscribe(client.SubjectRuleTrace("ID-rule"), func(msg *nats.Msg) {
+		var trace client.RuleTrace
+		err := json.Unmarshal(msg.Data, &trace)
+		if err != nil {
+			t.Error("Error decoding trace: ", err)
+		}
+		traces <- trace
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	err = client.SendNodePoint(nc, "ID-rule", data.Point{Type: data.PointTypeDebug,
+		Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	sendRuleTestPoint(t, nc, vin.ID, 12, time.Now())
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	select {
+	case trace := <-traces:
+		if trace.NodeID != vin.ID || !trace.Active || !trace.Changed ||
+			len(trace.Actions) != 1 || trace.Conditions[0].Value != 12 {
+			t.Fatalf("rule trace is not correct: %+v", trace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for rule trace")
+	}
+}
+
+func TestRuleTraceSimulateHoliday(t *testing.T) {
+	nc, vin, _, stop := setupRuleTest(t, client.Condition{
+		Description:   "all day",
+		ConditionType: data.PointValueSchedule,
+		TimeZone:      "UTC",
+	})
+	defer stop()
+
+	holidays := client.Holidays{
+		ID:          "ID-holidays",
+		Parent:      vin.Parent,
+		Description: "holidays",
+		Dates:       []string{"2023-12-25"},
+	}
+
+	err := client.SendNodeType(nc, holidays, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	err = client.SendNodePoint(nc, "ID-condition", data.Point{
+		Type: data.PointTypeHolidayNodeID, Text: holidays.ID, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	simulate := func(day time.Time) client.RuleTrace {
+		t.Helper()
+		trace, err := client.RuleSimulate(nc, "ID-rule", []data.NodeEdge{
+			{ID: "ID-rule", Points: data.Points{{Type: data.PointTypeTrigger,
+				Time: day}}},
+		})
+		if err != nil {
+			t.Fatal("Error simulating rule: ", err)
+		}
+		return trace
+	}
+
+	// the holiday is excluded like it is in live evaluation. Retry until
+	// the rule has picked up the holiday node.
+	start := time.Now()
+	for simulate(time.Date(2023, 12, 25, 12, 0, 0, 0, time.UTC)).Active {
+		if time.Since(start) > time.Second {
+			t.Fatal("simulated rule active on holiday")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	trace := simulate(time.Date(2023, 12, 26, 12, 0, 0, 0, time.UTC))
+	if !trace.Active {
+		t.Error("simulated rule not active after holiday")
+	}
+}
+
+func TestRuleScheduleHoliday(t *testing.T) {
+	nc, vin, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "all day",
+		ConditionType: data.PointValueSchedule,
+		TimeZone:      "UTC",
+	})
+	defer stop()
+
+	// the schedule is evaluated by the schedule ticker
+	waitRuleTestValue(t, voutGet, 1, 10*time.Second, "vout set")
+
+	// today is a holiday. The range covers the days around today in case
+	// the date changes while the test runs.
+	now := time.Now().UTC()
+	holidays := client.Holidays{
+		ID:          "ID-holidays",
+		Parent:      vin.Parent,
+		Description: "holidays",
+		Dates: []string{now.AddDate(0, 0, -1).Format("2006-01-02") + "/" +
+			now.AddDate(0, 0, 1).Format("2006-01-02")},
+	}
+
+	err := client.SendNodeType(nc, holidays, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	err = client.SendNodePoint(nc, "ID-condition", data.Point{
+		Type: data.PointTypeHolidayNodeID, Text: holidays.ID, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	waitRuleTestValue(t, voutGet, 0, 10*time.Second, "vout cleared on holiday")
+}
+
+func TestRuleCron(t *testing.T) {
+	nc, _, voutGet, stop := setupRuleTest(t, client.Condition{
+		Description:   "every minute",
+		ConditionType: data.PointValueCron,
+		Cron:          "* * * * *",
+		TimeZone:      "UTC",
+		ActiveTime:    1,
+		LastRun:       float64(time.Now().Add(time.Minute).Unix()),
+	})
+	defer stop()
+
+	// after the rule is set up, move the last run back so that an
+	// occurrence in the last minute is pending and the rule fires right away
+	now := time.Now()
+	lastRun := now.Add(-2 * time.Minute).Unix()
+
+	err := client.SendNodePoint(nc, "ID-condition", data.Point{Type: data.PointTypeLastRun,
+		Value: float64(lastRun), Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	waitRuleTestValue(t, voutGet, 1, time.Second, "vout set")
+
+	condGet, condStop, err := client.NodeWatcher[client.Condition](nc, "ID-condition", "ID-rule")
+	if err != nil {
+		t.Fatal("Error watching condition: ", err)
+	}
+	defer condStop()
+
+	// the last run point is persisted so the occurrence does not fire
+	// again after a restart
+	start := time.Now()
+	for {
+		lr := condGet().LastRun
+		if lr > float64(lastRun) {
+			if lr != float64(now.Truncate(time.Minute).Unix()) &&
+				lr != float64(time.Now().Truncate(time.Minute).Unix()) {
+				t.Fatal("last run is not the latest occurrence: ", lr)
+			}
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Timeout waiting for last run")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+func TestRuleAlarm(t *testing.T) {
+	nc, vin, _, stop := setupRuleTest(t, client.Condition{
+		Description:   "cond vin high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueNumber,
+		Operator:      data.PointValueGreaterThan,
+		Value:         10,
+	}, client.Action{
+		ID:          "ID-action-notify",
+		Parent:      "ID-rule",
+		Description: "notify",
+		Action:      data.PointValueNotify,
+	})
+	defer stop()
+
+	notifications := make(chan struct{}, 10)
+	sub, err := nc.Subscribe("node.ID-rule.not", func(msg *nats.Msg) {
+		n, err := data.PbDecodeNotification(msg.Data)
+		if err != nil {
+			t.Error("Error decoding notification: ", err)
+			return
+		}
+		// alarm notifications are fired by the rule node
+		if n.Message != "test rule fired at test rule" {
+			t.Error("wrong notification message: ", n.Message)
+		}
+		notifications <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	waitNotification := func(msg string) {
+		t.Helper()
+		select {
+		case <-notifications:
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for notification: ", msg)
+		}
+	}
+
+	noNotification := func(msg string) {
+		t.Helper()
+		select {
+		case <-notifications:
+			t.Fatal("Unexpected notification: ", msg)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	ruleGet, ruleStop, err := client.NodeWatcher[client.Rule](nc, "ID-rule", "all")
+	if err != nil {
+		t.Fatal("Error watching rule: ", err)
+	}
+	defer ruleStop()
+
+	waitState := func(state string) {
+		t.Helper()
+		start := time.Now()
+		for ruleGet().AlarmState != state {
+			if time.Since(start) > time.Second {
+				t.Fatalf("Timeout waiting for alarm state %v, got %v", state,
+					ruleGet().AlarmState)
+			}
+			<-time.After(time.Millisecond * 10)
+		}
+	}
+
+	sendRulePoint := func(typ string, value float64) {
+		t.Helper()
+		err := client.SendNodePoint(nc, "ID-rule", data.Point{Type: typ,
+			Value: value, Origin: "user1"}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	// re-notify every 0.5s
+	sendRulePoint(data.PointTypeRenotify, 0.5/60)
+	sendRulePoint(data.PointTypeAlarm, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	sendRuleTestPoint(t, nc, vin.ID, 11, time.Now())
+	waitState(data.PointValueAlarmUnacknowledged)
+	waitNotification("alarm")
+	waitNotification("re-notify")
+
+	sendRulePoint(data.PointTypeAck, 1)
+	waitState(data.PointValueAlarmAcknowledged)
+
+	r := ruleGet()
+	if r.AckBy != "user1" || r.AckTime == 0 {
+		t.Fatalf("ack by/time not set: %v, %v", r.AckBy, r.AckTime)
+	}
+
+	// drain any notification that may have raced with the ack
+	select {
+	case <-notifications:
+	default:
+	}
+
+	noNotification("acknowledged")
+
+	sendRuleTestPoint(t, nc, vin.ID, 4, time.Now())
+	waitState(data.PointValueAlarmCleared)
+
+	// shelved alarms don't notify
+	sendRulePoint(data.PointTypeShelve, 1)
+	waitState(data.PointValueAlarmShelved)
+	sendRuleTestPoint(t, nc, vin.ID, 11, time.Now())
+	noNotification("shelved")
+
+	// unshelving an active alarm raises it again
+	sendRulePoint(data.PointTypeUnshelve, 1)
+	waitState(data.PointValueAlarmUnacknowledged)
+	waitNotification("unshelve")
+}
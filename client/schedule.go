@@ -5,6 +5,7 @@ import (
 	"log"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,12 @@ type schedule struct {
 	startTime string
 	endTime   string
 	weekdays  []time.Weekday
+	// if dates is set, the schedule is only active on these dates
+	dates []dateRange
+	// the schedule is never active on exclude dates (holidays, etc)
+	exclude []dateRange
+	// start/end times and dates are in this location. If nil, UTC is used.
+	loc *time.Location
 }
 
 func newSchedule(start, end string, weekdays []time.Weekday) *schedule {
@@ -23,12 +30,26 @@ func newSchedule(start, end string, weekdays []time.Weekday) *schedule {
 }
 
 func (s *schedule) activeForTime(t time.Time) (bool, error) {
-	tUTC := t.UTC()
+	loc := s.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	tLoc := t.In(loc)
+
+	startTime := s.startTime
+	endTime := s.endTime
+
+	// if start and end are not specified, the schedule is active all day
+	if startTime == "" && endTime == "" {
+		startTime = "0:00"
+		endTime = "0:00"
+	}
 
 	// parse out hour/minute
-	matches := reHourMin.FindStringSubmatch(s.startTime)
+	matches := reHourMin.FindStringSubmatch(startTime)
 	if len(matches) < 3 {
-		return false, fmt.Errorf("TimeRange: invalid start: %v ", s.startTime)
+		return false, fmt.Errorf("TimeRange: invalid start: %v ", startTime)
 	}
 
 	startHour, err := strconv.Atoi(matches[1])
@@ -41,9 +62,9 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 		return false, fmt.Errorf("TimeRange: error parsing start hour: %v", matches[1])
 	}
 
-	matches = reHourMin.FindStringSubmatch(s.endTime)
+	matches = reHourMin.FindStringSubmatch(endTime)
 	if len(matches) < 3 {
-		return false, fmt.Errorf("TimeRange: invalid end: %v ", s.endTime)
+		return false, fmt.Errorf("TimeRange: invalid end: %v ", endTime)
 	}
 
 	endHour, err := strconv.Atoi(matches[1])
@@ -58,12 +79,12 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 		return false, fmt.Errorf("TimeRange: error parsing end hour: %v", matches[1])
 	}
 
-	y := tUTC.Year()
-	m := tUTC.Month()
-	d := tUTC.Day()
+	y := tLoc.Year()
+	m := tLoc.Month()
+	d := tLoc.Day()
 
-	start := time.Date(y, m, d, startHour, startMin, 0, 0, time.UTC)
-	end := time.Date(y, m, d, endHour, endMin, 0, 0, time.UTC)
+	start := time.Date(y, m, d, startHour, startMin, 0, 0, loc)
+	end := time.Date(y, m, d, endHour, endMin, 0, 0, loc)
 
 	timeRanges := timeRanges{
 		{start, end},
@@ -79,6 +100,8 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 	}
 
 	timeRanges.filterWeekdays(s.weekdays)
+	timeRanges.filterDates(s.dates)
+	timeRanges.excludeDates(s.exclude)
 
 	if timeRanges.in(t) {
 		return true, nil
@@ -89,7 +112,75 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 
 var reHourMin = regexp.MustCompile(`(\d{1,2}):(\d\d)`)
 
-//var reDate = regexp.MustCompile(`(\d{4})-(\d{1,2})-(\d{1,2})`)
+// scheduleLocation returns the location for a time zone name. If zone is
+// blank, UTC is used, as the web UI converts schedule times to UTC.
+func scheduleLocation(zone string) (*time.Location, error) {
+	if zone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(zone)
+}
+
+const dateLayout = "2006-01-02"
+
+// dateRange is a range of calendar dates (inclusive)
+type dateRange struct {
+	start time.Time
+	end   time.Time
+}
+
+// parseDateRange parses a date (2023-12-25) or date range
+// (2023-12-24/2023-12-26).
+func parseDateRange(s string) (dateRange, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) > 2 {
+		return dateRange{}, fmt.Errorf("invalid date range: %v", s)
+	}
+
+	start, err := time.Parse(dateLayout, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return dateRange{}, fmt.Errorf("invalid date: %v", parts[0])
+	}
+
+	end := start
+	if len(parts) == 2 {
+		end, err = time.Parse(dateLayout, strings.TrimSpace(parts[1]))
+		if err != nil {
+			return dateRange{}, fmt.Errorf("invalid date: %v", parts[1])
+		}
+	}
+
+	if end.Before(start) {
+		return dateRange{}, fmt.Errorf("date range end is before start: %v", s)
+	}
+
+	return dateRange{start: start, end: end}, nil
+}
+
+// parseDateRanges parses a list of dates/date ranges. Blank entries
+// are ignored.
+func parseDateRanges(dates []string) ([]dateRange, error) {
+	var ret []dateRange
+	for _, d := range dates {
+		if strings.TrimSpace(d) == "" {
+			continue
+		}
+		dr, err := parseDateRange(d)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, dr)
+	}
+	return ret, nil
+}
+
+// contains returns true if the calendar date of t (in its own location)
+// is in the date range
+func (dr dateRange) contains(t time.Time) bool {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return !d.Before(dr.start) && !d.After(dr.end)
+}
 
 type timeRange struct {
 	start time.Time
@@ -151,8 +242,8 @@ func (trs *timeRanges) filterWeekdays(weekdays []time.Weekday) {
 	*trs = trsNew
 }
 
-// FilterDates removes time ranges that do not have the same date as the provided list of times
-func (trs *timeRanges) FilterDates(dates []time.Time) {
+// filterDates removes time ranges that do not start on one of the provided dates
+func (trs *timeRanges) filterDates(dates []dateRange) {
 	if len(dates) <= 0 {
 		return
 	}
@@ -161,9 +252,7 @@ func (trs *timeRanges) FilterDates(dates []time.Time) {
 	for _, tr := range *trs {
 		dateFound := false
 		for _, date := range dates {
-			if date.Year() == tr.start.Year() &&
-				date.Month() == tr.start.Month() &&
-				date.Day() == tr.start.Day() {
+			if date.contains(tr.start) {
 				dateFound = true
 				break
 			}
@@ -175,3 +264,26 @@ func (trs *timeRanges) FilterDates(dates []time.Time) {
 
 	*trs = trsNew
 }
+
+// excludeDates removes time ranges that start on one of the provided dates
+func (trs *timeRanges) excludeDates(dates []dateRange) {
+	if len(dates) <= 0 {
+		return
+	}
+
+	trsNew := (*trs)[:0]
+	for _, tr := range *trs {
+		excluded := false
+		for _, date := range dates {
+			if date.contains(tr.start) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			trsNew = append(trsNew, tr)
+		}
+	}
+
+	*trs = trsNew
+}
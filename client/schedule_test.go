@@ -75,3 +75,105 @@ func TestScheduleWrapDayWeekday(t *testing.T) {
 
 	tests.run(t, sched)
 }
+
+func TestScheduleTimeZoneDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data not available: ", err)
+	}
+
+	sched := newSchedule("8:00", "17:00", []time.Weekday{})
+	sched.loc = loc
+
+	// 8:00 EST is 13:00 UTC in winter, and 8:00 EDT is 12:00 UTC in summer
+	tests := testTable{
+		{time.Date(2021, time.February, 10, 12, 30, 0, 0, time.UTC), false},
+		{time.Date(2021, time.February, 10, 13, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.August, 10, 12, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.August, 10, 21, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleTimeZoneWrapDayWeekday(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data not available: ", err)
+	}
+
+	// overnight schedule starting Monday local time
+	sched := newSchedule("22:00", "6:00", []time.Weekday{1})
+	sched.loc = loc
+
+	// 2021-08-09 is a Monday, EDT is UTC-4
+	tests := testTable{
+		// Monday 23:00 local
+		{time.Date(2021, time.August, 10, 3, 0, 0, 0, time.UTC), true},
+		// Tuesday 5:00 local
+		{time.Date(2021, time.August, 10, 9, 0, 0, 0, time.UTC), true},
+		// Tuesday 23:00 local
+		{time.Date(2021, time.August, 11, 3, 0, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleDates(t *testing.T) {
+	sched := newSchedule("", "", []time.Weekday{})
+
+	var err error
+	sched.dates, err = parseDateRanges([]string{"2021-08-09", "2021-12-24/2021-12-26"})
+	if err != nil {
+		t.Fatal("Error parsing dates: ", err)
+	}
+
+	tests := testTable{
+		{time.Date(2021, time.August, 9, 4, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.August, 10, 4, 0, 0, 0, time.UTC), false},
+		{time.Date(2021, time.December, 25, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.December, 27, 1, 0, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleExcludeDates(t *testing.T) {
+	sched := newSchedule("2:00", "5:00", []time.Weekday{})
+
+	var err error
+	sched.exclude, err = parseDateRanges([]string{"2021-12-24/2021-12-26"})
+	if err != nil {
+		t.Fatal("Error parsing dates: ", err)
+	}
+
+	tests := testTable{
+		{time.Date(2021, time.December, 23, 4, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.December, 25, 4, 0, 0, 0, time.UTC), false},
+		{time.Date(2021, time.December, 27, 4, 0, 0, 0, time.UTC), true},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestParseDateRangeErrors(t *testing.T) {
+	for _, d := range []string{"2021-13-01", "2021-12-26/2021-12-24", "2021-12-01/2021-12-02/2021-12-03"} {
+		_, err := parseDateRange(d)
+		if err == nil {
+			t.Error("expected error parsing: ", d)
+		}
+	}
+}
+
+func TestScheduleLocationDefault(t *testing.T) {
+	// the web UI saves schedule times in UTC, so a blank zone must be UTC
+	// regardless of the system time zone
+	loc, err := scheduleLocation("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loc != time.UTC {
+		t.Error("blank zone should be UTC, got: ", loc)
+	}
+}
@@ -28,3 +28,43 @@ func SubjectEdgeAllPoints() string {
 func SubjectNodeHRPoints(nodeID string) string {
 	return fmt.Sprintf("phr.%v", nodeID)
 }
+
+// SubjectRuleTrace constructs a NATS subject for rule evaluation traces
+func SubjectRuleTrace(ruleID string) string {
+	return fmt.Sprintf("rule.%v.trace", ruleID)
+}
+
+// SubjectRuleSim constructs a NATS subject for rule simulation requests
+func SubjectRuleSim(ruleID string) string {
+	return fmt.Sprintf("rule.%v.sim", ruleID)
+}
+
+// SubjectSyncBatch is used by sync clients to send compressed batches of
+// points upstream
+func SubjectSyncBatch() string {
+	return "sync.batch"
+}
+
+// SubjectSyncResync is used to request a full resync of a subtree by a
+// sync client
+func SubjectSyncResync(syncID string) string {
+	return fmt.Sprintf("sync.%v.resync", syncID)
+}
+
+// SubjectModbusTemplates is used to request the list of Modbus device
+// templates
+func SubjectModbusTemplates() string {
+	return "modbus.templates"
+}
+
+// SubjectModbusTemplate is used to instantiate a Modbus device template on
+// a bus
+func SubjectModbusTemplate(busID string) string {
+	return fmt.Sprintf("modbus.%v.template", busID)
+}
+
+// SubjectModbusScan is used to start a scan for devices on a Modbus client
+// bus
+func SubjectModbusScan(busID string) string {
+	return fmt.Sprintf("modbus.%v.scan", busID)
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	degToRad        = math.Pi / 180
+)
+
+func timeToJulian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+func julianToTime(j float64) time.Time {
+	return time.Unix(int64(math.Round((j-julianUnixEpoch)*86400)), 0)
+}
+
+// sunTimes calculates sunrise and sunset for a calendar date at a position
+// using the sunrise equation. Latitude is positive north and longitude is
+// positive east. Returns false if the sun does not rise or set on that date
+// (polar day/night). Accuracy is within a minute or two, which is plenty
+// for automation.
+func sunTimes(year int, month time.Month, day int, pos data.GpsPos) (time.Time, time.Time, bool) {
+	noon := time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	n := math.Ceil(timeToJulian(noon) - julian2000 - 0.0008)
+
+	// mean solar time
+	jStar := n - pos.Long/360
+
+	// solar mean anomaly
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	mRad := m * degToRad
+
+	// equation of the center
+	c := 1.9148*math.Sin(mRad) + 0.02*math.Sin(2*mRad) + 0.0003*math.Sin(3*mRad)
+
+	// ecliptic longitude
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	lambdaRad := lambda * degToRad
+
+	jTransit := julian2000 + jStar + 0.0053*math.Sin(mRad) - 0.0069*math.Sin(2*lambdaRad)
+
+	// declination of the sun
+	sinDec := math.Sin(lambdaRad) * math.Sin(23.4397*degToRad)
+	cosDec := math.Cos(math.Asin(sinDec))
+
+	// hour angle, corrected for refraction and the solar disc
+	latRad := pos.Lat * degToRad
+	cosW := (math.Sin(-0.833*degToRad) - math.Sin(latRad)*sinDec) /
+		(math.Cos(latRad) * cosDec)
+
+	if cosW < -1 || cosW > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	w := math.Acos(cosW) / degToRad
+
+	return julianToTime(jTransit - w/360), julianToTime(jTransit + w/360), true
+}
+
+// nextSunEvent returns the first sunrise or sunset (plus offset) after t.
+// Days are determined in the location of t.
+func nextSunEvent(event string, offset time.Duration, pos data.GpsPos, t time.Time) (time.Time, error) {
+	if event != data.PointValueSunrise && event != data.PointValueSunset {
+		return time.Time{}, fmt.Errorf("invalid sun event: %v", event)
+	}
+
+	if pos.Lat < -90 || pos.Lat > 90 || pos.Long < -180 || pos.Long > 180 {
+		return time.Time{}, fmt.Errorf("invalid position: %v, %v", pos.Lat, pos.Long)
+	}
+
+	// start the day before in case of large offsets or time zones far
+	// from the position. Polar regions may go months without an event.
+	for i := -1; i < 370; i++ {
+		d := t.AddDate(0, 0, i)
+		rise, set, ok := sunTimes(d.Year(), d.Month(), d.Day(), pos)
+		if !ok {
+			continue
+		}
+
+		ev := rise
+		if event == data.PointValueSunset {
+			ev = set
+		}
+
+		ev = ev.Add(offset).In(t.Location())
+		if ev.After(t) {
+			return ev, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no %v found after %v", event, t)
+}
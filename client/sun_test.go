@@ -0,0 +1,201 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func timeNear(a, b time.Time, tolerance time.Duration) bool {
+	d := a.Sub(b)
+	return d <= tolerance && d >= -tolerance
+}
+
+func TestSunTimes(t *testing.T) {
+	nyc := data.GpsPos{Lat: 40.7128, Long: -74.0060}
+
+	tests := []struct {
+		pos  data.GpsPos
+		date time.Time
+		rise time.Time
+		set  time.Time
+	}{
+		{nyc, time.Date(2023, 6, 21, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 6, 21, 9, 25, 0, 0, time.UTC),
+			time.Date(2023, 6, 22, 0, 31, 0, 0, time.UTC)},
+		{nyc, time.Date(2023, 12, 21, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 12, 21, 12, 16, 0, 0, time.UTC),
+			time.Date(2023, 12, 21, 21, 32, 0, 0, time.UTC)},
+		// Sydney
+		{data.GpsPos{Lat: -33.8688, Long: 151.2093},
+			time.Date(2023, 6, 21, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 6, 20, 20, 59, 0, 0, time.UTC),
+			time.Date(2023, 6, 21, 6, 54, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		rise, set, ok := sunTimes(test.date.Year(), test.date.Month(), test.date.Day(), test.pos)
+		if !ok {
+			t.Errorf("%v: expected sunrise/sunset", test.date)
+			continue
+		}
+
+		if !timeNear(rise, test.rise, 2*time.Minute) {
+			t.Errorf("%v: expected sunrise %v, got %v", test.date, test.rise, rise.UTC())
+		}
+
+		if !timeNear(set, test.set, 2*time.Minute) {
+			t.Errorf("%v: expected sunset %v, got %v", test.date, test.set, set.UTC())
+		}
+	}
+
+	// no sunset in the arctic in June
+	_, _, ok := sunTimes(2023, 6, 21, data.GpsPos{Lat: 78.22, Long: 15.65})
+	if ok {
+		t.Error("expected polar day")
+	}
+}
+
+func TestNextSunEvent(t *testing.T) {
+	nyc := data.GpsPos{Lat: 40.7128, Long: -74.0060}
+
+	// after sunrise, the next sunrise is the next day
+	after := time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC)
+	next, err := nextSunEvent(data.PointValueSunrise, 0, nyc, after)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	exp := time.Date(2023, 6, 22, 9, 25, 0, 0, time.UTC)
+	if !timeNear(next, exp, 2*time.Minute) {
+		t.Errorf("expected %v, got %v", exp, next.UTC())
+	}
+
+	// 30 minutes before sunset
+	next, err = nextSunEvent(data.PointValueSunset, -30*time.Minute, nyc, after)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	exp = time.Date(2023, 6, 22, 0, 1, 0, 0, time.UTC)
+	if !timeNear(next, exp, 2*time.Minute) {
+		t.Errorf("expected %v, got %v", exp, next.UTC())
+	}
+
+	_, err = nextSunEvent("noon", 0, nyc, after)
+	if err == nil {
+		t.Error("expected error for invalid event")
+	}
+}
+
+func TestEvalOccurrence(t *testing.T) {
+	rc := &RuleClient{}
+
+	c := Condition{
+		ConditionType: data.PointValueCron,
+		Cron:          "*/15 * * * *",
+		TimeZone:      "UTC",
+	}
+
+	start := time.Date(2023, 5, 1, 10, 0, 30, 0, time.UTC)
+	cs := newConditionState(false, start)
+
+	eval := func(now time.Time, expFired, expRaw bool) {
+		t.Helper()
+		fired, err := rc.evalOccurrence(&c, cs, now)
+		if err != nil {
+			t.Fatal("eval error: ", err)
+		}
+		if fired != expFired {
+			t.Errorf("%v: expected fired %v", now, expFired)
+		}
+		if cs.raw != expRaw {
+			t.Errorf("%v: expected raw %v", now, expRaw)
+		}
+	}
+
+	eval(start.Add(10*time.Minute), false, false)
+
+	fire := time.Date(2023, 5, 1, 10, 15, 1, 0, time.UTC)
+	eval(fire, true, true)
+
+	if c.LastRun != float64(time.Date(2023, 5, 1, 10, 15, 0, 0, time.UTC).Unix()) {
+		t.Error("last run not set to occurrence")
+	}
+
+	// momentary occurrence is cleared on the next evaluation and does not
+	// fire again
+	eval(fire.Add(time.Second), false, false)
+
+	// restart -- we should not fire again for the same occurrence
+	cs = newConditionState(false, fire.Add(time.Minute))
+	eval(fire.Add(time.Minute), false, false)
+
+	// restart during occurrence -- the occurrence should still fire
+	c.LastRun = float64(time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC).Unix())
+	cs = newConditionState(false, fire.Add(time.Minute))
+	eval(fire.Add(time.Minute), true, true)
+
+	// missed occurrences (system down) only fire once for the latest
+	c.LastRun = float64(time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC).Unix())
+	c.Cron = "* * * * *"
+	cs = newConditionState(false, fire)
+	eval(fire, true, true)
+	if c.LastRun != float64(time.Date(2023, 5, 1, 10, 15, 0, 0, time.UTC).Unix()) {
+		t.Error("expected latest occurrence to fire")
+	}
+	eval(fire.Add(time.Second), false, false)
+
+	// active time keeps the condition active after the occurrence
+	c.ActiveTime = 5
+	c.Cron = "*/15 * * * *"
+	eval(time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC), true, true)
+	eval(time.Date(2023, 5, 1, 10, 34, 0, 0, time.UTC), false, true)
+	eval(time.Date(2023, 5, 1, 10, 35, 0, 0, time.UTC), false, false)
+}
+
+func TestNextOccurrenceCache(t *testing.T) {
+	rc := &RuleClient{}
+
+	c := Condition{
+		ConditionType: data.PointValueCron,
+		Cron:          "0 8 * * *",
+		TimeZone:      "UTC",
+	}
+
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	cs := newConditionState(false, start)
+
+	check := func(after, exp time.Time, expCached bool) {
+		t.Helper()
+		base := cs.occurrence.base
+		n, err := rc.nextOccurrence(c, cs, after)
+		if err != nil {
+			t.Fatal("next occurrence error: ", err)
+		}
+		if !n.Equal(exp) {
+			t.Errorf("%v: expected %v, got %v", after, exp, n)
+		}
+		cached := cs.occurrence.base.Equal(base)
+		if cached != expCached {
+			t.Errorf("%v: expected cached %v", after, expCached)
+		}
+	}
+
+	tomorrow := time.Date(2023, 5, 2, 8, 0, 0, 0, time.UTC)
+
+	check(start, tomorrow, false)
+	check(start.Add(time.Hour), tomorrow, true)
+
+	// changing the schedule recalculates
+	c.Cron = "0 9 * * *"
+	check(start.Add(time.Hour), tomorrow.Add(time.Hour), false)
+	check(start.Add(2*time.Hour), tomorrow.Add(time.Hour), true)
+
+	// moving past the occurrence recalculates
+	check(tomorrow.Add(time.Hour), tomorrow.Add(25*time.Hour), false)
+
+	// moving back before the cached base recalculates
+	check(start, tomorrow.Add(time.Hour), false)
+}
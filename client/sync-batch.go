@@ -0,0 +1,249 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// A sync batch is a list of nodes encoded with protobuf (data.Nodes) and
+// compressed with zstd. Each node in the batch holds either node points or
+// edge points for one point message, in the order they were sent.
+
+// syncBatchMaxSize is the max size of a decompressed batch
+const syncBatchMaxSize = 64 << 20
+
+// syncBatchMaxPoints is the number of points that triggers a batch to be
+// sent before the batch period expires
+const syncBatchMaxPoints = 1000
+
+var (
+	syncBatchOnce sync.Once
+	syncBatchEnc  *zstd.Encoder
+	syncBatchDec  *zstd.Decoder
+	syncBatchErr  error
+)
+
+func syncBatchInit() error {
+	syncBatchOnce.Do(func() {
+		syncBatchEnc, syncBatchErr = zstd.NewWriter(nil)
+		if syncBatchErr != nil {
+			return
+		}
+
+		syncBatchDec, syncBatchErr = zstd.NewReader(nil,
+			zstd.WithDecoderMaxMemory(syncBatchMaxSize))
+	})
+
+	return syncBatchErr
+}
+
+// EncodeSyncBatch encodes and compresses a batch of node/edge points
+func EncodeSyncBatch(nodes []data.NodeEdge) ([]byte, error) {
+	err := syncBatchInit()
+	if err != nil {
+		return nil, err
+	}
+
+	n := data.Nodes(nodes)
+	d, err := n.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	return syncBatchEnc.EncodeAll(d, nil), nil
+}
+
+// DecodeSyncBatch decompresses and decodes a batch of node/edge points
+func DecodeSyncBatch(buf []byte) ([]data.NodeEdge, error) {
+	err := syncBatchInit()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := syncBatchDec.DecodeAll(buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing sync batch: %w", err)
+	}
+
+	return data.PbDecodeNodes(d)
+}
+
+// batchCount returns the number of points in the current batch
+func (up *SyncClient) batchCount() int {
+	count := 0
+	for _, n := range up.batch {
+		count += len(n.Points) + len(n.EdgePoints)
+	}
+	return count
+}
+
+// addBatch adds node or edge points to the batch. The batch is sent when
+// the batch period expires or the batch is full.
+func (up *SyncClient) addBatch(n data.NodeEdge) {
+	now := time.Now()
+	for _, pts := range []data.Points{n.Points, n.EdgePoints} {
+		for i := range pts {
+			if pts[i].Time.IsZero() {
+				pts[i].Time = now
+			}
+		}
+	}
+
+	if len(up.batch) <= 0 {
+		up.batchTimer.Reset(time.Duration(up.config.BatchPeriod) * time.Millisecond)
+	}
+
+	up.batch = append(up.batch, n)
+
+	if up.batchCount() >= syncBatchMaxPoints {
+		up.sendBatch()
+	}
+}
+
+// sendPointsRemote sends node points upstream, or adds them to the batch
+// if batching is enabled. Points that need to be acked are always sent
+// immediately.
+func (up *SyncClient) sendPointsRemote(nodeID string, points data.Points, ack bool) error {
+	if !up.connected {
+		up.queueAdd(data.NodeEdge{ID: nodeID, Points: points})
+		return nil
+	}
+
+	if up.config.BatchPeriod > 0 && !ack {
+		up.addBatch(data.NodeEdge{ID: nodeID, Points: points})
+		return nil
+	}
+
+	err := SendNodePoints(up.ncRemote, nodeID, points, ack)
+	if err != nil {
+		return err
+	}
+
+	up.synced.add(nodeID, points)
+	return nil
+}
+
+// syncBatchResult is the result of a batch sent upstream
+type syncBatchResult struct {
+	batch []data.NodeEdge
+	err   error
+}
+
+// sendBatch sends the current batch upstream. The request runs in its own
+// goroutine so a slow upstream does not hold up the sync loop, and the
+// result is handled by batchSent. Only one batch is in flight at a time so
+// batches arrive in order; points added meanwhile are sent when the
+// current request completes.
+func (up *SyncClient) sendBatch() {
+	if len(up.batch) <= 0 || up.batchSending {
+		return
+	}
+
+	batch := up.batch
+	up.batch = nil
+	up.batchTimer.Stop()
+	up.batchSending = true
+
+	nc := up.ncRemote
+	go func() {
+		up.batchResults <- syncBatchResult{batch: batch, err: requestBatch(nc, batch)}
+	}()
+}
+
+// flushBatch waits for the batch in flight and then sends the current
+// batch before returning. This is used before the remote connection is
+// closed.
+func (up *SyncClient) flushBatch() {
+	if up.batchSending {
+		up.batchSent(<-up.batchResults)
+	}
+
+	if len(up.batch) <= 0 {
+		return
+	}
+
+	batch := up.batch
+	up.batch = nil
+	up.batchTimer.Stop()
+	up.batchSent(syncBatchResult{batch: batch, err: requestBatch(up.ncRemote, batch)})
+}
+
+// batchSent handles the result of sending a batch. If sending failed, the
+// batch is added to the offline queue if enabled, otherwise it is dropped
+// and the periodic sync will reconcile the latest state.
+func (up *SyncClient) batchSent(res syncBatchResult) {
+	up.batchSending = false
+
+	if res.err != nil {
+		log.Println("Sync: error sending batch: ", res.err)
+		up.setError(res.err)
+		for _, n := range res.batch {
+			up.queueAdd(n)
+		}
+	} else {
+		for _, n := range res.batch {
+			up.synced.add(n.ID, n.Points)
+		}
+	}
+
+	// points added while the request was in flight
+	up.sendBatch()
+}
+
+// requestBatch encodes a batch and sends it upstream
+func requestBatch(nc *nats.Conn, batch []data.NodeEdge) error {
+	if nc == nil {
+		return errors.New("not connected")
+	}
+
+	d, err := EncodeSyncBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	msg, err := nc.Request(SubjectSyncBatch(), d, time.Second*20)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Data) > 0 {
+		return fmt.Errorf("upstream error processing batch: %v", string(msg.Data))
+	}
+
+	return nil
+}
+
+// reportBytes updates the bytes sent/received points from the remote
+// connection stats. The counts accumulate across connections and can be
+// reset by setting the points to 0.
+func (up *SyncClient) reportBytes() {
+	if up.ncRemote == nil {
+		return
+	}
+
+	stats := up.ncRemote.Stats()
+	if stats.OutBytes == up.statsOut && stats.InBytes == up.statsIn {
+		return
+	}
+
+	up.config.BytesSent += float64(stats.OutBytes - up.statsOut)
+	up.config.BytesReceived += float64(stats.InBytes - up.statsIn)
+	up.statsOut, up.statsIn = stats.OutBytes, stats.InBytes
+
+	points := data.Points{
+		{Type: data.PointTypeBytesSent, Value: up.config.BytesSent},
+		{Type: data.PointTypeBytesReceived, Value: up.config.BytesReceived},
+	}
+
+	err := SendNodePoints(up.nc, up.config.ID, points, false)
+	if err != nil {
+		log.Println("Sync: error sending byte stats: ", err)
+	}
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// A conflict is detected during a sync when a node point was changed on
+// both the local and upstream instances since the last successful sync
+// (typically while disconnected) and the values are different. How the
+// conflict is resolved depends on the conflict policy of the sync node:
+//
+//   - newest (default): the point with the newest timestamp wins
+//   - upstream: the upstream value wins
+//   - downstream: the local value wins
+//   - manual: the point is not synced until the conflict is resolved with
+//     a conflictResolve point, or the point is changed again on either side
+//
+// Each conflict is recorded in a conflict point on the sync node keyed by
+// node ID, point type, and point key so that operators can review the
+// value that was overwritten. Edge points and the points of the sync node
+// itself are not checked for conflicts.
+//
+// Points that were synced while connected (sent upstream, or received from
+// upstream) are tracked so that a point that was changed on one side and
+// synced before the connection was lost is not flagged as a conflict.
+
+// syncedPoints tracks the timestamps of points synced since the last sync
+type syncedPoints struct {
+	lock  sync.Mutex
+	times map[string]time.Time
+}
+
+func newSyncedPoints() *syncedPoints {
+	return &syncedPoints{times: make(map[string]time.Time)}
+}
+
+func (sp *syncedPoints) add(nodeID string, points data.Points) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	for _, p := range points {
+		sp.times[conflictKey(nodeID, p)] = p.Time
+	}
+}
+
+// synced returns true if the point with this timestamp was synced
+func (sp *syncedPoints) synced(nodeID string, p data.Point) bool {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	t, ok := sp.times[conflictKey(nodeID, p)]
+	return ok && t.Equal(p.Time)
+}
+
+// prune removes points older than t
+func (sp *syncedPoints) prune(t time.Time) {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	for k, v := range sp.times {
+		if v.Before(t) {
+			delete(sp.times, k)
+		}
+	}
+}
+
+// SyncConflict is the conflict record stored (as JSON) in the text field of
+// conflict points on the sync node.
+type SyncConflict struct {
+	NodeID   string     `json:"nodeId"`
+	Time     time.Time  `json:"time"`
+	Policy   string     `json:"policy"`
+	Winner   string     `json:"winner"`
+	Local    data.Point `json:"local"`
+	Upstream data.Point `json:"upstream"`
+}
+
+// Resolved returns true if the conflict has been resolved
+func (sc SyncConflict) Resolved() bool {
+	return sc.Winner != ""
+}
+
+func (sc SyncConflict) String() string {
+	winner := sc.Winner
+	if winner == "" {
+		winner = "unresolved"
+	}
+	return fmt.Sprintf("CONFLICT %v %v:%v local: %v, upstream: %v, policy: %v, winner: %v",
+		sc.NodeID, sc.Local.Type, sc.Local.Key, pointValueString(sc.Local),
+		pointValueString(sc.Upstream), sc.Policy, winner)
+}
+
+func pointValueString(p data.Point) string {
+	if p.Text != "" {
+		return p.Text
+	}
+	return fmt.Sprintf("%v", p.Value)
+}
+
+func conflictKey(nodeID string, p data.Point) string {
+	return nodeID + "." + p.Type + "." + p.Key
+}
+
+func pointValuesEqual(a, b data.Point) bool {
+	return a.Value == b.Value && a.Text == b.Text &&
+		string(a.Data) == string(b.Data) && a.Tombstone == b.Tombstone
+}
+
+// conflict returns the conflict record for a key
+func (up *SyncClient) conflict(key string) (SyncConflict, bool) {
+	var ret SyncConflict
+
+	txt, ok := up.config.Conflicts[key]
+	if !ok || txt == "" {
+		return ret, false
+	}
+
+	err := json.Unmarshal([]byte(txt), &ret)
+	if err != nil {
+		log.Println("Sync: error decoding conflict record: ", err)
+		return ret, false
+	}
+
+	return ret, true
+}
+
+// setConflict saves a conflict record on the sync node
+func (up *SyncClient) setConflict(key string, sc SyncConflict) {
+	d, err := json.Marshal(sc)
+	if err != nil {
+		log.Println("Sync: error encoding conflict record: ", err)
+		return
+	}
+
+	p := data.Point{Type: data.PointTypeConflict, Key: key, Text: string(d),
+		Time: time.Now()}
+
+	err = data.MergePoints(up.config.ID, data.Points{p}, &up.config)
+	if err != nil {
+		log.Println("Sync: error merging conflict record: ", err)
+	}
+
+	err = SendNodePoint(up.nc, up.config.ID, p, false)
+	if err != nil {
+		log.Println("Sync: error sending conflict record: ", err)
+	}
+}
+
+// applyConflictWinner writes the winning value with a new timestamp to
+// both sides, as the losing side may have a newer timestamp.
+func (up *SyncClient) applyConflictWinner(nodeID string, p data.Point) error {
+	p.Time = time.Now()
+	p.Origin = ""
+
+	err := SendNodePoint(up.nc, nodeID, p, true)
+	if err != nil {
+		return fmt.Errorf("error writing local point: %w", err)
+	}
+
+	if up.ncRemote == nil {
+		return nil
+	}
+
+	err = SendNodePoints(up.ncRemote, nodeID, data.Points{p}, true)
+	if err != nil {
+		return fmt.Errorf("error writing upstream point: %w", err)
+	}
+
+	return nil
+}
+
+// syncConflict checks a local and upstream point with different
+// timestamps for a conflict. Returns true if the conflict was handled and
+// the point should not be synced by timestamp.
+func (up *SyncClient) syncConflict(nodeID string, p, pUp data.Point) bool {
+	key := conflictKey(nodeID, p)
+
+	if sc, ok := up.conflict(key); ok && !sc.Resolved() {
+		if !p.Time.After(sc.Time) && !pUp.Time.After(sc.Time) {
+			// waiting for manual resolution
+			return true
+		}
+
+		// the point was changed after the conflict was detected, so
+		// resolve by timestamp
+		sc.Winner = data.PointValueNewest
+		up.setConflict(key, sc)
+		return false
+	}
+
+	if up.config.LastSync <= 0 || nodeID == up.config.ID ||
+		pointValuesEqual(p, pUp) {
+		return false
+	}
+
+	lastSync := unixToTime(up.config.LastSync)
+	changed := func(p data.Point) bool {
+		return p.Time.After(lastSync) && !up.synced.synced(nodeID, p)
+	}
+
+	if !changed(p) || !changed(pUp) {
+		return false
+	}
+
+	sc := SyncConflict{
+		NodeID:   nodeID,
+		Time:     time.Now(),
+		Policy:   up.config.ConflictPolicy,
+		Local:    p,
+		Upstream: pUp,
+	}
+
+	handled := true
+
+	switch sc.Policy {
+	case data.PointValueUpstream:
+		sc.Winner = data.PointValueUpstream
+	case data.PointValueDownstream:
+		sc.Winner = data.PointValueDownstream
+	case data.PointValueManual:
+	default:
+		sc.Policy = data.PointValueNewest
+		sc.Winner = data.PointValueUpstream
+		if p.Time.After(pUp.Time) {
+			sc.Winner = data.PointValueDownstream
+		}
+		handled = false
+	}
+
+	log.Printf("Sync %v: %v\n", up.config.Description, sc)
+
+	up.setConflict(key, sc)
+
+	if handled && sc.Resolved() {
+		winner := pUp
+		if sc.Winner == data.PointValueDownstream {
+			winner = p
+		}
+
+		err := up.applyConflictWinner(nodeID, winner)
+		if err != nil {
+			log.Println("Sync: error resolving conflict: ", err)
+		}
+	}
+
+	return handled
+}
+
+// conflictCommand processes conflictResolve and conflictClear points sent
+// to the sync node
+func (up *SyncClient) conflictCommand(p data.Point) {
+	switch p.Type {
+	case data.PointTypeConflictResolve:
+		sc, ok := up.conflict(p.Key)
+		if !ok || sc.Resolved() {
+			return
+		}
+
+		var winner data.Point
+		switch p.Text {
+		case data.PointValueUpstream:
+			winner = sc.Upstream
+		case data.PointValueDownstream:
+			winner = sc.Local
+		default:
+			log.Println("Sync: invalid conflict resolution: ", p.Text)
+			return
+		}
+
+		err := up.applyConflictWinner(sc.NodeID, winner)
+		if err != nil {
+			log.Println("Sync: error resolving conflict: ", err)
+			return
+		}
+
+		sc.Winner = p.Text
+		up.setConflict(p.Key, sc)
+
+	case data.PointTypeConflictClear:
+		if p.Value == 0 {
+			return
+		}
+
+		now := time.Now()
+		var pts data.Points
+		for key, txt := range up.config.Conflicts {
+			if txt == "" {
+				continue
+			}
+			if sc, ok := up.conflict(key); ok && !sc.Resolved() {
+				continue
+			}
+			pts = append(pts, data.Point{Type: data.PointTypeConflict,
+				Key: key, Time: now, Tombstone: 1})
+		}
+
+		pts = append(pts, data.Point{Type: data.PointTypeConflictClear, Time: now})
+
+		err := data.MergePoints(up.config.ID, pts, &up.config)
+		if err != nil {
+			log.Println("Sync: error merging conflict clear: ", err)
+		}
+
+		err = SendNodePoints(up.nc, up.config.ID, pts, false)
+		if err != nil {
+			log.Println("Sync: error clearing conflicts: ", err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// setError records the last sync error. A nil error clears it.
+func (up *SyncClient) setError(err error) {
+	if err != nil {
+		up.lastError = err.Error()
+	} else {
+		up.lastError = ""
+	}
+}
+
+// reportDiag sends the sync diagnostic points that have changed since
+// they were last sent. nodesOutOfSync is the number of nodes that were
+// not in sync during the last sync (or resync), and queueSize is the
+// number of points waiting in the offline queue and current batch.
+func (up *SyncClient) reportDiag() {
+	connected := up.connected && up.ncRemote != nil && up.ncRemote.IsConnected()
+
+	var rtt float64
+	if connected {
+		d, err := up.ncRemote.RTT()
+		if err != nil {
+			log.Println("Sync: error getting RTT: ", err)
+		} else {
+			rtt = float64(d) / float64(time.Millisecond)
+		}
+	}
+
+	queueSize := up.batchCount()
+	if up.queue != nil {
+		queueSize += up.queue.count
+	}
+
+	var points data.Points
+
+	if connected != up.config.Connected {
+		up.config.Connected = connected
+		points = append(points, data.Point{Type: data.PointTypeConnected,
+			Value: data.BoolToFloat(connected)})
+	}
+
+	if rtt != up.config.RTT {
+		up.config.RTT = rtt
+		points = append(points, data.Point{Type: data.PointTypeRTT, Value: rtt})
+	}
+
+	if up.nodesOutOfSync != up.config.NodesOutOfSync {
+		up.config.NodesOutOfSync = up.nodesOutOfSync
+		points = append(points, data.Point{Type: data.PointTypeNodesOutOfSync,
+			Value: float64(up.nodesOutOfSync)})
+	}
+
+	if queueSize != up.config.QueueSize {
+		up.config.QueueSize = queueSize
+		points = append(points, data.Point{Type: data.PointTypeQueueSize,
+			Value: float64(queueSize)})
+	}
+
+	if up.lastError != up.config.LastError {
+		up.config.LastError = up.lastError
+		points = append(points, data.Point{Type: data.PointTypeLastError,
+			Text: up.lastError})
+	}
+
+	if len(points) == 0 {
+		return
+	}
+
+	err := SendNodePoints(up.nc, up.config.ID, points, false)
+	if err != nil {
+		log.Println("Sync: error sending diagnostics: ", err)
+	}
+}
+
+// handleResync handles a resync request. The request data is the ID of the
+// node to resync (empty for the entire tree). All nodes in the subtree are
+// compared point by point, even if the hashes match.
+func (up *SyncClient) handleResync(msg *nats.Msg) {
+	id := string(msg.Data)
+
+	var err error
+
+	if !up.connected {
+		err = errors.New("not connected")
+	} else {
+		up.syncForce = true
+		if id == "" || id == up.rootLocal.ID {
+			err = up.syncRoot()
+		} else {
+			up.nodesOutOfSync = 0
+			err = up.syncNode("all", id)
+		}
+		up.syncForce = false
+		up.setError(err)
+		up.reportDiag()
+	}
+
+	var ret string
+	if err != nil {
+		log.Printf("Sync: %v: resync error: %v\n", up.config.Description, err)
+		ret = err.Error()
+	}
+
+	err = msg.Respond([]byte(ret))
+	if err != nil {
+		log.Println("Error responding to sync resync request: ", err)
+	}
+}
+
+// SyncResync requests a sync client to do a full resync of the subtree
+// starting at nodeID. If nodeID is blank, the entire tree is synced.
+func SyncResync(nc *nats.Conn, syncID, nodeID string) error {
+	msg, err := nc.Request(SubjectSyncResync(syncID), []byte(nodeID), time.Second*20)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Data) > 0 {
+		return errors.New(string(msg.Data))
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Sync filters limit what is sent upstream:
+//
+//   - a node is synced only if it and all of its ancestors pass the node
+//     ID and node type filters. The local root node is always synced.
+//   - if include node IDs are set, only those subtrees are synced
+//   - if include node types are set, only nodes of those types are synced
+//   - node points are filtered by the point type filters. Edge points are
+//     never filtered as they describe the structure of the tree.
+//   - throttled point types are sent at most once per throttle period per
+//     node/type/key. The latest value is sent at the end of the period.
+//
+// Filters only apply to data sent upstream.
+
+// syncNodeFilter is the cached filter result for a node
+type syncNodeFilter struct {
+	// allowed is true if the node is synced
+	allowed bool
+	// included is true if the node is in an included subtree
+	included bool
+}
+
+type syncThrottle struct {
+	last    time.Time
+	nodeID  string
+	pending *data.Point
+}
+
+func filterContains(list []string, v string) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEmpty returns true if there are no non-blank entries in a filter
+// list
+func filterEmpty(list []string) bool {
+	for _, l := range list {
+		if l != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// pointAllowed returns true if a node point passes the point type filters
+func (up *SyncClient) pointAllowed(p data.Point) bool {
+	if filterContains(up.config.ExcludePointTypes, p.Type) {
+		return false
+	}
+
+	if !filterEmpty(up.config.IncludePointTypes) &&
+		!filterContains(up.config.IncludePointTypes, p.Type) {
+		return false
+	}
+
+	return true
+}
+
+func (up *SyncClient) filterPoints(points data.Points) data.Points {
+	ret := make(data.Points, 0, len(points))
+	for _, p := range points {
+		if up.pointAllowed(p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// resetFilterCache is called when the sync config or the local tree
+// structure changes
+func (up *SyncClient) resetFilterCache() {
+	up.filterCache = make(map[string]syncNodeFilter)
+	up.hashCache = make(map[string]syncHash)
+}
+
+// nodeFilter evaluates the filters for a node. The parent filter result is
+// looked up (and cached) as needed.
+func (up *SyncClient) nodeFilter(node data.NodeEdge) syncNodeFilter {
+	return up.nodeFilterDepth(node, 0)
+}
+
+func (up *SyncClient) nodeFilterDepth(node data.NodeEdge, depth int) syncNodeFilter {
+	if f, ok := up.filterCache[node.ID]; ok {
+		return f
+	}
+
+	includeAll := filterEmpty(up.config.IncludeNodeIDs)
+
+	var f syncNodeFilter
+
+	switch {
+	case node.ID == up.rootLocal.ID:
+		f = syncNodeFilter{
+			allowed:  true,
+			included: includeAll || filterContains(up.config.IncludeNodeIDs, node.ID),
+		}
+	case depth > 100:
+		log.Println("Sync filter: tree is too deep, loop?: ", node.ID)
+	case filterContains(up.config.ExcludeNodeIDs, node.ID),
+		filterContains(up.config.ExcludeNodeTypes, node.Type),
+		!filterEmpty(up.config.IncludeNodeTypes) &&
+			!filterContains(up.config.IncludeNodeTypes, node.Type):
+		// excluded
+	default:
+		parent, ok := up.nodeFilterID(node.Parent, depth+1)
+		if !ok || !parent.allowed {
+			break
+		}
+
+		f.included = parent.included ||
+			filterContains(up.config.IncludeNodeIDs, node.ID)
+		f.allowed = f.included
+	}
+
+	up.filterCache[node.ID] = f
+	return f
+}
+
+// nodeFilterID looks up a node and evaluates the filters for it. Returns
+// false if the node was not found.
+func (up *SyncClient) nodeFilterID(id string, depth int) (syncNodeFilter, bool) {
+	if f, ok := up.filterCache[id]; ok {
+		return f, true
+	}
+
+	// include deleted nodes so that deletes are synced
+	nodes, err := GetNodes(up.nc, "all", id, "", true)
+	if err != nil || len(nodes) < 1 {
+		return syncNodeFilter{}, false
+	}
+
+	return up.nodeFilterDepth(nodes[0], depth), true
+}
+
+// nodeAllowed returns true if points for the node ID should be sent
+// upstream
+func (up *SyncClient) nodeAllowed(id string) bool {
+	f, _ := up.nodeFilterID(id, 0)
+	return f.allowed
+}
+
+// filtersActive returns true if any node or point filters are set
+func (up *SyncClient) filtersActive() bool {
+	return !filterEmpty(up.config.IncludeNodeIDs) ||
+		!filterEmpty(up.config.ExcludeNodeIDs) ||
+		!filterEmpty(up.config.IncludeNodeTypes) ||
+		!filterEmpty(up.config.ExcludeNodeTypes) ||
+		!filterEmpty(up.config.IncludePointTypes) ||
+		!filterEmpty(up.config.ExcludePointTypes)
+}
+
+// filteredHash returns the hash of a local node computed over the content
+// that is synced upstream. Node hashes are the XOR of the point CRCs and
+// child hashes, so filtered points and excluded children are backed out.
+// This walks the local subtree when filters are set, so a filtered tree
+// that is in sync compares equal without any upstream requests. Results
+// are cached by node hash for the sync pass, so each subtree is only
+// walked once even though syncNode asks for child hashes again as it
+// recurses.
+func (up *SyncClient) filteredHash(node data.NodeEdge) (uint32, error) {
+	if !up.filtersActive() {
+		return node.Hash, nil
+	}
+
+	key := node.ID + "/" + node.Parent
+	if h, ok := up.hashCache[key]; ok && h.hash == node.Hash {
+		return h.filtered, nil
+	}
+
+	hash := node.Hash
+	for _, p := range node.Points {
+		if !up.pointAllowed(p) {
+			hash ^= p.CRC()
+		}
+	}
+
+	// deleted children are included as they are part of the node hash
+	children, err := GetNodes(up.ncLocal, node.ID, "all", "", true)
+	if err != nil {
+		return hash, fmt.Errorf("Error getting local node children: %w", err)
+	}
+
+	for _, c := range children {
+		if !up.nodeFilter(c).allowed {
+			hash ^= c.Hash
+			continue
+		}
+
+		cHash, err := up.filteredHash(c)
+		if err != nil {
+			return hash, err
+		}
+		hash ^= c.Hash ^ cHash
+	}
+
+	up.hashCache[key] = syncHash{hash: node.Hash, filtered: hash}
+
+	return hash, nil
+}
+
+// syncHash is a cached filtered hash for a local node
+type syncHash struct {
+	hash     uint32
+	filtered uint32
+}
+
+// filteredHashUp returns the hash of an upstream node with points that
+// are not synced backed out
+func (up *SyncClient) filteredHashUp(node data.NodeEdge) uint32 {
+	hash := node.Hash
+	for _, p := range node.Points {
+		if !up.pointAllowed(p) {
+			hash ^= p.CRC()
+		}
+	}
+	return hash
+}
+
+// throttlePoints returns the points that can be sent now. Throttled points
+// are held and sent later by flushThrottle.
+func (up *SyncClient) throttlePoints(nodeID string, points data.Points, now time.Time) data.Points {
+	if len(up.config.Throttle) <= 0 {
+		return points
+	}
+
+	ret := make(data.Points, 0, len(points))
+
+	for _, p := range points {
+		period := time.Duration(up.config.Throttle[p.Type] * float64(time.Second))
+		if period <= 0 {
+			ret = append(ret, p)
+			continue
+		}
+
+		key := nodeID + "." + p.Type + "." + p.Key
+		t, ok := up.throttles[key]
+		if !ok {
+			t = &syncThrottle{nodeID: nodeID}
+			up.throttles[key] = t
+		}
+
+		if now.Sub(t.last) >= period {
+			t.last = now
+			t.pending = nil
+			ret = append(ret, p)
+			continue
+		}
+
+		if t.pending == nil || !p.Time.Before(t.pending.Time) {
+			pCopy := p
+			t.pending = &pCopy
+		}
+	}
+
+	return ret
+}
+
+// flushThrottle sends held points whose throttle period has expired
+func (up *SyncClient) flushThrottle(now time.Time) {
+	for key, t := range up.throttles {
+		if t.pending == nil {
+			if now.Sub(t.last) > time.Hour {
+				delete(up.throttles, key)
+			}
+			continue
+		}
+
+		period := time.Duration(up.config.Throttle[t.pending.Type] * float64(time.Second))
+		if now.Sub(t.last) < period {
+			continue
+		}
+
+		if up.ncRemote != nil {
+			err := up.sendPointsRemote(t.nodeID, data.Points{*t.pending}, false)
+			if err != nil {
+				log.Println("Sync: error sending throttled point: ", err)
+				continue
+			}
+		}
+
+		t.last = now
+		t.pending = nil
+	}
+}
+
+// sendNodePointsRemote filters and throttles points before sending them
+// upstream
+func (up *SyncClient) sendNodePointsRemote(nodeID string, points data.Points, ack bool) error {
+	if !up.nodeAllowed(nodeID) {
+		return nil
+	}
+
+	points = up.throttlePoints(nodeID, up.filterPoints(points), time.Now())
+	if len(points) <= 0 {
+		return nil
+	}
+
+	return up.sendPointsRemote(nodeID, points, ack)
+}
+
+// sendEdgePointsRemote sends edge points upstream if the node passes the
+// node filters
+func (up *SyncClient) sendEdgePointsRemote(nodeID, parentID string, points data.Points) error {
+	if !up.nodeAllowed(nodeID) {
+		return nil
+	}
+
+	n := data.NodeEdge{ID: nodeID, Parent: parentID, EdgePoints: points}
+
+	if !up.connected {
+		up.queueAdd(n)
+		return nil
+	}
+
+	if up.config.BatchPeriod > 0 {
+		up.addBatch(n)
+		return nil
+	}
+
+	return SendEdgePoints(up.ncRemote, nodeID, parentID, points, false)
+}
@@ -0,0 +1,393 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// The offline queue stores point changes while the upstream connection is
+// down so that they can be replayed in order (with original timestamps)
+// when the connection is restored. The queue file starts with an 8 byte big
+// endian header that holds the offset of the first record that has not
+// been replayed, followed by a sequence of records, each a 4 byte big
+// endian length followed by a protobuf encoded data.Nodes containing one
+// node or edge point message. Replayed records are skipped by moving the
+// header offset, and the file is truncated when the queue is empty.
+//
+// The queue file is stored in the SIOT_DATA directory and is kept across
+// restarts. If the queue is full, new points are dropped and the periodic
+// sync reconciles the latest state.
+
+// syncQueueMaxRecord is the max size of a queue record
+const syncQueueMaxRecord = 16 << 20
+
+// syncQueueHeaderLen is the size of the queue file header
+const syncQueueHeaderLen = 8
+
+type syncQueue struct {
+	path  string
+	f     *os.File
+	count int
+	// head is the offset of the first record that has not been
+	// replayed, or 0 if the file is empty
+	head int64
+}
+
+// syncQueuePath returns the queue file path for a sync node
+func syncQueuePath(id string) string {
+	dataDir := os.Getenv("SIOT_DATA")
+	if dataDir == "" {
+		dataDir = "./"
+	}
+
+	return filepath.Join(dataDir, "sync-"+id+".queue")
+}
+
+func pointCount(n data.NodeEdge) int {
+	return len(n.Points) + len(n.EdgePoints)
+}
+
+// openSyncQueue opens or creates a queue file. Existing records are
+// counted, and a partially written record at the end of the file (power
+// loss during write) is discarded.
+func openSyncQueue(path string) (*syncQueue, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &syncQueue{path: path, f: f}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.Size() >= syncQueueHeaderLen {
+		h := make([]byte, syncQueueHeaderLen)
+		_, err = f.ReadAt(h, 0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		q.head = int64(binary.BigEndian.Uint64(h))
+		if q.head < syncQueueHeaderLen || q.head > info.Size() {
+			log.Printf("Sync queue %v: invalid header, replaying all records\n", path)
+			q.head = syncQueueHeaderLen
+		}
+	}
+
+	end := q.head
+	err = q.read(func(n data.NodeEdge, offset int64) error {
+		q.count += pointCount(n)
+		end = offset
+		return nil
+	})
+	if err != nil {
+		log.Printf("Sync queue %v: discarding corrupt data: %v\n", path, err)
+	}
+
+	if q.count <= 0 {
+		end = 0
+		q.head = 0
+	}
+
+	err = f.Truncate(end)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// read calls fn for each record in the queue, starting at the head, with
+// the file offset of the end of the record
+func (q *syncQueue) read(fn func(n data.NodeEdge, offset int64) error) error {
+	if q.head <= 0 {
+		return nil
+	}
+
+	_, err := q.f.Seek(q.head, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(q.f)
+	offset := q.head
+
+	for {
+		var l uint32
+		err := binary.Read(r, binary.BigEndian, &l)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if l > syncQueueMaxRecord {
+			return fmt.Errorf("record too large: %v", l)
+		}
+
+		buf := make([]byte, l)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := data.PbDecodeNodes(buf)
+		if err != nil {
+			return err
+		}
+
+		offset += 4 + int64(l)
+
+		for _, n := range nodes {
+			err = fn(n, offset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// setHead writes the offset of the first record that has not been
+// replayed to the file header
+func (q *syncQueue) setHead(offset int64) error {
+	h := make([]byte, syncQueueHeaderLen)
+	binary.BigEndian.PutUint64(h, uint64(offset))
+	_, err := q.f.WriteAt(h, 0)
+	if err != nil {
+		return err
+	}
+
+	q.head = offset
+	return nil
+}
+
+// add appends a node or edge point message to the queue
+func (q *syncQueue) add(n data.NodeEdge) error {
+	nodes := data.Nodes{n}
+	buf, err := nodes.ToPb()
+	if err != nil {
+		return err
+	}
+
+	rec := make([]byte, 4+len(buf))
+	binary.BigEndian.PutUint32(rec, uint32(len(buf)))
+	copy(rec[4:], buf)
+
+	if q.head <= 0 {
+		err = q.setHead(syncQueueHeaderLen)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = q.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.f.Write(rec)
+	if err != nil {
+		return err
+	}
+
+	q.count += pointCount(n)
+	return nil
+}
+
+var errSyncQueueReplay = errors.New("replay failed")
+
+// replay sends queued records in order in batches of up to max points.
+// The head is moved past each batch that was sent, so a failed replay
+// can be resumed later.
+func (q *syncQueue) replay(max int, send func([]data.NodeEdge) error) error {
+	var batch []data.NodeEdge
+	var batchCount int
+
+	flush := func(offset int64) error {
+		if len(batch) <= 0 {
+			return nil
+		}
+
+		err := send(batch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errSyncQueueReplay, err)
+		}
+
+		q.count -= batchCount
+		batch = nil
+		batchCount = 0
+		return q.setHead(offset)
+	}
+
+	var last int64
+	err := q.read(func(n data.NodeEdge, offset int64) error {
+		if batchCount > 0 && batchCount+pointCount(n) > max {
+			err := flush(last)
+			if err != nil {
+				return err
+			}
+		}
+
+		batch = append(batch, n)
+		batchCount += pointCount(n)
+		last = offset
+		return nil
+	})
+
+	if err == nil {
+		err = flush(last)
+	}
+
+	if err != nil && !errors.Is(err, errSyncQueueReplay) {
+		log.Printf("Sync queue %v: discarding corrupt data: %v\n", q.path, err)
+		err = flush(last)
+		if err == nil {
+			return q.clear()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return q.clear()
+}
+
+// clear removes all records from the queue
+func (q *syncQueue) clear() error {
+	q.count = 0
+	q.head = 0
+	return q.f.Truncate(0)
+}
+
+func (q *syncQueue) close() error {
+	return q.f.Close()
+}
+
+// openQueue opens the offline queue for the sync node
+func (up *SyncClient) openQueue() {
+	if up.queue != nil {
+		return
+	}
+
+	var err error
+	up.queue, err = openSyncQueue(syncQueuePath(up.config.ID))
+	if err != nil {
+		log.Println("Sync: error opening queue: ", err)
+	}
+}
+
+func (up *SyncClient) closeQueue() {
+	if up.queue == nil {
+		return
+	}
+
+	err := up.queue.close()
+	if err != nil {
+		log.Println("Sync: error closing queue: ", err)
+	}
+	up.queue = nil
+}
+
+// queueAdd adds node or edge points to the offline queue if enabled
+func (up *SyncClient) queueAdd(n data.NodeEdge) {
+	if up.config.QueueMax <= 0 || up.config.Disable {
+		return
+	}
+
+	up.openQueue()
+	if up.queue == nil {
+		return
+	}
+
+	if up.queue.count+pointCount(n) > up.config.QueueMax {
+		if !up.queueFull {
+			log.Printf("Sync: %v: offline queue is full, dropping points\n",
+				up.config.Description)
+			up.queueFull = true
+		}
+		return
+	}
+
+	err := up.queue.add(n)
+	if err != nil {
+		log.Println("Sync: error adding points to queue: ", err)
+	}
+}
+
+// replayQueue sends queued points upstream in order
+func (up *SyncClient) replayQueue() {
+	if up.queue == nil || up.queue.count <= 0 {
+		return
+	}
+
+	log.Printf("Sync: %v: replaying %v queued points\n",
+		up.config.Description, up.queue.count)
+
+	err := up.queue.replay(syncBatchMaxPoints, up.replaySend)
+	if err != nil {
+		log.Println("Sync: error replaying queue: ", err)
+		up.setError(err)
+	}
+
+	up.queueFull = false
+}
+
+// replaySend sends replayed queue records upstream. Records are sent as a
+// batch if batching is enabled. If the upstream does not handle batches
+// (older versions), or batching is disabled, the records are sent as
+// individual point messages.
+func (up *SyncClient) replaySend(batch []data.NodeEdge) error {
+	if up.config.BatchPeriod > 0 {
+		err := requestBatch(up.ncRemote, batch)
+		if err == nil {
+			for _, n := range batch {
+				up.synced.add(n.ID, n.Points)
+			}
+			return nil
+		}
+		if !errors.Is(err, nats.ErrNoResponders) && !errors.Is(err, nats.ErrTimeout) {
+			return err
+		}
+		log.Println("Sync: upstream did not handle batch, sending points: ", err)
+	}
+
+	if up.ncRemote == nil {
+		return errors.New("not connected")
+	}
+
+	for _, n := range batch {
+		if len(n.Points) > 0 {
+			err := SendNodePoints(up.ncRemote, n.ID, n.Points, true)
+			if err != nil {
+				return err
+			}
+			up.synced.add(n.ID, n.Points)
+		}
+
+		if len(n.EdgePoints) > 0 {
+			err := SendEdgePoints(up.ncRemote, n.ID, n.Parent, n.EdgePoints, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
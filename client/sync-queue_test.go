@@ -0,0 +1,205 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func queueValues(t *testing.T, nodes []data.NodeEdge) []float64 {
+	var ret []float64
+	for _, n := range nodes {
+		for _, p := range n.Points {
+			ret = append(ret, p.Value)
+		}
+	}
+	return ret
+}
+
+func TestSyncQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.queue")
+
+	q, err := openSyncQueue(path)
+	if err != nil {
+		t.Fatal("Error opening queue: ", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		err = q.add(data.NodeEdge{ID: "a", Points: data.Points{
+			{Type: data.PointTypeValue, Value: float64(i), Time: now},
+		}})
+		if err != nil {
+			t.Fatal("Error adding to queue: ", err)
+		}
+	}
+
+	err = q.add(data.NodeEdge{ID: "b", Parent: "a", EdgePoints: data.Points{
+		{Type: data.PointTypeTombstone, Time: now},
+	}})
+	if err != nil {
+		t.Fatal("Error adding to queue: ", err)
+	}
+
+	if q.count != 6 {
+		t.Fatal("Wrong count: ", q.count)
+	}
+
+	q.close()
+
+	// simulate a partial write at the end of the file
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	q, err = openSyncQueue(path)
+	if err != nil {
+		t.Fatal("Error reopening queue: ", err)
+	}
+	defer q.close()
+
+	if q.count != 6 {
+		t.Fatal("Wrong count after reopen: ", q.count)
+	}
+
+	// fail on the second batch, the first batch should be removed
+	var sent []float64
+	calls := 0
+	err = q.replay(2, func(b []data.NodeEdge) error {
+		calls++
+		if calls == 2 {
+			return errors.New("send failed")
+		}
+		sent = append(sent, queueValues(t, b)...)
+		return nil
+	})
+
+	if err == nil {
+		t.Fatal("Expected replay error")
+	}
+
+	if q.count != 4 {
+		t.Fatal("Wrong count after failed replay: ", q.count)
+	}
+
+	// the replayed records are skipped after a restart
+	q.close()
+	q, err = openSyncQueue(path)
+	if err != nil {
+		t.Fatal("Error reopening queue: ", err)
+	}
+	defer q.close()
+
+	if q.count != 4 {
+		t.Fatal("Wrong count after reopen: ", q.count)
+	}
+
+	var edges int
+	err = q.replay(2, func(b []data.NodeEdge) error {
+		sent = append(sent, queueValues(t, b)...)
+		for _, n := range b {
+			edges += len(n.EdgePoints)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal("Replay error: ", err)
+	}
+
+	exp := []float64{0, 1, 2, 3, 4}
+	if len(sent) != len(exp) {
+		t.Fatal("Wrong values sent: ", sent)
+	}
+	for i := range exp {
+		if sent[i] != exp[i] {
+			t.Fatal("Wrong values sent: ", sent)
+		}
+	}
+
+	if edges != 1 {
+		t.Fatal("Edge points not replayed")
+	}
+
+	if q.count != 0 {
+		t.Fatal("Queue not empty after replay: ", q.count)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 0 {
+		t.Fatal("Queue file not empty: ", info.Size())
+	}
+}
+
+func TestSyncQueueReplayFallback(t *testing.T) {
+	// an upstream without a sync batch handler
+	ns, err := natsserver.NewServer(&natsserver.Options{Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	values := make(chan float64, 10)
+	_, err = nc.Subscribe(SubjectNodeAllPoints(), func(msg *nats.Msg) {
+		_, points, err := DecodeNodePointsMsg(msg)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, p := range points {
+			values <- p.Value
+		}
+		_ = msg.Respond(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := &SyncClient{
+		ncRemote: nc,
+		synced:   newSyncedPoints(),
+		config:   Sync{BatchPeriod: 100},
+	}
+
+	err = up.replaySend([]data.NodeEdge{
+		{ID: "a", Points: data.Points{{Type: data.PointTypeValue, Value: 1, Time: time.Now()}}},
+		{ID: "a", Points: data.Points{{Type: data.PointTypeValue, Value: 2, Time: time.Now()}}},
+	})
+	if err != nil {
+		t.Fatal("Replay error: ", err)
+	}
+
+	for _, exp := range []float64{1, 2} {
+		select {
+		case v := <-values:
+			if v != exp {
+				t.Fatalf("expected %v, got %v", exp, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for point: ", exp)
+		}
+	}
+}
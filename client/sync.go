@@ -10,17 +10,50 @@ import (
 	"github.com/simpleiot/simpleiot/data"
 )
 
-// Sync represents an sync node config
+// Sync represents an sync node config. The include/exclude and throttle
+// fields filter what is sent upstream (see sync-filter.go). If BatchPeriod
+// (ms) is set, point changes are batched and compressed (see
+// sync-batch.go). If QueueMax is set, point changes are queued on disk
+// while disconnected (see sync-queue.go). Conflicts are handled according
+// to ConflictPolicy (see sync-conflict.go). The TLS and NKey/creds fields
+// are optional credentials for the upstream connection (see edge-auth.go).
+// Connected through LastError are diagnostics reported by the client (see
+// sync-diag.go).
 type Sync struct {
-	ID             string `node:"id"`
-	Parent         string `node:"parent"`
-	Description    string `point:"description"`
-	URI            string `point:"uri"`
-	AuthToken      string `point:"authToken"`
-	Period         int    `point:"period"`
-	Disable        bool   `point:"disable"`
-	SyncCount      int    `point:"syncCount"`
-	SyncCountReset bool   `point:"syncCountReset"`
+	ID                string             `node:"id"`
+	Parent            string             `node:"parent"`
+	Description       string             `point:"description"`
+	URI               string             `point:"uri"`
+	AuthToken         string             `point:"authToken"`
+	TLSCert           string             `point:"tlsCert"`
+	TLSKey            string             `point:"tlsKey"`
+	TLSCA             string             `point:"tlsCA"`
+	NKeySeed          string             `point:"nkeySeed"`
+	Creds             string             `point:"creds"`
+	CertExpiry        float64            `point:"certExpiry"`
+	Period            int                `point:"period"`
+	Disable           bool               `point:"disable"`
+	SyncCount         int                `point:"syncCount"`
+	SyncCountReset    bool               `point:"syncCountReset"`
+	IncludeNodeIDs    []string           `point:"includeNodeID"`
+	ExcludeNodeIDs    []string           `point:"excludeNodeID"`
+	IncludeNodeTypes  []string           `point:"includeNodeType"`
+	ExcludeNodeTypes  []string           `point:"excludeNodeType"`
+	IncludePointTypes []string           `point:"includePointType"`
+	ExcludePointTypes []string           `point:"excludePointType"`
+	Throttle          map[string]float64 `point:"throttle"`
+	BatchPeriod       int                `point:"batchPeriod"`
+	BytesSent         float64            `point:"bytesSent"`
+	BytesReceived     float64            `point:"bytesReceived"`
+	QueueMax          int                `point:"queueMax"`
+	LastSync          float64            `point:"lastSync"`
+	ConflictPolicy    string             `point:"conflictPolicy"`
+	Conflicts         map[string]string  `point:"conflict"`
+	Connected         bool               `point:"connected"`
+	RTT               float64            `point:"rtt"`
+	NodesOutOfSync    int                `point:"nodesOutOfSync"`
+	QueueSize         int                `point:"queueSize"`
+	LastError         string             `point:"lastError"`
 }
 
 type newEdge struct {
@@ -46,10 +79,31 @@ type SyncClient struct {
 	chConnected         chan bool
 	initialSub          bool
 	chNewEdge           chan newEdge
+	filterCache         map[string]syncNodeFilter
+	hashCache           map[string]syncHash
+	throttles           map[string]*syncThrottle
+	batch               []data.NodeEdge
+	batchTimer          *time.Timer
+	batchSending        bool
+	batchResults        chan syncBatchResult
+	statsOut            uint64
+	statsIn             uint64
+	connected           bool
+	queue               *syncQueue
+	queueFull           bool
+	synced              *syncedPoints
+	resyncRequests      chan *nats.Msg
+	resyncSub           *nats.Subscription
+	syncForce           bool
+	nodesOutOfSync      int
+	lastError           string
 }
 
 // NewSyncClient constructor
 func NewSyncClient(nc *nats.Conn, config Sync) Client {
+	batchTimer := time.NewTimer(time.Hour)
+	batchTimer.Stop()
+
 	return &SyncClient{
 		nc:                  nc,
 		config:              config,
@@ -60,6 +114,13 @@ func NewSyncClient(nc *nats.Conn, config Sync) Client {
 		subRemoteNodePoints: make(map[string]*nats.Subscription),
 		subRemoteEdgePoints: make(map[string]*nats.Subscription),
 		chNewEdge:           make(chan newEdge),
+		filterCache:         make(map[string]syncNodeFilter),
+		hashCache:           make(map[string]syncHash),
+		throttles:           make(map[string]*syncThrottle),
+		batchTimer:          batchTimer,
+		batchResults:        make(chan syncBatchResult, 1),
+		synced:              newSyncedPoints(),
+		resyncRequests:      make(chan *nats.Msg),
 	}
 }
 
@@ -134,6 +195,13 @@ func (up *SyncClient) Run() error {
 		log.Println("SyncClient: error subscribing: ", err)
 	}
 
+	up.resyncSub, err = up.nc.Subscribe(SubjectSyncResync(up.config.ID), func(msg *nats.Msg) {
+		up.resyncRequests <- msg
+	})
+	if err != nil {
+		log.Println("SyncClient: error subscribing to resync: ", err)
+	}
+
 	checkPeriod := func() {
 		if up.config.Period < 1 {
 			up.config.Period = 20
@@ -155,14 +223,22 @@ func (up *SyncClient) Run() error {
 
 	connectTimer := time.NewTimer(time.Millisecond * 10)
 
+	// throttleTicker is used to send throttled points
+	throttleTicker := time.NewTicker(time.Second)
+	defer throttleTicker.Stop()
+
 	up.rootLocal, err = GetRootNode(up.nc)
 	if err != nil {
 		return fmt.Errorf("Error getting root node: %v", err)
 	}
 
-	connected := false
+	up.connected = false
 	up.initialSub = false
 
+	if up.config.QueueMax > 0 {
+		up.openQueue()
+	}
+
 done:
 	for {
 		select {
@@ -174,22 +250,37 @@ done:
 			if err != nil {
 				log.Printf("Sync connect failure: %v: %v\n",
 					up.config.Description, err)
+				up.setError(err)
+				up.reportDiag()
 				connectTimer.Reset(30 * time.Second)
 			}
 		case <-syncTicker.C:
-			err := up.syncNode("root", up.rootLocal.ID)
+			up.sendBatch()
+			err := up.syncRoot()
 			if err != nil {
 				log.Println("Error syncing: ", err)
 			}
+			up.setError(err)
+			// stats points are reported after the sync so they are
+			// forwarded upstream before the next hash compare
+			up.reportBytes()
+			up.reportDiag()
 
 		case conn := <-up.chConnected:
-			connected = conn
+			// events from a closed connection may arrive late, so use
+			// the state of the current connection
+			conn = up.ncRemote != nil && up.ncRemote.IsConnected()
+			up.connected = conn
 			if conn {
 				syncTicker.Reset(time.Duration(up.config.Period) * time.Second)
-				err := up.syncNode("root", up.rootLocal.ID)
+				// replay queued points before syncing so that the
+				// upstream receives them in order
+				up.replayQueue()
+				err := up.syncRoot()
 				if err != nil {
 					log.Println("Error syncing: ", err)
 				}
+				up.setError(err)
 
 				if !up.initialSub {
 					// set up initial subscriptions to remote nodes
@@ -206,19 +297,28 @@ done:
 				// is set up which may have a new root
 				up.rootRemote = data.NodeEdge{}
 			}
+			up.reportDiag()
+		case msg := <-up.resyncRequests:
+			up.handleResync(msg)
+		case <-throttleTicker.C:
+			if up.connected {
+				up.flushThrottle(time.Now())
+			}
+		case <-up.batchTimer.C:
+			up.sendBatch()
+		case res := <-up.batchResults:
+			up.batchSent(res)
 		case pts := <-chLocalNodePoints:
-			if connected {
-				err = SendNodePoints(up.ncRemote, pts.ID, pts.Points, false)
-				if err != nil {
-					log.Println("Error sending node points to remote system: ", err)
-				}
+			err = up.sendNodePointsRemote(pts.ID, pts.Points, false)
+			if err != nil {
+				log.Println("Error sending node points to remote system: ", err)
 			}
 		case pts := <-chLocalEdgePoints:
-			if connected {
-				err = SendEdgePoints(up.ncRemote, pts.ID, pts.Parent, pts.Points, false)
-				if err != nil {
-					log.Println("Error sending edge points to remote system: ", err)
-				}
+			// the tree structure may have changed
+			up.resetFilterCache()
+			err = up.sendEdgePointsRemote(pts.ID, pts.Parent, pts.Points)
+			if err != nil {
+				log.Println("Error sending edge points to remote system: ", err)
 			}
 		case pts := <-up.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &up.config)
@@ -226,17 +326,29 @@ done:
 				log.Println("error merging new points: ", err)
 			}
 
+			up.resetFilterCache()
+
 			for _, p := range pts.Points {
 				switch p.Type {
+				case data.PointTypeConflictResolve,
+					data.PointTypeConflictClear:
+					up.conflictCommand(p)
 				case data.PointTypeURI,
 					data.PointTypeAuthToken,
+					data.PointTypeTLSCert,
+					data.PointTypeTLSKey,
+					data.PointTypeTLSCA,
+					data.PointTypeNKeySeed,
+					data.PointTypeCreds,
 					data.PointTypeDisable:
 					// we need to restart the sync connection
+					up.flushBatch()
 					up.disconnect()
 					connectTimer.Reset(10 * time.Millisecond)
+					up.reportDiag()
 				case data.PointTypePeriod:
 					checkPeriod()
-					if connected {
+					if up.connected {
 						syncTicker.Reset(time.Duration(up.config.Period) *
 							time.Second)
 					}
@@ -263,6 +375,8 @@ done:
 			if err != nil {
 				log.Println("error merging new points: ", err)
 			}
+
+			up.resetFilterCache()
 		case edge := <-up.chNewEdge:
 			if !edge.local {
 				// a new remote node was created, if it does not exist here,
@@ -323,8 +437,18 @@ done:
 		log.Println("Error unsubscribing edge points from local bus: ", err)
 	}
 
+	if up.resyncSub != nil {
+		err = up.resyncSub.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing sync resync sub: ", err)
+		}
+	}
+
+	up.batchTimer.Stop()
+	up.flushBatch()
 	up.disconnect()
 	up.ncLocal.Close()
+	up.closeQueue()
 
 	return nil
 }
@@ -352,9 +476,16 @@ func (up *SyncClient) connect() error {
 		return nil
 	}
 
+	up.reportCertExpiry()
+
 	opts := EdgeOptions{
 		URI:       up.config.URI,
 		AuthToken: up.config.AuthToken,
+		TLSCert:   up.config.TLSCert,
+		TLSKey:    up.config.TLSKey,
+		TLSCA:     up.config.TLSCA,
+		NKeySeed:  up.config.NKeySeed,
+		Creds:     up.config.Creds,
 		NoEcho:    true,
 		Connected: func() {
 			up.chConnected <- true
@@ -375,6 +506,7 @@ func (up *SyncClient) connect() error {
 	}
 
 	var err error
+	up.statsOut, up.statsIn = 0, 0
 	up.ncRemote, err = EdgeConnect(opts)
 
 	if err != nil {
@@ -384,6 +516,36 @@ func (up *SyncClient) connect() error {
 	return nil
 }
 
+// reportCertExpiry sends the expiration time of the client certificate
+func (up *SyncClient) reportCertExpiry() {
+	var expiry float64
+
+	if up.config.TLSCert != "" {
+		t, err := CertExpiry(up.config.TLSCert)
+		if err != nil {
+			log.Printf("Sync %v: error parsing client certificate: %v\n",
+				up.config.Description, err)
+		} else {
+			expiry = timeToUnix(t)
+			if time.Now().After(t) {
+				log.Printf("Sync %v: client certificate expired: %v\n",
+					up.config.Description, t)
+			}
+		}
+	}
+
+	if expiry == up.config.CertExpiry {
+		return
+	}
+
+	up.config.CertExpiry = expiry
+	err := SendNodePoint(up.nc, up.config.ID, data.Point{
+		Type: data.PointTypeCertExpiry, Value: expiry}, false)
+	if err != nil {
+		log.Println("Sync: error sending cert expiry: ", err)
+	}
+}
+
 func (up *SyncClient) subscribeRemoteNodePoints(id string) error {
 	if _, ok := up.subRemoteNodePoints[id]; !ok {
 		var err error
@@ -394,10 +556,27 @@ func (up *SyncClient) subscribeRemoteNodePoints(id string) error {
 				return
 			}
 
+			// batched points are republished by the upstream, so
+			// skip the points we sent
+			received := make(data.Points, 0, len(points))
+			for _, p := range points {
+				if !up.synced.synced(nodeID, p) {
+					received = append(received, p)
+				}
+			}
+
+			if len(received) <= 0 {
+				return
+			}
+			points = received
+
 			err = SendNodePoints(up.ncLocal, nodeID, points, false)
 			if err != nil {
 				log.Println("Error sending node points to remote system: ", err)
+				return
 			}
+
+			up.synced.add(nodeID, points)
 		})
 
 		if err != nil {
@@ -478,6 +657,7 @@ func (up *SyncClient) disconnect() {
 	}
 
 	up.initialSub = false
+	up.connected = false
 	if up.subRemoteUp != nil {
 		err := up.subRemoteUp.Unsubscribe()
 		if err != nil {
@@ -487,16 +667,42 @@ func (up *SyncClient) disconnect() {
 	}
 
 	if up.ncRemote != nil {
+		up.reportBytes()
 		up.ncRemote.Close()
 		up.ncRemote = nil
 		up.rootRemote = data.NodeEdge{}
 	}
 }
 
+// syncRoot syncs the entire tree and records the time of the last
+// successful sync, which is used to detect conflicts
+func (up *SyncClient) syncRoot() error {
+	start := time.Now()
+	up.nodesOutOfSync = 0
+	up.hashCache = make(map[string]syncHash)
+
+	err := up.syncNode("root", up.rootLocal.ID)
+	if err != nil {
+		return err
+	}
+
+	up.config.LastSync = timeToUnix(start)
+	up.synced.prune(start)
+
+	return SendNodePoint(up.nc, up.config.ID, data.Point{
+		Type: data.PointTypeLastSync, Value: up.config.LastSync, Time: start}, false)
+}
+
 // sendNodesRemote is used to send node and children over nats
 // from one NATS server to another. Typically from the current instance
 // to an upstream.
 func (up *SyncClient) sendNodesRemote(node data.NodeEdge) error {
+	if !up.nodeFilter(node).allowed {
+		return nil
+	}
+
+	node.Points = up.filterPoints(node.Points)
+
 	if node.Parent == "root" {
 		node.Parent = up.rootRemote.ID
 	}
@@ -637,6 +843,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 	}
 
 	if !nodeFound {
+		up.nodesOutOfSync++
 		log.Printf("Sync node %v does not exist, sending\n", nodeLocal.Desc())
 		err := up.sendNodesRemote(nodeLocal)
 		if err != nil {
@@ -653,6 +860,13 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	nodeUp = nodeUps[0]
 
+	// compare the content that passes the sync filters
+	nodeLocal.Hash, err = up.filteredHash(nodeLocal)
+	if err != nil {
+		return err
+	}
+	nodeUp.Hash = up.filteredHashUp(nodeUp)
+
 	if nodeLocal.ID == up.rootLocal.ID {
 		// we need to back out the edge points from the hash as don't want to sync those
 		for _, p := range nodeUp.EdgePoints {
@@ -664,13 +878,13 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		}
 	}
 
-	if nodeUp.Hash == nodeLocal.Hash {
+	if nodeUp.Hash == nodeLocal.Hash && !up.syncForce {
 		// we're good!
 		return nil
 	}
 
 	// only increment count once during sync
-	if nodeLocal.ID == up.rootLocal.ID {
+	if nodeLocal.ID == up.rootLocal.ID && nodeUp.Hash != nodeLocal.Hash {
 		up.config.SyncCount++
 		points := data.Points{
 			{Type: data.PointTypeSyncCount, Value: float64(up.config.SyncCount)},
@@ -687,19 +901,33 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		nodeLocal.Desc(),
 		nodeUp.Hash, nodeLocal.Hash)
 
+	// outOfSync is set if any points of this node are synced
+	outOfSync := false
+
 	// first compare node points
 	// key in below map is the index of the point in the upstream node
 	upstreamProcessed := make(map[int]bool)
 
 	for _, p := range nodeLocal.Points {
+		allowed := up.pointAllowed(p)
 		found := false
 		for i, pUp := range nodeUp.Points {
 			if p.IsMatch(pUp.Type, pUp.Key) {
 				found = true
 				upstreamProcessed[i] = true
+				if !allowed {
+					// filtered points are not synced in either direction
+					continue
+				}
+				if !p.Time.Equal(pUp.Time) {
+					outOfSync = true
+					if up.syncConflict(nodeLocal.ID, p, pUp) {
+						continue
+					}
+				}
 				if p.Time.After(pUp.Time) {
 					// need to send point upstream
-					err := SendNodePoint(up.ncRemote, nodeUp.ID, p, true)
+					err := up.sendNodePointsRemote(nodeUp.ID, data.Points{p}, true)
 					if err != nil {
 						log.Println("Error syncing point upstream: ", err)
 					}
@@ -713,8 +941,9 @@ func (up *SyncClient) syncNode(parent, id string) error {
 			}
 		}
 
-		if !found {
-			err := SendNodePoint(up.ncRemote, nodeUp.ID, p, true)
+		if !found && allowed {
+			outOfSync = true
+			err := up.sendNodePointsRemote(nodeUp.ID, data.Points{p}, true)
 			if err != nil {
 				log.Println("Error sending point: ", err)
 			}
@@ -724,6 +953,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 	// check for any points that do not exist locally
 	for i, pUp := range nodeUp.Points {
 		if _, ok := upstreamProcessed[i]; !ok {
+			outOfSync = true
 			err := SendNodePoint(up.nc, nodeLocal.ID, pUp, true)
 			if err != nil {
 				log.Println("Error syncing point from upstream: ", err)
@@ -743,6 +973,9 @@ func (up *SyncClient) syncNode(parent, id string) error {
 				if p.IsMatch(pUp.Type, pUp.Key) {
 					found = true
 					upstreamProcessed[i] = true
+					if !p.Time.Equal(pUp.Time) {
+						outOfSync = true
+					}
 					if p.Time.After(pUp.Time) {
 						// need to send point upstream
 						err := SendEdgePoint(up.ncRemote, nodeUp.ID, nodeUp.Parent, p, true)
@@ -760,6 +993,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 			}
 
 			if !found {
+				outOfSync = true
 				err := SendEdgePoint(up.ncRemote, nodeUp.ID, nodeUp.Parent, p, true)
 				if err != nil {
 					log.Println("Error sending point: ", err)
@@ -770,6 +1004,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		// check for any points that do not exist locally
 		for i, pUp := range nodeUp.EdgePoints {
 			if _, ok := upstreamProcessed[i]; !ok {
+				outOfSync = true
 				err := SendEdgePoint(up.nc, nodeLocal.ID, nodeLocal.Parent, pUp, true)
 				if err != nil {
 					log.Println("Error syncing edge point from upstream: ", err)
@@ -778,6 +1013,10 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		}
 	}
 
+	if outOfSync {
+		up.nodesOutOfSync++
+	}
+
 	// sync child nodes
 	children, err := GetNodes(up.ncLocal, nodeLocal.ID, "all", "", false)
 	if err != nil {
@@ -794,12 +1033,26 @@ func (up *SyncClient) syncNode(parent, id string) error {
 	upChildProcessed := make(map[int]bool)
 
 	for _, child := range children {
+		if !up.nodeFilter(child).allowed {
+			// an upstream copy of an excluded node is left alone
+			for i, upChild := range upChildren {
+				if child.ID == upChild.ID {
+					upChildProcessed[i] = true
+				}
+			}
+			continue
+		}
+
 		found := false
 		for i, upChild := range upChildren {
 			if child.ID == upChild.ID {
 				found = true
 				upChildProcessed[i] = true
-				if child.Hash != upChild.Hash {
+				childHash, err := up.filteredHash(child)
+				if err != nil {
+					log.Println("Error calculating node hash: ", err)
+				}
+				if childHash != up.filteredHashUp(upChild) || up.syncForce {
 					err := up.syncNode(nodeLocal.ID, child.ID)
 					if err != nil {
 						fmt.Println("Error syncing node: ", err)
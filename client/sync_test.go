@@ -1,10 +1,12 @@
 package client_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/client"
 	"github.com/simpleiot/simpleiot/data"
 	"github.com/simpleiot/simpleiot/server"
@@ -294,3 +296,738 @@ func TestSyncDeleteUpstream(t *testing.T) {
 		time.Sleep(time.Millisecond * 10)
 	}
 }
+
+func TestSyncFilter(t *testing.T) {
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	varInc := client.Variable{ID: "varInc", Parent: rootD.ID, Description: "included"}
+	varExc := client.Variable{ID: "varExc", Parent: rootD.ID, Description: "excluded"}
+
+	for _, v := range []client.Variable{varInc, varExc} {
+		err = client.SendNodeType(ncD, v, "test")
+		if err != nil {
+			t.Fatal("Error sending var: ", err)
+		}
+	}
+
+	sync := client.Sync{
+		ID:                "sync-id",
+		Parent:            rootD.ID,
+		Description:       "sync to up",
+		URI:               server.TestServerOptions2.NatsServer,
+		ExcludeNodeIDs:    []string{varExc.ID},
+		ExcludePointTypes: []string{"private"},
+		Throttle:          map[string]float64{data.PointTypeValue: 0.5},
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("included node not synced")
+		}
+
+		nodes, err := client.GetNodes(ncU, "all", varInc.ID, "", false)
+		if err == nil && len(nodes) > 0 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	nodes, err := client.GetNodes(ncU, "all", varExc.ID, "", false)
+	if err != nil {
+		t.Fatal("Error getting nodes: ", err)
+	}
+
+	if len(nodes) > 0 {
+		t.Fatal("excluded node was synced")
+	}
+
+	values := make(chan float64, 10)
+	privates := make(chan float64, 10)
+
+	sub, err := ncU.Subscribe(client.SubjectNodePoints(varInc.ID), func(msg *nats.Msg) {
+		_, points, err := client.DecodeNodePointsMsg(msg)
+		if err != nil {
+			t.Error("Error decoding points: ", err)
+			return
+		}
+		for _, p := range points {
+			switch p.Type {
+			case data.PointTypeValue:
+				values <- p.Value
+			case "private":
+				privates <- p.Value
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	err = client.SendNodePoint(ncD, varInc.ID, data.Point{Type: "private", Value: 1}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	// the first value is sent right away, the second is dropped, and the
+	// last is sent at the end of the throttle period
+	for _, v := range []float64{1, 2, 3} {
+		err = client.SendNodePoint(ncD, varInc.ID, data.Point{Type: data.PointTypeValue, Value: v}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	for _, exp := range []float64{1, 3} {
+		select {
+		case v := <-values:
+			if v != exp {
+				t.Fatalf("expected value %v, got %v", exp, v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for value: ", exp)
+		}
+	}
+
+	select {
+	case <-privates:
+		t.Fatal("excluded point type was synced")
+	case v := <-values:
+		t.Fatal("unexpected value: ", v)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestSyncFilterInSync checks that a filtered tree that is in sync is not
+// synced again every sync period
+func TestSyncFilterInSync(t *testing.T) {
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	varInc := client.Variable{ID: "varInc", Parent: rootD.ID, Description: "included"}
+	varExc := client.Variable{ID: "varExc", Parent: rootD.ID, Description: "excluded"}
+
+	for _, v := range []client.Variable{varInc, varExc} {
+		err = client.SendNodeType(ncD, v, "test")
+		if err != nil {
+			t.Fatal("Error sending var: ", err)
+		}
+	}
+
+	err = client.SendNodePoint(ncD, varInc.ID, data.Point{Type: "private", Value: 1}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	sync := client.Sync{
+		ID:                "sync-id",
+		Parent:            rootD.ID,
+		Description:       "sync to up",
+		URI:               server.TestServerOptions2.NatsServer,
+		Period:            1,
+		ExcludeNodeIDs:    []string{varExc.ID},
+		ExcludePointTypes: []string{"private"},
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("included node not synced")
+		}
+
+		nodes, err := client.GetNodes(ncU, "all", varInc.ID, "", false)
+		if err == nil && len(nodes) > 0 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	syncCount := func() int {
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, sync.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting sync node: ", err)
+		}
+		return nodes[0].SyncCount
+	}
+
+	// let the first sync after the initial upload settle
+	time.Sleep(1500 * time.Millisecond)
+	count := syncCount()
+
+	// two more sync periods
+	time.Sleep(2500 * time.Millisecond)
+
+	if c := syncCount(); c != count {
+		t.Errorf("filtered tree was synced again, sync count %v -> %v", count, c)
+	}
+}
+
+func TestSyncBatchEncode(t *testing.T) {
+	nodes := []data.NodeEdge{
+		{ID: "a", Points: data.Points{{Type: data.PointTypeValue, Value: 1, Time: time.Now()}}},
+		{ID: "b", Parent: "a", EdgePoints: data.Points{{Type: data.PointTypeTombstone, Time: time.Now()}}},
+	}
+
+	d, err := client.EncodeSyncBatch(nodes)
+	if err != nil {
+		t.Fatal("Error encoding batch: ", err)
+	}
+
+	decoded, err := client.DecodeSyncBatch(d)
+	if err != nil {
+		t.Fatal("Error decoding batch: ", err)
+	}
+
+	if len(decoded) != 2 {
+		t.Fatal("Wrong number of nodes decoded: ", len(decoded))
+	}
+
+	if decoded[0].ID != "a" || len(decoded[0].Points) != 1 ||
+		decoded[0].Points[0].Value != 1 {
+		t.Fatal("Node points not decoded correctly: ", decoded[0])
+	}
+
+	if decoded[1].ID != "b" || decoded[1].Parent != "a" ||
+		len(decoded[1].EdgePoints) != 1 {
+		t.Fatal("Edge points not decoded correctly: ", decoded[1])
+	}
+
+	_, err = client.DecodeSyncBatch([]byte("garbage"))
+	if err == nil {
+		t.Fatal("Expected error decoding garbage")
+	}
+}
+
+func TestSyncBatch(t *testing.T) {
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "var", Parent: rootD.ID, Description: "batched"}
+
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending var: ", err)
+	}
+
+	sync := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         server.TestServerOptions2.NatsServer,
+		Period:      1,
+		BatchPeriod: 100,
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	waitVar := func(desc string, check func(client.Variable) bool) {
+		start := time.Now()
+		for {
+			if time.Since(start) > 2*time.Second {
+				t.Fatal("timeout waiting for ", desc)
+			}
+
+			nodes, err := client.GetNodesType[client.Variable](ncU, "all", v.ID)
+			if err == nil && len(nodes) > 0 && check(nodes[0]) {
+				return
+			}
+
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	waitVar("node sync", func(client.Variable) bool { return true })
+
+	// batched points are republished on the upstream point subjects
+	values := make(chan float64, 10)
+	sub, err := ncU.Subscribe(client.SubjectNodePoints(v.ID), func(msg *nats.Msg) {
+		_, points, err := client.DecodeNodePointsMsg(msg)
+		if err != nil {
+			t.Error("Error decoding points: ", err)
+			return
+		}
+		for _, p := range points {
+			if p.Type == data.PointTypeValue {
+				values <- p.Value
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	for _, val := range []float64{1, 2, 3} {
+		err = client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue, Value: val}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	waitVar("batched points", func(n client.Variable) bool { return n.Value == 3 })
+
+	for _, exp := range []float64{1, 2, 3} {
+		select {
+		case v := <-values:
+			if v != exp {
+				t.Fatalf("expected value %v, got %v", exp, v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for batched point: ", exp)
+		}
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("timeout waiting for byte stats")
+		}
+
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, sync.ID)
+		if err == nil && len(nodes) > 0 &&
+			nodes[0].BytesSent > 0 && nodes[0].BytesReceived > 0 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestSyncOfflineQueue(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "var", Parent: rootD.ID, Description: "queued"}
+
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending var: ", err)
+	}
+
+	sync := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         server.TestServerOptions2.NatsServer,
+		QueueMax:    100,
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	setURI := func(uri string) {
+		err := client.SendNodePoint(ncD, sync.ID, data.Point{Type: data.PointTypeURI,
+			Text: uri, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal("Error sending uri: ", err)
+		}
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("node not synced")
+		}
+
+		nodes, err := client.GetNodes(ncU, "all", v.ID, "", false)
+		if err == nil && len(nodes) > 0 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// switch to an upstream that does not exist so points are queued
+	setURI("nats://localhost:8999")
+	time.Sleep(100 * time.Millisecond)
+
+	values := make(chan float64, 10)
+
+	sub, err := ncU.Subscribe(fmt.Sprintf("up.%v.%v", rootD.ID, v.ID), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			t.Error("Error decoding points: ", err)
+			return
+		}
+		for _, p := range points {
+			if p.Type == data.PointTypeValue {
+				values <- p.Value
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	start = time.Now()
+	for i, val := range []float64{1, 2, 3} {
+		p := data.Point{Type: data.PointTypeValue, Value: val,
+			Time: start.Add(time.Duration(i) * time.Millisecond)}
+		err = client.SendNodePoint(ncD, v.ID, p, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	setURI(server.TestServerOptions2.NatsServer)
+
+	// all queued values must be received in order
+	for _, exp := range []float64{1, 2, 3} {
+		select {
+		case v := <-values:
+			if v != exp {
+				t.Fatalf("expected value %v, got %v", exp, v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for value: ", exp)
+		}
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "var", Parent: rootD.ID, Description: "conflict", Value: 1}
+
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending var: ", err)
+	}
+
+	sync := client.Sync{
+		ID:             "sync-id",
+		Parent:         rootD.ID,
+		Description:    "sync to up",
+		URI:            server.TestServerOptions2.NatsServer,
+		Period:         1,
+		ConflictPolicy: data.PointValueUpstream,
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	sendSync := func(p data.Point) {
+		p.Origin = "test"
+		err := client.SendNodePoint(ncD, sync.ID, p, true)
+		if err != nil {
+			t.Fatal("Error sending sync point: ", err)
+		}
+	}
+
+	getSync := func() client.Sync {
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, sync.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting sync node: ", err)
+		}
+		return nodes[0]
+	}
+
+	getValue := func(nc *nats.Conn) float64 {
+		nodes, err := client.GetNodesType[client.Variable](nc, "all", v.ID)
+		if err != nil || len(nodes) < 1 {
+			return -1
+		}
+		return nodes[0].Value
+	}
+
+	wait := func(desc string, check func() bool) {
+		start := time.Now()
+		for {
+			if time.Since(start) > 3*time.Second {
+				t.Fatal("timeout waiting for ", desc)
+			}
+			if check() {
+				return
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+
+	wait("initial sync", func() bool {
+		return getSync().LastSync > 0 && getValue(ncU) == 1
+	})
+
+	// change the value on both sides while disconnected
+	conflict := func(local, upstream float64) {
+		sendSync(data.Point{Type: data.PointTypeURI, Text: "nats://localhost:8999"})
+		time.Sleep(100 * time.Millisecond)
+
+		err := client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: local}, true)
+		if err != nil {
+			t.Fatal("Error sending local value: ", err)
+		}
+
+		err = client.SendNodePoint(ncU, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: upstream}, true)
+		if err != nil {
+			t.Fatal("Error sending upstream value: ", err)
+		}
+
+		sendSync(data.Point{Type: data.PointTypeURI, Text: server.TestServerOptions2.NatsServer})
+	}
+
+	conflictKey := v.ID + "." + data.PointTypeValue + "."
+
+	getConflict := func() (client.SyncConflict, bool) {
+		var sc client.SyncConflict
+		txt := getSync().Conflicts[conflictKey]
+		if txt == "" {
+			return sc, false
+		}
+		err := json.Unmarshal([]byte(txt), &sc)
+		if err != nil {
+			t.Fatal("Error decoding conflict: ", err)
+		}
+		return sc, true
+	}
+
+	// the older upstream value wins
+	conflict(3, 2)
+
+	wait("upstream wins", func() bool {
+		return getValue(ncD) == 2 && getValue(ncU) == 2
+	})
+
+	sc, ok := getConflict()
+	if !ok {
+		t.Fatal("conflict not recorded")
+	}
+
+	if sc.Winner != data.PointValueUpstream || sc.Local.Value != 3 ||
+		sc.Upstream.Value != 2 {
+		t.Fatal("wrong conflict record: ", sc)
+	}
+
+	// with the manual policy, nothing is synced until resolved
+	sendSync(data.Point{Type: data.PointTypeConflictPolicy, Text: data.PointValueManual})
+	time.Sleep(1500 * time.Millisecond)
+
+	conflict(4, 5)
+
+	wait("manual conflict", func() bool {
+		sc, ok := getConflict()
+		return ok && !sc.Resolved()
+	})
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if getValue(ncD) != 4 || getValue(ncU) != 5 {
+		t.Fatal("values synced before conflict was resolved")
+	}
+
+	sendSync(data.Point{Type: data.PointTypeConflictResolve, Key: conflictKey,
+		Text: data.PointValueDownstream})
+
+	wait("downstream wins", func() bool {
+		return getValue(ncD) == 4 && getValue(ncU) == 4
+	})
+
+	sendSync(data.Point{Type: data.PointTypeConflictClear, Value: 1})
+
+	wait("conflicts cleared", func() bool {
+		_, ok := getConflict()
+		return !ok
+	})
+}
+
+func TestSyncDiag(t *testing.T) {
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	upStopped := false
+	defer func() {
+		if !upStopped {
+			stopU()
+		}
+	}()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "var", Parent: rootD.ID, Description: "diag", Value: 1}
+
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending var: ", err)
+	}
+
+	sync := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         server.TestServerOptions2.NatsServer,
+		Period:      1,
+	}
+
+	err = client.SendNodeType(ncD, sync, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	getSync := func() client.Sync {
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, sync.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting sync node: ", err)
+		}
+		return nodes[0]
+	}
+
+	wait := func(desc string, check func() bool) {
+		start := time.Now()
+		for {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("timeout waiting for ", desc)
+			}
+			if check() {
+				return
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+
+	wait("connected", func() bool {
+		nodes, err := client.GetNodesType[client.Variable](ncU, "all", v.ID)
+		s := getSync()
+		return err == nil && len(nodes) > 0 && s.Connected && s.RTT > 0 &&
+			s.LastSync > 0
+	})
+
+	err = client.SyncResync(ncD, sync.ID, "")
+	if err != nil {
+		t.Fatal("Error resyncing: ", err)
+	}
+
+	err = client.SyncResync(ncD, sync.ID, v.ID)
+	if err != nil {
+		t.Fatal("Error resyncing node: ", err)
+	}
+
+	s := getSync()
+	if s.NodesOutOfSync != 0 || s.LastError != "" {
+		t.Errorf("expected tree in sync, out of sync: %v, error: %v",
+			s.NodesOutOfSync, s.LastError)
+	}
+
+	err = client.SyncResync(ncD, sync.ID, "does-not-exist")
+	if err == nil {
+		t.Error("expected error resyncing unknown node")
+	}
+
+	wait("last error", func() bool {
+		return getSync().LastError != ""
+	})
+
+	stopU()
+	upStopped = true
+
+	wait("disconnected", func() bool {
+		s := getSync()
+		return !s.Connected && s.RTT == 0
+	})
+
+	err = client.SyncResync(ncD, sync.ID, "")
+	if err == nil {
+		t.Error("expected error resyncing while disconnected")
+	}
+}
@@ -25,6 +25,7 @@ func main() {
 
 	flagPort := flag.String("port", "", "serial port")
 	flagBaud := flag.String("baud", "9600", "baud rate")
+	flagScan := flag.String("scan", "", "scan for devices with IDs, for example 1-247")
 	flag.Parse()
 
 	if *flagPort == "" {
@@ -51,6 +52,21 @@ func main() {
 	transport := modbus.NewRTU(portRR)
 	client := modbus.NewClient(transport, 1)
 
+	if *flagScan != "" {
+		ids, err := modbus.ParseIDs(*flagScan)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// a short timeout keeps scans fast, as each missing device times out
+		portRR.SetTimeout(time.Millisecond*100, time.Millisecond*30)
+		client.SetDebugLevel(0)
+		for _, r := range client.Scan(ids, true, nil) {
+			log.Printf("Found ID %v: %v\n", r.ID, r.DeviceID)
+		}
+		return
+	}
+
 	// Read discrete inputs.
 	coils, _ := client.ReadCoils(1, 128, 1)
 	if len(coils) != 1 {
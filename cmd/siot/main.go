@@ -36,6 +36,7 @@ func main() {
 		fmt.Println("  - serve (start the SIOT server)")
 		fmt.Println("  - log (log SIOT messages)")
 		fmt.Println("  - store (store maint, requires server to be running)")
+		fmt.Println("  - modbus scan (scan for Modbus devices)")
 	}
 
 	_ = flags.Parse(os.Args[1:])
@@ -64,8 +65,10 @@ func main() {
 		runLog(args[1:])
 	case "store":
 		runStore(args[1:])
+	case "modbus":
+		runModbus(args[1:])
 	default:
-		log.Fatal("Unknown command; options: serve, log, store")
+		log.Fatal("Unknown command; options: serve, log, store, modbus")
 	}
 }
 
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+	"github.com/simpleiot/simpleiot/node"
+	"github.com/simpleiot/simpleiot/respreader"
+	"go.bug.st/serial"
+)
+
+func runModbus(args []string) {
+	if len(args) < 1 || args[0] != "scan" {
+		log.Fatal("Unknown modbus command; options: scan")
+	}
+
+	defaultNatsServer := "nats://localhost:4222"
+	flags := flag.NewFlagSet("modbus scan", flag.ExitOnError)
+	flagPort := flags.String("port", "", "Serial port")
+	flagProtocol := flags.String("protocol", "RTU", "Serial protocol (RTU or ASCII)")
+	flagBaud := flags.String("baud", "9600", "Baud rates to scan, for example 9600,19200")
+	flagURI := flags.String("uri", "", "Modbus TCP server to scan, for example 192.168.1.10:502")
+	flagIDs := flags.String("ids", "1-247", "IDs to scan, for example 1-10,20")
+	flagDeviceID := flags.Bool("deviceId", false, "Read device identification (FC43) from devices found")
+	flagTimeout := flags.Duration("timeout", 100*time.Millisecond, "Response timeout")
+	flagBus := flags.String("bus", "", "Scan a bus on a running SIOT instance (bus node ID)")
+	flagNatsServer := flags.String("natsServer", defaultNatsServer, "NATS Server")
+	flagAuthToken := flags.String("token", "", "Auth token")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		log.Fatal("error: ", err)
+	}
+
+	ids, err := modbus.ParseIDs(*flagIDs)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+
+	var bauds []int
+	for _, b := range strings.Split(*flagBaud, ",") {
+		baud, err := strconv.Atoi(strings.TrimSpace(b))
+		if err != nil {
+			log.Fatal("Invalid baud: ", b)
+		}
+		bauds = append(bauds, baud)
+	}
+
+	found := func(baud int) func(r modbus.ScanResult) {
+		return func(r modbus.ScanResult) {
+			fmt.Printf("ID %v, baud %v: %v\n", r.ID, baud, r.DeviceID)
+		}
+	}
+
+	switch {
+	case *flagBus != "":
+		// only consider env if command line option is something different
+		// that default
+		natsServer := *flagNatsServer
+		if natsServer == defaultNatsServer {
+			natsServerE := os.Getenv("SIOT_NATS_SERVER")
+			if natsServerE != "" {
+				natsServer = natsServerE
+			}
+		}
+
+		// baud rates are only sent if set, so the bus baud is used by
+		// default
+		var busBauds []int
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "baud" {
+				busBauds = bauds
+			}
+		})
+
+		err := scanBus(natsServer, *flagAuthToken, *flagBus,
+			node.ModbusScanRequest{
+				IDs:      *flagIDs,
+				Bauds:    busBauds,
+				DeviceID: *flagDeviceID,
+			})
+		if err != nil {
+			log.Fatal("Error scanning bus: ", err)
+		}
+
+	case *flagURI != "":
+		sock, err := net.DialTimeout("tcp", *flagURI, 5*time.Second)
+		if err != nil {
+			log.Fatal("Error connecting: ", err)
+		}
+
+		c := modbus.NewClient(modbus.NewTCP(sock, *flagTimeout,
+			modbus.TransportClient), 0)
+		c.Scan(ids, *flagDeviceID, found(0))
+		c.Close()
+
+	case *flagPort != "":
+		for _, baud := range bauds {
+			log.Printf("Scanning %v at %v baud\n", *flagPort, baud)
+			port, err := serial.Open(*flagPort, &serial.Mode{BaudRate: baud})
+			if err != nil {
+				log.Fatal("Error opening serial port: ", err)
+			}
+
+			portRR := respreader.NewReadWriteCloser(port, *flagTimeout,
+				time.Millisecond*20)
+
+			var transport modbus.Transport
+			switch *flagProtocol {
+			case data.PointValueRTU:
+				transport = modbus.NewRTU(portRR)
+			case data.PointValueASCII:
+				transport = modbus.NewASCII(portRR)
+			default:
+				log.Fatal("Invalid protocol: ", *flagProtocol)
+			}
+
+			c := modbus.NewClient(transport, 0)
+			c.Scan(ids, *flagDeviceID, found(baud))
+			c.Close()
+		}
+
+	default:
+		fmt.Println("Error, one of -port, -uri, or -bus must be given.")
+		flags.Usage()
+	}
+}
+
+// scanTimePerID is the time allowed to probe one ID on a bus of a running
+// SIOT instance. This includes the response timeout, reading the device
+// identification, and the time between probes.
+const scanTimePerID = 2 * time.Second
+
+// scanBus starts a scan on a bus of a running SIOT instance and prints the
+// devices found
+func scanBus(natsServer, authToken, busID string, req node.ModbusScanRequest) error {
+	ids, err := modbus.ParseIDs(req.IDs)
+	if err != nil {
+		return err
+	}
+
+	nc, err := client.EdgeConnect(client.EdgeOptions{
+		URI:       natsServer,
+		AuthToken: authToken,
+		NoEcho:    true,
+	})
+	if err != nil {
+		return fmt.Errorf("Error connecting to NATS server: %w", err)
+	}
+	defer nc.Close()
+
+	done := make(chan struct{}, 1)
+	sub, err := nc.Subscribe(client.SubjectNodePoints(busID), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Error decoding points: ", err)
+			return
+		}
+
+		for _, p := range points {
+			switch {
+			case p.Type == data.PointTypeScanDevice && p.Tombstone%2 == 0:
+				fmt.Printf("ID %v, baud %v: %v\n", p.Key, p.Value, p.Text)
+			case p.Type == data.PointTypeScanning && p.Value == 0:
+				select {
+				case done <- struct{}{}:
+				default:
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	err = node.ModbusScan(nc, busID, req)
+	if err != nil {
+		return err
+	}
+
+	bauds := len(req.Bauds)
+	if bauds == 0 {
+		bauds = 1
+	}
+
+	timeout := time.Duration(len(ids)*bauds)*scanTimePerID + 10*time.Second
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout waiting for scan to finish after %v", timeout)
+	}
+
+	return nil
+}
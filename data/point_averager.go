@@ -30,6 +30,14 @@ func (pa *PointAverager) AddPoint(s Point) {
 	}
 
 	// update statistical values.
+	if pa.count == 0 || s.Value < pa.min {
+		pa.min = s.Value
+	}
+
+	if pa.count == 0 || s.Value > pa.max {
+		pa.max = s.Value
+	}
+
 	pa.total += s.Value
 	pa.count++
 }
@@ -57,3 +65,26 @@ func (pa *PointAverager) GetAverage() Point {
 		Value: value,
 	}
 }
+
+// GetMin returns the minimum of the accumulated points
+func (pa *PointAverager) GetMin() Point {
+	return Point{
+		Type:  pa.pointType,
+		Time:  pa.pointTime,
+		Value: pa.min,
+	}
+}
+
+// GetMax returns the maximum of the accumulated points
+func (pa *PointAverager) GetMax() Point {
+	return Point{
+		Type:  pa.pointType,
+		Time:  pa.pointTime,
+		Value: pa.max,
+	}
+}
+
+// GetCount returns the number of accumulated points
+func (pa *PointAverager) GetCount() int {
+	return pa.count
+}
@@ -48,6 +48,18 @@ func TestPointAverager(t *testing.T) {
 	if avgPoint.Value != avg {
 		t.Error("point avg is not correct")
 	}
+
+	if pointAverager.GetMin().Value != avg-100 {
+		t.Error("point min is not correct: ", pointAverager.GetMin().Value)
+	}
+
+	if pointAverager.GetMax().Value != avg+100 {
+		t.Error("point max is not correct: ", pointAverager.GetMax().Value)
+	}
+
+	if pointAverager.GetCount() != 6 {
+		t.Error("point count is not correct: ", pointAverager.GetCount())
+	}
 }
 
 func feedPoints(pointAverager *PointAverager, avg float64) {
@@ -92,6 +92,31 @@ const (
 	PointTypeProtocol = "protocol"
 	PointValueRTU     = "RTU"
 	PointValueTCP     = "TCP"
+	PointValueASCII   = "ASCII"
+	// RTU packets over a TCP connection (serial to Ethernet converters)
+	PointValueRTUOverTCP = "RTUOverTCP"
+
+	// TCP port of a Modbus TCP server that forwards requests to devices on
+	// a client bus
+	PointTypeGatewayPort = "gatewayPort"
+
+	// block reads group client IOs into one read request. blockReadMax is
+	// the max number of registers (or coils) per request, and blockReadGap
+	// is the max number of unused registers between IOs in a block.
+	PointTypeBlockReadMax = "blockReadMax"
+	PointTypeBlockReadGap = "blockReadGap"
+
+	// percentage of time a client bus is busy with transactions
+	PointTypeBusUtilization = "busUtilization"
+
+	// set to 1 while a client bus is scanning for devices
+	PointTypeScanning = "scanning"
+	// device found by a bus scan. The key is the Modbus ID, the value is
+	// the baud rate (0 for TCP), and the text is the device identification.
+	PointTypeScanDevice = "scanDevice"
+
+	// set to 1 to capture all bus traffic to a file
+	PointTypeCapture = "capture"
 
 	NodeTypeModbusIO = "modbusIo"
 
@@ -107,6 +132,18 @@ const (
 	PointValueUINT32    = "uint32"
 	PointValueINT32     = "int32"
 	PointValueFLOAT32   = "float32"
+	PointValueUINT64    = "uint64"
+	PointValueINT64     = "int64"
+	PointValueFLOAT64   = "float64"
+	// ASCII string, the number of registers is set by the count point
+	PointValueString = "string"
+	// one bit of a register, the bit number is set by the bit point
+	PointValueBit = "bit"
+
+	PointTypeBit = "bit"
+
+	// order of bytes in values that span registers (ABCD, CDAB, BADC, DCBA)
+	PointTypeByteOrder = "byteOrder"
 
 	NodeTypeOneWire   = "oneWire"
 	NodeTypeOneWireIO = "oneWireIO"
@@ -125,18 +162,57 @@ const (
 
 	PointTypeActive = "active"
 
+	// alarm workflow for rules. ack, shelve (minutes), and unshelve are
+	// commands sent to the rule node. Times are unix seconds.
+	PointTypeAlarm                = "alarm"
+	PointTypeAlarmState           = "alarmState"
+	PointValueAlarmUnacknowledged = "unacknowledged"
+	PointValueAlarmAcknowledged   = "acknowledged"
+	PointValueAlarmCleared        = "cleared"
+	PointValueAlarmShelved        = "shelved"
+	PointTypeAck                  = "ack"
+	PointTypeAckBy                = "ackBy"
+	PointTypeAckTime              = "ackTime"
+	PointTypeShelve               = "shelve"
+	PointTypeUnshelve             = "unshelve"
+	PointTypeShelvedUntil         = "shelvedUntil"
+	PointTypeRenotify             = "renotify"
+
 	NodeTypeCondition = "condition"
 
 	PointTypeConditionType = "conditionType"
 	PointValuePointValue   = "pointValue"
 	PointValueSchedule     = "schedule"
+	PointValueRateOfChange = "rateOfChange"
+	PointValueStale        = "stale"
+	PointValueWindow       = "window"
+	PointValueCron         = "cron"
+	PointValueSun          = "sun"
 
 	PointTypeTrigger = "trigger"
 
-	PointTypeStart   = "start"
-	PointTypeEnd     = "end"
-	PointTypeWeekday = "weekday"
-	PointTypeDate    = "date"
+	PointTypeStart         = "start"
+	PointTypeEnd           = "end"
+	PointTypeWeekday       = "weekday"
+	PointTypeDate          = "date"
+	PointTypeTimeZone      = "timeZone"
+	PointTypeHolidayNodeID = "holidayNodeID"
+
+	// cron and sun conditions are active at an instant (occurrence) and
+	// optionally for activeTime minutes after. lastRun is the time of the
+	// last occurrence (unix seconds).
+	PointTypeCron       = "cron"
+	PointTypeSunEvent   = "sunEvent"
+	PointValueSunrise   = "sunrise"
+	PointValueSunset    = "sunset"
+	PointTypeLatitude   = "latitude"
+	PointTypeLongitude  = "longitude"
+	PointTypeActiveTime = "activeTime"
+	PointTypeLastRun    = "lastRun"
+
+	// a holidays node holds a list of dates that can be used to
+	// exclude schedule conditions in multiple rules
+	NodeTypeHolidays = "holidays"
 
 	PointTypePointID    = "pointID"
 	PointTypePointKey   = "pointKey"
@@ -156,9 +232,24 @@ const (
 	PointValueOff         = "off"
 	PointValueContains    = "contains"
 
-	PointTypeValueText = "valueText"
+	// hysteresis operators use value as the set threshold and
+	// valueClear as the clear threshold
+	PointValueHysteresisHigh = "hysteresisHigh"
+	PointValueHysteresisLow  = "hysteresisLow"
+
+	PointTypeValueText  = "valueText"
+	PointTypeValueClear = "valueClear"
 
-	PointTypeMinActive = "minActive"
+	PointTypeWindowLength = "windowLength"
+	PointTypeAggregate    = "aggregate"
+	PointValueAverage     = "avg"
+	PointValueMin         = "min"
+	PointValueMax         = "max"
+	PointValueCount       = "count"
+
+	PointTypeMinActive    = "minActive"
+	PointTypeMinInactive  = "minInactive"
+	PointTypeStaleTimeout = "staleTimeout"
 
 	NodeTypeAction         = "action"
 	NodeTypeActionInactive = "actionInactive"
@@ -168,6 +259,24 @@ const (
 	PointValueNotify    = "notify"
 	PointValueSetValue  = "setValue"
 	PointValuePlayAudio = "playAudio"
+	PointValueWebhook   = "webhook"
+	PointValuePublish   = "publish"
+
+	PointTypeMethod  = "method"
+	PointTypeHeader  = "header"
+	PointTypeBody    = "body"
+	PointTypeRetries = "retries"
+	PointTypeSubject = "subject"
+
+	PointValueSequence       = "sequence"
+	PointTypeStepNodeID      = "stepNodeID"
+	PointTypeStepPointType   = "stepPointType"
+	PointTypeStepValue       = "stepValue"
+	PointTypeStepWait        = "stepWait"
+	PointTypeRepeat          = "repeat"
+	PointTypeSequenceRunning = "sequenceRunning"
+	PointTypeSequenceStep    = "sequenceStep"
+	PointTypeSequenceCycle   = "sequenceCycle"
 
 	// Transient points that are used for notifications, etc.
 	// These points are not stored in the state of any node,
@@ -191,6 +300,62 @@ const (
 
 	NodeTypeSync = "sync"
 
+	// sync filters. Throttle is keyed by point type and the value is the
+	// min period (seconds) between sending points of that type.
+	PointTypeIncludeNodeID    = "includeNodeID"
+	PointTypeExcludeNodeID    = "excludeNodeID"
+	PointTypeIncludeNodeType  = "includeNodeType"
+	PointTypeExcludeNodeType  = "excludeNodeType"
+	PointTypeIncludePointType = "includePointType"
+	PointTypeExcludePointType = "excludePointType"
+	PointTypeThrottle         = "throttle"
+
+	// sync batching and stats. Batches of points are compressed and sent
+	// every batchPeriod (ms).
+	PointTypeBytesSent     = "bytesSent"
+	PointTypeBytesReceived = "bytesReceived"
+
+	// max number of points stored in the sync offline queue
+	PointTypeQueueMax = "queueMax"
+
+	// sync conflicts
+	PointTypeLastSync        = "lastSync"
+	PointTypeConflictPolicy  = "conflictPolicy"
+	PointValueNewest         = "newest"
+	PointValueUpstream       = "upstream"
+	PointValueDownstream     = "downstream"
+	PointValueManual         = "manual"
+	PointTypeConflict        = "conflict"
+	PointTypeConflictResolve = "conflictResolve"
+	PointTypeConflictClear   = "conflictClear"
+
+	// sync credentials. Certificates and keys are PEM encoded, and creds
+	// is the contents of a NATS credentials file. Cert expiry is in unix
+	// seconds.
+	PointTypeTLSCert    = "tlsCert"
+	PointTypeTLSKey     = "tlsKey"
+	PointTypeTLSCA      = "tlsCA"
+	PointTypeNKeySeed   = "nkeySeed"
+	PointTypeCreds      = "creds"
+	PointTypeCertExpiry = "certExpiry"
+
+	// sync diagnostics. connected is also used by other clients. RTT is
+	// in ms.
+	PointTypeRTT            = "rtt"
+	PointTypeNodesOutOfSync = "nodesOutOfSync"
+	PointTypeQueueSize      = "queueSize"
+	PointTypeLastError      = "lastError"
+
+	// discovery of SIOT instances on the local network (mDNS). peer is
+	// keyed by the root node ID of the discovered instance and the text
+	// is the ID of the sync node created for it.
+	NodeTypeDiscovery    = "discovery"
+	PointTypeAdvertise   = "advertise"
+	PointTypeAcceptSync  = "acceptSync"
+	PointTypeAutoSync    = "autoSync"
+	PointTypePeer        = "peer"
+	PointTypePeerApprove = "peerApprove"
+
 	PointTypeMetricNatsCycleNodePoint          = "metricNatsCycleNodePoint"
 	PointTypeMetricNatsCycleNodeEdgePoint      = "metricNatsCycleNodeEdgePoint"
 	PointTypeMetricNatsCycleNode               = "metricNatsCycleNode"
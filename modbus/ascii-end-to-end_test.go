@@ -0,0 +1,90 @@
+package modbus
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/respreader"
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestASCIIEndToEnd(t *testing.T) {
+	id := byte(1)
+
+	// create virtual serial wire to simulate connection between
+	// server and client
+	a, b := test.NewIoSim()
+
+	portA := respreader.NewReadWriteCloser(a, time.Second*2,
+		5*time.Millisecond)
+	regs := &Regs{}
+	slave := NewServer(id, NewASCII(portA), regs, 0)
+	regs.AddCoil(128)
+	err := regs.WriteCoil(128, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs.AddReg(2, 125)
+	err = regs.WriteReg(2, 0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go slave.Listen(func(err error) {
+		log.Println("modbus server listen error: ", err)
+	}, func() {}, func() {})
+
+	portB := respreader.NewReadWriteCloser(b, time.Second*2,
+		5*time.Millisecond)
+	master := NewClient(NewASCII(portB), 0)
+
+	coils, err := master.ReadCoils(id, 128, 1)
+	if err != nil {
+		t.Fatal("read coils returned err: ", err)
+	}
+
+	if len(coils) != 1 || coils[0] != true {
+		t.Fatal("wrong coil value: ", coils)
+	}
+
+	hr, err := master.ReadHoldingRegs(id, 2, 1)
+	if err != nil {
+		t.Fatal("read holding regs returned err: ", err)
+	}
+
+	if len(hr) != 1 || hr[0] != 0x1234 {
+		t.Fatalf("read holding reg returned wrong value: %x", hr)
+	}
+
+	err = master.WriteMultipleRegs(id, 3, Float32ToRegs([]float32{12.5}))
+	if err != nil {
+		t.Fatal("write multiple regs returned err: ", err)
+	}
+
+	v, err := regs.ReadRegFloat32(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 12.5 {
+		t.Fatal("wrong float value: ", v)
+	}
+
+	// max size read, the response frame is larger than a binary ADU
+	hr, err = master.ReadHoldingRegs(id, 2, 125)
+	if err != nil {
+		t.Fatal("read 125 holding regs returned err: ", err)
+	}
+
+	if len(hr) != 125 || hr[0] != 0x1234 {
+		t.Fatal("wrong regs from max size read")
+	}
+
+	// address 200 does not exist
+	_, err = master.ReadHoldingRegs(id, 200, 1)
+	if err != ExcIllegalAddress {
+		t.Fatal("expected illegal address exception, got: ", err)
+	}
+}
@@ -2,10 +2,12 @@ package modbus
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 const (
@@ -36,6 +38,104 @@ func (m *Modbus) Read() ([]byte, error) {
 	return m.bufRead.ReadBytes(0xA)
 }
 
+// ASCII defines a Modbus ASCII connection. Frames start with ':', end
+// with CRLF, and contain the hex encoded address, PDU, and LRC.
+type ASCII struct {
+	port io.ReadWriteCloser
+	buf  []byte
+	rx   []byte
+}
+
+// NewASCII creates a new ASCII transport
+func NewASCII(port io.ReadWriteCloser) *ASCII {
+	return &ASCII{
+		port: port,
+		rx:   make([]byte, asciiMaxSize),
+	}
+}
+
+// Read returns one ASCII frame. Data is read from the port until the end
+// of a frame is received, so frames split across several port reads are
+// reassembled. Any partial frame is discarded if the port returns an error.
+func (a *ASCII) Read(p []byte) (int, error) {
+	for {
+		if i := bytes.IndexByte(a.buf, '\n'); i >= 0 {
+			frame := a.buf[:i+1]
+			// skip any noise before the start of the frame
+			if start := bytes.IndexByte(frame, asciiStart); start > 0 {
+				frame = frame[start:]
+			}
+			cnt := copy(p, frame)
+			a.buf = a.buf[i+1:]
+			if cnt < len(frame) {
+				return cnt, errors.New("ASCII frame larger than read buffer")
+			}
+			return cnt, nil
+		}
+
+		if len(a.buf) > asciiMaxSize {
+			a.buf = nil
+			return 0, errors.New("ASCII frame too long")
+		}
+
+		cnt, err := a.port.Read(a.rx)
+		a.buf = append(a.buf, a.rx[:cnt]...)
+		if err != nil {
+			a.buf = nil
+			return 0, err
+		}
+	}
+}
+
+func (a *ASCII) Write(p []byte) (int, error) {
+	return a.port.Write(p)
+}
+
+// Close closes the serial port
+func (a *ASCII) Close() error {
+	return a.port.Close()
+}
+
+// Encode encodes an ASCII frame
+func (a *ASCII) Encode(id byte, pdu PDU) ([]byte, error) {
+	adu := make([]byte, len(pdu.Data)+3)
+	adu[0] = id
+	adu[1] = byte(pdu.FunctionCode)
+	copy(adu[2:], pdu.Data)
+	adu[len(adu)-1] = LRC(adu[:len(adu)-1])
+
+	frame := string(asciiStart) + strings.ToUpper(hex.EncodeToString(adu)) +
+		asciiEnd
+
+	return []byte(frame), nil
+}
+
+// Decode decodes an ASCII frame
+func (a *ASCII) Decode(packet []byte) (byte, PDU, error) {
+	adu, err := DecodeASCIIPDU(packet)
+	if err != nil {
+		return 0, PDU{}, err
+	}
+
+	return adu.Address, PDU{FunctionCode: adu.FunctionCode, Data: adu.Data}, nil
+}
+
+// Type returns TransportType
+func (a *ASCII) Type() TransportType {
+	return TransportTypeASCII
+}
+
+// LRC calculates the longitudinal redundancy check of Modbus ASCII
+// frames, which is the two's complement of the sum of the bytes
+func LRC(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+
+	return -sum
+}
+
 // ASCIIADU is a modbus protocol data unit
 type ASCIIADU struct {
 	Address      byte
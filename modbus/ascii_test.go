@@ -3,6 +3,7 @@ package modbus
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -222,3 +223,74 @@ func TestASCIIDecode3(t *testing.T) {
 	}
 
 }
+
+func TestASCIIEncodeDecode(t *testing.T) {
+	a := NewASCII(nil)
+
+	frame, err := a.Encode(1, ReadHoldingRegs(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(frame) != ":010300000001FB\r\n" {
+		t.Fatalf("wrong frame: %q", frame)
+	}
+
+	id, pdu, err := a.Decode(testData1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 3 || pdu.FunctionCode != FuncCodeReadHoldingRegisters ||
+		!bytes.Equal(pdu.Data, []byte{0, 0, 0, 6}) {
+		t.Fatalf("wrong decode, id: %v, pdu: %v", id, pdu)
+	}
+}
+
+// chunkPort returns one chunk of data for each read
+type chunkPort struct {
+	chunks [][]byte
+}
+
+func (c *chunkPort) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func (c *chunkPort) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *chunkPort) Close() error { return nil }
+
+func TestASCIIRead(t *testing.T) {
+	port := &chunkPort{chunks: [][]byte{
+		// noise, then a frame split across reads
+		[]byte("xx:0103"),
+		[]byte("00000001FB\r"),
+		// end of first frame and start of second in the same read
+		[]byte("\n:0103000"),
+		[]byte("00001FB\r\n"),
+	}}
+
+	a := NewASCII(port)
+
+	for i := 0; i < 2; i++ {
+		buf := make([]byte, maxFrameSize)
+		cnt, err := a.Read(buf)
+		if err != nil {
+			t.Fatal("read error: ", err)
+		}
+
+		if string(buf[:cnt]) != ":010300000001FB\r\n" {
+			t.Fatalf("frame %v wrong: %q", i, buf[:cnt])
+		}
+	}
+
+	_, err := a.Read(make([]byte, maxFrameSize))
+	if err != io.EOF {
+		t.Fatal("expected EOF, got: ", err)
+	}
+}
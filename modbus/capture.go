@@ -0,0 +1,406 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Captures are written in the classic pcap file format so they can also be
+// opened with tools like Wireshark. Each transport type is stored with its
+// own user link type, and each packet is one ADU prefixed by a direction
+// byte.
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapHeaderLen    = 24
+	pcapRecordLen    = 16
+	pcapSnapLen      = 65535
+	pcapLinkTypeUser = 147
+)
+
+var captureLinkTypes = []TransportType{
+	TransportTypeRTU,
+	TransportTypeASCII,
+	TransportTypeTCP,
+	TransportTypeRTUOverTCP,
+}
+
+func captureLinkType(t TransportType) (uint32, error) {
+	for i, lt := range captureLinkTypes {
+		if lt == t {
+			return pcapLinkTypeUser + uint32(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("capture: unsupported transport type: %v", t)
+}
+
+// Direction is the direction of a captured frame
+type Direction byte
+
+// define valid directions
+const (
+	// DirectionTx is a frame written to the transport
+	DirectionTx Direction = 0
+	// DirectionRx is a frame read from the transport
+	DirectionRx Direction = 1
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionTx:
+		return "tx"
+	case DirectionRx:
+		return "rx"
+	default:
+		return fmt.Sprintf("unknown(%v)", byte(d))
+	}
+}
+
+// Frame is one captured ADU
+type Frame struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%v %v %x", f.Time.Format(time.RFC3339Nano),
+		f.Direction, f.Data)
+}
+
+// CaptureWriter writes frames to a capture. It is safe to use from several
+// goroutines, so one capture can be shared by all connections of a TCP
+// server.
+type CaptureWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+// captureHeader returns the capture file header for transport type t
+func captureHeader(t TransportType) ([]byte, error) {
+	lt, err := captureLinkType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, pcapHeaderLen)
+	binary.LittleEndian.PutUint32(h[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(h[20:], lt)
+
+	return h, nil
+}
+
+// NewCaptureWriter writes the capture header to w and returns a writer for
+// frames of transport type t.
+func NewCaptureWriter(w io.Writer, t TransportType) (*CaptureWriter, error) {
+	h, err := captureHeader(t)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteFrame adds a frame to the capture
+func (c *CaptureWriter) WriteFrame(f Frame) error {
+	l := len(f.Data) + 1
+	rec := make([]byte, pcapRecordLen+l)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(f.Time.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(f.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(l))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(l))
+	rec[pcapRecordLen] = byte(f.Direction)
+	copy(rec[pcapRecordLen+1:], f.Data)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.w.Write(rec)
+	return err
+}
+
+// readCaptureHeader reads the capture header and returns the transport type
+func readCaptureHeader(r io.Reader) (TransportType, error) {
+	h := make([]byte, pcapHeaderLen)
+	_, err := io.ReadFull(r, h)
+	if err != nil {
+		return "", fmt.Errorf("capture: error reading header: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(h[0:]) != pcapMagic {
+		return "", errors.New("capture: not a little endian pcap file")
+	}
+
+	lt := binary.LittleEndian.Uint32(h[20:])
+	if lt < pcapLinkTypeUser || lt >= pcapLinkTypeUser+uint32(len(captureLinkTypes)) {
+		return "", fmt.Errorf("capture: unsupported link type: %v", lt)
+	}
+
+	return captureLinkTypes[lt-pcapLinkTypeUser], nil
+}
+
+// ReadCapture reads all frames from a capture. A record cut short at the
+// end of the capture, which happens if a device loses power while
+// capturing, is ignored.
+func ReadCapture(r io.Reader) (TransportType, []Frame, error) {
+	t, err := readCaptureHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var frames []Frame
+	rec := make([]byte, pcapRecordLen)
+
+	for {
+		_, err := io.ReadFull(r, rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return t, frames, nil
+		}
+		if err != nil {
+			return t, frames, err
+		}
+
+		sec := binary.LittleEndian.Uint32(rec[0:])
+		usec := binary.LittleEndian.Uint32(rec[4:])
+		l := binary.LittleEndian.Uint32(rec[8:])
+		if l < 1 || l > pcapSnapLen {
+			return t, frames, fmt.Errorf("capture: invalid record length: %v", l)
+		}
+
+		buf := make([]byte, l)
+		_, err = io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return t, frames, nil
+		}
+		if err != nil {
+			return t, frames, err
+		}
+
+		frames = append(frames, Frame{
+			Time:      time.Unix(int64(sec), int64(usec)*1000),
+			Direction: Direction(buf[0]),
+			Data:      buf[1:],
+		})
+	}
+}
+
+// ReadCaptureFile reads all frames from a capture file
+func ReadCaptureFile(name string) (TransportType, []Frame, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	return ReadCapture(f)
+}
+
+// CaptureFile is a capture written to a file
+type CaptureFile struct {
+	*CaptureWriter
+	file *captureFile
+}
+
+// OpenCaptureFile opens a capture file for writing. Frames are appended to
+// an existing capture of the same transport type, so a capture survives
+// port reconnects and restarts. Otherwise, a new capture is started.
+//
+// If maxSize is greater than 0, the capture is rotated when a frame would
+// make the file larger than maxSize: the file is renamed to name.1,
+// replacing the previous one, and a new capture is started. A capture then
+// uses at most twice maxSize on disk.
+func OpenCaptureFile(name string, t TransportType, maxSize int64) (*CaptureFile, error) {
+	header, err := captureHeader(t)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &captureFile{
+		name:    name,
+		file:    f,
+		header:  header,
+		maxSize: maxSize,
+	}
+
+	existing, err := readCaptureHeader(f)
+	if err == nil && existing == t {
+		// drop a record cut short by power loss so new records are
+		// appended in line
+		cf.size, err = captureRecordsEnd(f)
+		if err == nil {
+			err = f.Truncate(cf.size)
+		}
+		if err == nil {
+			_, err = f.Seek(cf.size, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &CaptureFile{CaptureWriter: &CaptureWriter{w: cf}, file: cf}, nil
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w, err := NewCaptureWriter(cf, t)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &CaptureFile{CaptureWriter: w, file: cf}, nil
+}
+
+// captureRecordsEnd reads the records following the capture header and
+// returns the file offset of the end of the last complete record
+func captureRecordsEnd(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	end := int64(pcapHeaderLen)
+	rec := make([]byte, pcapRecordLen)
+
+	for {
+		_, err := io.ReadFull(br, rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+
+		l := binary.LittleEndian.Uint32(rec[8:])
+		if l < 1 || l > pcapSnapLen {
+			return end, nil
+		}
+
+		_, err = io.CopyN(io.Discard, br, int64(l))
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+
+		end += pcapRecordLen + int64(l)
+	}
+}
+
+// Close closes the capture file
+func (c *CaptureFile) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.file.file.Close()
+}
+
+// captureFile is the writer of a CaptureFile. Each write is one header or
+// record, so the file is only rotated between records.
+type captureFile struct {
+	name    string
+	file    *os.File
+	header  []byte
+	size    int64
+	maxSize int64
+}
+
+func (f *captureFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > int64(len(f.header)) &&
+		f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("capture: error rotating file: %w", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the capture to name.1 and starts a new capture
+func (f *captureFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.name, f.name+".1")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+
+	n, err := f.file.Write(f.header)
+	f.size = int64(n)
+	return err
+}
+
+// CaptureTransport records every ADU read from or written to a transport.
+// It can be passed to a client or server in place of the transport it
+// wraps.
+type CaptureTransport struct {
+	Transport
+	capture *CaptureWriter
+}
+
+// NewCaptureTransport wraps a transport so its traffic is written to a
+// capture
+func NewCaptureTransport(t Transport, capture *CaptureWriter) *CaptureTransport {
+	return &CaptureTransport{
+		Transport: t,
+		capture:   capture,
+	}
+}
+
+func (c *CaptureTransport) record(dir Direction, p []byte) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	err := c.capture.WriteFrame(Frame{Time: time.Now(), Direction: dir, Data: data})
+	if err != nil {
+		log.Println("Modbus capture error: ", err)
+	}
+}
+
+// Read reads a frame from the transport and records it
+func (c *CaptureTransport) Read(p []byte) (int, error) {
+	n, err := c.Transport.Read(p)
+	if n > 0 {
+		c.record(DirectionRx, p[:n])
+	}
+	return n, err
+}
+
+// Write records a frame and writes it to the transport
+func (c *CaptureTransport) Write(p []byte) (int, error) {
+	c.record(DirectionTx, p)
+	return c.Transport.Write(p)
+}
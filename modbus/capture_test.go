@@ -0,0 +1,304 @@
+package modbus
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/respreader"
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewCaptureWriter(&buf, TransportTypeTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 123456000)
+	frames := []Frame{
+		{Time: start, Direction: DirectionTx, Data: []byte{1, 2, 3}},
+		{Time: start.Add(time.Millisecond), Direction: DirectionRx, Data: []byte{4, 5}},
+	}
+
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a record cut short is ignored
+	truncated := buf.Bytes()[:buf.Len()-1]
+
+	typ, read, err := ReadCapture(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ != TransportTypeTCP {
+		t.Error("wrong transport type: ", typ)
+	}
+
+	if len(read) != 1 || !reflect.DeepEqual(read[0], frames[0]) {
+		t.Error("wrong frames: ", read)
+	}
+
+	_, read, err = ReadCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read) != 2 || !read[1].Time.Equal(frames[1].Time) ||
+		read[1].Direction != DirectionRx ||
+		!bytes.Equal(read[1].Data, frames[1].Data) {
+		t.Error("wrong frames: ", read)
+	}
+}
+
+func TestCaptureFileRotate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bus.pcap")
+
+	// room for the header and two 3 byte frames
+	maxSize := int64(pcapHeaderLen + 2*(pcapRecordLen+4))
+
+	c, err := OpenCaptureFile(name, TransportTypeRTU, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	frame := func(i int) Frame {
+		return Frame{Time: start.Add(time.Duration(i) * time.Second),
+			Direction: DirectionTx, Data: []byte{byte(i), 2, 3}}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := c.WriteFrame(frame(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening appends to the current capture
+	c, err = OpenCaptureFile(name, TransportTypeRTU, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WriteFrame(frame(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, exp ...int) {
+		t.Helper()
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > maxSize {
+			t.Errorf("%v: size %v is larger than max", name, info.Size())
+		}
+
+		typ, frames, err := ReadCaptureFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != TransportTypeRTU || len(frames) != len(exp) {
+			t.Fatalf("%v: wrong capture: %v %v", name, typ, frames)
+		}
+		for i, f := range frames {
+			if f.Data[0] != byte(exp[i]) {
+				t.Errorf("%v: wrong frame %v: %v", name, i, f)
+			}
+		}
+	}
+
+	check(name+".1", 0, 1)
+	check(name, 2, 3)
+}
+
+func TestCaptureFileTruncated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bus.pcap")
+
+	c, err := OpenCaptureFile(name, TransportTypeRTU, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	frame := func(i int) Frame {
+		return Frame{Time: start.Add(time.Duration(i) * time.Second),
+			Direction: DirectionTx, Data: []byte{byte(i), 2, 3}}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.WriteFrame(frame(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the last record short as a power loss would
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Truncate(name, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenCaptureFile(name, TransportTypeRTU, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 2; i < 4; i++ {
+		if err := c.WriteFrame(frame(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, frames, err := ReadCaptureFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []int{0, 2, 3}
+	if len(frames) != len(exp) {
+		t.Fatal("wrong frames: ", frames)
+	}
+
+	for i, f := range frames {
+		if !reflect.DeepEqual(f, frame(exp[i])) {
+			t.Errorf("wrong frame %v: %v", i, f)
+		}
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	id := byte(1)
+	a, b := test.NewIoSim()
+
+	portA := respreader.NewReadWriteCloser(a, time.Second*2,
+		5*time.Millisecond)
+	regs := &Regs{}
+	regs.AddReg(2, 1)
+	_ = regs.WriteReg(2, 0x1234)
+	server := NewServer(id, NewRTU(portA), regs, 0)
+
+	go server.Listen(func(err error) {
+		log.Println("modbus server listen error: ", err)
+	}, func() {}, func() {})
+
+	// capture the client side of the bus
+	var buf bytes.Buffer
+	capture, err := NewCaptureWriter(&buf, TransportTypeRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portB := respreader.NewReadWriteCloser(b, time.Millisecond*100,
+		5*time.Millisecond)
+	client := NewClient(NewCaptureTransport(NewRTU(portB), capture), 0)
+
+	regsRead, err := client.ReadHoldingRegs(id, 2, 1)
+	if err != nil || regsRead[0] != 0x1234 {
+		t.Fatal("read failed: ", regsRead, err)
+	}
+
+	// ID 2 is not on the bus, so this request times out
+	_, err = client.ReadHoldingRegs(2, 2, 1)
+	if err == nil {
+		t.Fatal("expected error reading missing device")
+	}
+
+	typ, frames, err := ReadCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ != TransportTypeRTU || len(frames) != 3 {
+		t.Fatal("wrong capture: ", typ, frames)
+	}
+
+	// replay to a client reproduces the responses without the device
+	replay := NewReplay(NewRTU(nil), frames)
+	client = NewClient(replay, 0)
+
+	regsRead, err = client.ReadHoldingRegs(id, 2, 1)
+	if err != nil || regsRead[0] != 0x1234 {
+		t.Error("replayed read failed: ", regsRead, err)
+	}
+
+	_, err = client.ReadHoldingRegs(2, 2, 1)
+	if err == nil {
+		t.Error("expected replayed timeout")
+	}
+
+	if replay.Remaining() != 0 {
+		t.Error("frames not played back: ", replay.Remaining())
+	}
+
+	// a request that differs from the capture is an error
+	replay = NewReplay(NewRTU(nil), frames)
+	client = NewClient(replay, 0)
+	_, err = client.ReadHoldingRegs(id, 3, 1)
+	if err == nil {
+		t.Error("expected mismatch error")
+	}
+
+	// the server side of the bus sees the same frames in the other
+	// direction
+	serverFrames := make([]Frame, len(frames))
+	for i, f := range frames {
+		serverFrames[i] = f
+		if f.Direction == DirectionTx {
+			serverFrames[i].Direction = DirectionRx
+		} else {
+			serverFrames[i].Direction = DirectionTx
+		}
+	}
+
+	replay = NewReplay(NewRTU(nil), serverFrames)
+	done := make(chan struct{})
+	server = NewServer(id, replay, regs, 0)
+	go server.Listen(func(err error) {
+		t.Error("replayed server error: ", err)
+	}, func() {}, func() {
+		close(done)
+	})
+
+	timeout := time.After(time.Second)
+	for replay.Remaining() > 0 {
+		select {
+		case <-timeout:
+			t.Fatal("timeout replaying to server, remaining: ",
+				replay.Remaining())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	_ = server.Close()
+	<-done
+}
@@ -35,250 +35,139 @@ func (c *Client) Close() error {
 	return c.transport.Close()
 }
 
-// ReadCoils is used to read modbus coils
-func (c *Client) ReadCoils(id byte, coil, count uint16) ([]bool, error) {
-	ret := []bool{}
-	req := ReadCoils(coil, count)
+// send encodes a request PDU and writes it to the transport
+func (c *Client) send(name string, id byte, req PDU) error {
 	if c.debug >= 1 {
-		fmt.Printf("Modbus client Readcoils ID:0x%x req:%v\n", id, req)
+		fmt.Printf("Modbus client %v ID:0x%x req:%v\n", name, id, req)
 	}
 	packet, err := c.transport.Encode(id, req)
 	if err != nil {
-		return ret, err
+		return err
 	}
 
 	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadCoils tx: ", test.HexDump(packet))
+		fmt.Printf("Modbus client %v tx: %v\n", name, test.HexDump(packet))
 	}
 
 	_, err = c.transport.Write(packet)
+	return err
+}
+
+// request sends a request PDU to a device and returns the response PDU.
+// An exception response is returned as an ExceptionCode error.
+func (c *Client) request(name string, id byte, req PDU) (PDU, error) {
+	err := c.send(name, id, req)
 	if err != nil {
-		return ret, err
+		return PDU{}, err
 	}
 
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
+	buf := make([]byte, maxFrameSize)
 	cnt, err := c.transport.Read(buf)
 	if err != nil {
-		return ret, err
+		return PDU{}, err
 	}
 
 	buf = buf[:cnt]
 
 	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadCoils rx: ", test.HexDump(buf))
+		fmt.Printf("Modbus client %v rx: %v\n", name, test.HexDump(buf))
 	}
 
 	_, resp, err := c.transport.Decode(buf)
 	if err != nil {
-		return ret, err
+		return PDU{}, err
 	}
 
 	if c.debug >= 1 {
-		fmt.Printf("Modbus client Readcoils ID:0x%x resp:%v\n", id, resp)
+		fmt.Printf("Modbus client %v ID:0x%x resp:%v\n", name, id, resp)
 	}
 
-	return resp.RespReadBits()
-}
-
-// WriteSingleCoil is used to read modbus coils
-func (c *Client) WriteSingleCoil(id byte, coil uint16, v bool) error {
-	req := WriteSingleCoil(coil, v)
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client WriteSingleCoil ID:0x%x req:%v\n", id, req)
-	}
-	packet, err := c.transport.Encode(id, req)
-	if err != nil {
-		return fmt.Errorf("RtuEncode error: %w", err)
-	}
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client WriteSingleCoil tx: ", test.HexDump(packet))
+	if resp.FunctionCode == req.FunctionCode|0x80 && len(resp.Data) > 0 {
+		return PDU{}, ExceptionCode(resp.Data[0])
 	}
 
-	_, err = c.transport.Write(packet)
-	if err != nil {
-		return err
+	if resp.FunctionCode != req.FunctionCode {
+		return PDU{}, errors.New("resp contains wrong function code")
 	}
 
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
-	cnt, err := c.transport.Read(buf)
-	if err != nil {
-		return err
-	}
+	return resp, nil
+}
 
-	buf = buf[:cnt]
+// Request sends a request PDU to a device and returns the response PDU.
+// This can be used for functions that do not have a helper, or to forward
+// requests (for instance in a gateway). An exception response is returned
+// as an ExceptionCode error.
+func (c *Client) Request(id byte, req PDU) (PDU, error) {
+	return c.request("Request", id, req)
+}
 
-	if c.debug >= 9 {
-		fmt.Println("Modbus client WriteSingleCoil rx: ", test.HexDump(buf))
-	}
+// Broadcast sends a request PDU to all devices (ID 0). Devices do not
+// respond to broadcasts, so no response is read.
+func (c *Client) Broadcast(req PDU) error {
+	return c.send("Broadcast", 0, req)
+}
 
-	_, resp, err := c.transport.Decode(buf)
+// write sends a write request and checks that the response echos the
+// request data (or the first echoLen bytes of it)
+func (c *Client) write(name string, id byte, req PDU, echoLen int) error {
+	resp, err := c.request(name, id, req)
 	if err != nil {
-		return fmt.Errorf("RtuDecode error: %w", err)
-	}
-
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client WriteSingleCoil ID:0x%x resp:%v\n", id, resp)
-	}
-
-	if resp.FunctionCode != req.FunctionCode {
-		return errors.New("resp contains wrong function code")
+		return err
 	}
 
-	if !bytes.Equal(req.Data, resp.Data) {
+	if len(req.Data) < echoLen || !bytes.Equal(req.Data[:echoLen], resp.Data) {
 		return errors.New("Did not get the correct response data")
 	}
 
 	return nil
 }
 
-// ReadDiscreteInputs is used to read modbus discrete inputs
-func (c *Client) ReadDiscreteInputs(id byte, input, count uint16) ([]bool, error) {
-	ret := []bool{}
-	req := ReadDiscreteInputs(input, count)
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadDiscreteInputs ID:0x%x req:%v\n", id, req)
-	}
-	packet, err := c.transport.Encode(id, req)
-	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadDiscreteInputs tx: ", test.HexDump(packet))
-	}
-
-	_, err = c.transport.Write(packet)
+// ReadCoils is used to read modbus coils
+func (c *Client) ReadCoils(id byte, coil, count uint16) ([]bool, error) {
+	resp, err := c.request("ReadCoils", id, ReadCoils(coil, count))
 	if err != nil {
-		return ret, err
+		return []bool{}, err
 	}
 
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
-	cnt, err := c.transport.Read(buf)
-	if err != nil {
-		return ret, err
-	}
+	return resp.respReadBits(int(count))
+}
 
-	buf = buf[:cnt]
+// WriteSingleCoil is used to write a modbus coil
+func (c *Client) WriteSingleCoil(id byte, coil uint16, v bool) error {
+	req := WriteSingleCoil(coil, v)
+	return c.write("WriteSingleCoil", id, req, len(req.Data))
+}
 
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadDiscreteInputs rx: ", test.HexDump(buf))
-	}
+// WriteMultipleCoils is used to write consecutive modbus coils (FC15)
+func (c *Client) WriteMultipleCoils(id byte, coil uint16, values []bool) error {
+	return c.write("WriteMultipleCoils", id, WriteMultipleCoils(coil, values), 4)
+}
 
-	_, resp, err := c.transport.Decode(buf)
+// ReadDiscreteInputs is used to read modbus discrete inputs
+func (c *Client) ReadDiscreteInputs(id byte, input, count uint16) ([]bool, error) {
+	resp, err := c.request("ReadDiscreteInputs", id, ReadDiscreteInputs(input, count))
 	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadDiscreteInputs ID:0x%x resp:%v\n", id, resp)
+		return []bool{}, err
 	}
 
-	if resp.FunctionCode != req.FunctionCode {
-		return []bool{}, errors.New("resp contains wrong function code")
-	}
-
-	return resp.RespReadBits()
+	return resp.respReadBits(int(count))
 }
 
-// ReadHoldingRegs is used to read modbus coils
+// ReadHoldingRegs is used to read modbus holding registers
 func (c *Client) ReadHoldingRegs(id byte, reg, count uint16) ([]uint16, error) {
-	ret := []uint16{}
-	req := ReadHoldingRegs(reg, count)
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadHoldingRegs ID:0x%x req:%v\n", id, req)
-	}
-	packet, err := c.transport.Encode(id, req)
-	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadHoldingRegs tx: ", test.HexDump(packet))
-	}
-
-	_, err = c.transport.Write(packet)
-	if err != nil {
-		return ret, err
-	}
-
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
-	cnt, err := c.transport.Read(buf)
-	if err != nil {
-		return ret, err
-	}
-
-	buf = buf[:cnt]
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadHoldingRegs rx: ", test.HexDump(buf))
-	}
-
-	_, resp, err := c.transport.Decode(buf)
+	resp, err := c.request("ReadHoldingRegs", id, ReadHoldingRegs(reg, count))
 	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadHoldingRegs ID:0x%x resp:%v\n", id, resp)
-	}
-
-	if resp.FunctionCode != req.FunctionCode {
-		return []uint16{}, errors.New("resp contains wrong function code")
+		return []uint16{}, err
 	}
 
 	return resp.RespReadRegs()
 }
 
-// ReadInputRegs is used to read modbus coils
+// ReadInputRegs is used to read modbus input registers
 func (c *Client) ReadInputRegs(id byte, reg, count uint16) ([]uint16, error) {
-	ret := []uint16{}
-	req := ReadInputRegs(reg, count)
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadInputRegs ID:0x%x req:%v\n", id, req)
-	}
-	packet, err := c.transport.Encode(id, req)
-	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadInputRegs tx: ", test.HexDump(packet))
-	}
-
-	_, err = c.transport.Write(packet)
+	resp, err := c.request("ReadInputRegs", id, ReadInputRegs(reg, count))
 	if err != nil {
-		return ret, err
-	}
-
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
-	cnt, err := c.transport.Read(buf)
-	if err != nil {
-		return ret, err
-	}
-
-	buf = buf[:cnt]
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client ReadInputRegs rx: ", test.HexDump(buf))
-	}
-
-	_, resp, err := c.transport.Decode(buf)
-	if err != nil {
-		return ret, err
-	}
-
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client ReadInputRegs ID:0x%x resp:%v\n", id, resp)
-	}
-
-	if resp.FunctionCode != req.FunctionCode {
-		return []uint16{}, errors.New("resp contains wrong function code")
+		return []uint16{}, err
 	}
 
 	return resp.RespReadRegs()
@@ -287,52 +176,65 @@ func (c *Client) ReadInputRegs(id byte, reg, count uint16) ([]uint16, error) {
 // WriteSingleReg writes to a single holding register
 func (c *Client) WriteSingleReg(id byte, reg, value uint16) error {
 	req := WriteSingleReg(reg, value)
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client WriteSingleReg ID:0x%x req:%v\n", id, req)
-	}
-	packet, err := c.transport.Encode(id, req)
-	if err != nil {
-		return err
-	}
-
-	if c.debug >= 9 {
-		fmt.Println("Modbus client WriteSingleReg tx: ", test.HexDump(packet))
-	}
-
-	_, err = c.transport.Write(packet)
-	if err != nil {
-		return err
-	}
-
-	// FIXME, what is max modbus packet size?
-	buf := make([]byte, 200)
-	cnt, err := c.transport.Read(buf)
-	if err != nil {
-		return err
-	}
-
-	buf = buf[:cnt]
+	return c.write("WriteSingleReg", id, req, len(req.Data))
+}
 
-	if c.debug >= 9 {
-		fmt.Println("Modbus client WriteSingleReg rx: ", test.HexDump(buf))
-	}
+// WriteMultipleRegs writes consecutive holding registers (FC16). This
+// should be used for values that span multiple registers, as many devices
+// only update multi-register values when written in one request.
+func (c *Client) WriteMultipleRegs(id byte, reg uint16, values []uint16) error {
+	return c.write("WriteMultipleRegs", id, WriteMultipleRegs(reg, values), 4)
+}
 
-	_, resp, err := c.transport.Decode(buf)
+// ReadWriteMultipleRegs writes holding registers and then reads holding
+// registers in one transaction (FC23)
+func (c *Client) ReadWriteMultipleRegs(id byte, readReg, readCount, writeReg uint16,
+	values []uint16) ([]uint16, error) {
+	resp, err := c.request("ReadWriteMultipleRegs", id,
+		ReadWriteMultipleRegs(readReg, readCount, writeReg, values))
 	if err != nil {
-		return err
+		return []uint16{}, err
 	}
 
-	if c.debug >= 1 {
-		fmt.Printf("Modbus client WriteSingleReg ID:0x%x resp:%v\n", id, resp)
-	}
-
-	if resp.FunctionCode != req.FunctionCode {
-		return errors.New("resp contains wrong function code")
-	}
+	return resp.RespReadRegs()
+}
 
-	if !bytes.Equal(req.Data, resp.Data) {
-		return errors.New("Did not get the correct response data")
-	}
+// MaskWriteReg modifies bits in a holding register (FC22). The register is
+// set to (current AND andMask) OR (orMask AND NOT andMask).
+func (c *Client) MaskWriteReg(id byte, reg, andMask, orMask uint16) error {
+	req := MaskWriteReg(reg, andMask, orMask)
+	return c.write("MaskWriteReg", id, req, len(req.Data))
+}
 
-	return nil
+// ReadDeviceID reads the device identification objects (FC43) up to the
+// regular category. Objects are requested until the device reports that
+// no more follow.
+func (c *Client) ReadDeviceID(id byte) (DeviceID, error) {
+	ret := make(DeviceID)
+	var next byte
+
+	// a device that does not advance the object ID would loop forever
+	for i := 0; i < 16; i++ {
+		resp, err := c.request("ReadDeviceID", id,
+			ReadDeviceID(ReadDeviceIDRegular, next))
+		if err != nil {
+			return nil, err
+		}
+
+		objects, more, n, err := resp.RespReadDeviceID()
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range objects {
+			ret[k] = v
+		}
+
+		if !more || n <= next {
+			break
+		}
+		next = n
+	}
+
+	return ret, nil
 }
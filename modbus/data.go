@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 )
@@ -156,3 +157,124 @@ func Float32ToRegs(in []float32) []uint16 {
 
 	return ret
 }
+
+// Byte orders for values that span more than one register. The letters
+// describe the order the bytes of a big endian 32-bit value (ABCD) are
+// sent on the bus. For 64-bit values, words are reversed for CDAB and DCBA.
+const (
+	ByteOrderABCD = "ABCD" // big endian, modbus default
+	ByteOrderCDAB = "CDAB" // word swapped
+	ByteOrderBADC = "BADC" // byte swapped
+	ByteOrderDCBA = "DCBA" // little endian
+)
+
+// OrderRegs converts regs between the specified byte order and big endian
+// (ABCD) order. As the conversion is symmetric, it is used both to decode
+// regs read from the bus and to encode regs to be written. An empty or
+// unknown order is treated as ABCD.
+func OrderRegs(in []uint16, order string) []uint16 {
+	ret := make([]uint16, len(in))
+	copy(ret, in)
+
+	switch order {
+	case ByteOrderCDAB, ByteOrderDCBA:
+		for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+			ret[i], ret[j] = ret[j], ret[i]
+		}
+	}
+
+	switch order {
+	case ByteOrderBADC, ByteOrderDCBA:
+		for i, v := range ret {
+			ret[i] = v<<8 | v>>8
+		}
+	}
+
+	return ret
+}
+
+// RegsToUint64 converts modbus regs to uint64 values
+func RegsToUint64(in []uint16) []uint64 {
+	count := len(in) / 4
+	ret := make([]uint64, count)
+	for i := range ret {
+		buf := PutUint16Array(in[i*4 : i*4+4]...)
+		ret[i] = binary.BigEndian.Uint64(buf)
+	}
+
+	return ret
+}
+
+// Uint64ToRegs converts uint64 values to modbus regs
+func Uint64ToRegs(in []uint64) []uint16 {
+	buf := make([]byte, len(in)*8)
+	for i, v := range in {
+		binary.BigEndian.PutUint64(buf[i*8:], v)
+	}
+
+	return Uint16Array(buf)
+}
+
+// RegsToInt64 converts modbus regs to int64 values
+func RegsToInt64(in []uint16) []int64 {
+	u := RegsToUint64(in)
+	ret := make([]int64, len(u))
+	for i, v := range u {
+		ret[i] = int64(v)
+	}
+
+	return ret
+}
+
+// Int64ToRegs converts int64 values to modbus regs
+func Int64ToRegs(in []int64) []uint16 {
+	u := make([]uint64, len(in))
+	for i, v := range in {
+		u[i] = uint64(v)
+	}
+
+	return Uint64ToRegs(u)
+}
+
+// RegsToFloat64 converts modbus regs to float64 values
+func RegsToFloat64(in []uint16) []float64 {
+	u := RegsToUint64(in)
+	ret := make([]float64, len(u))
+	for i, v := range u {
+		ret[i] = math.Float64frombits(v)
+	}
+
+	return ret
+}
+
+// Float64ToRegs converts float64 values to modbus regs
+func Float64ToRegs(in []float64) []uint16 {
+	u := make([]uint64, len(in))
+	for i, v := range in {
+		u[i] = math.Float64bits(v)
+	}
+
+	return Uint64ToRegs(u)
+}
+
+// RegsToString converts modbus regs to an ASCII string. Each register
+// holds two characters, high byte first. The string ends at the first NUL
+// character.
+func RegsToString(in []uint16) string {
+	buf := PutUint16Array(in...)
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	return string(buf)
+}
+
+// StringToRegs converts a string to count modbus regs. The string is
+// truncated if it is too long, and padded with NUL characters if it is too
+// short.
+func StringToRegs(s string, count int) []uint16 {
+	buf := make([]byte, count*2)
+	copy(buf, s)
+
+	return Uint16Array(buf)
+}
@@ -49,3 +49,80 @@ func TestRegsToFloat32SwapWords(t *testing.T) {
 		t.Error("Failed: ", exp, f)
 	}
 }
+
+func TestUint64(t *testing.T) {
+	v := uint64(0x0102030405060708)
+
+	regs := Uint64ToRegs([]uint64{v})
+
+	if regs[0] != 0x0102 || regs[3] != 0x0708 {
+		t.Errorf("Failed, regs: %x", regs)
+	}
+
+	v2 := RegsToUint64(regs)
+
+	if v != v2[0] {
+		t.Error("Failed: ", v, v2[0])
+	}
+}
+
+func TestFloat64(t *testing.T) {
+	v := -2124.23e100
+
+	v2 := RegsToFloat64(Float64ToRegs([]float64{v}))
+
+	if v != v2[0] {
+		t.Error("Failed: ", v, v2[0])
+	}
+}
+
+func TestString(t *testing.T) {
+	regs := StringToRegs("ABC", 3)
+
+	exp := []uint16{0x4142, 0x4300, 0}
+	for i := range exp {
+		if regs[i] != exp[i] {
+			t.Fatalf("Failed, exp: %x, got: %x", exp, regs)
+		}
+	}
+
+	if s := RegsToString(regs); s != "ABC" {
+		t.Error("Failed: ", s)
+	}
+
+	if s := RegsToString(StringToRegs("ABCDEF", 2)); s != "ABCD" {
+		t.Error("Failed to truncate: ", s)
+	}
+}
+
+func TestOrderRegs(t *testing.T) {
+	// 0x3c23d70a is float32 0.01
+	tests := []struct {
+		order string
+		regs  []uint16
+	}{
+		{ByteOrderABCD, []uint16{0x3c23, 0xd70a}},
+		{"", []uint16{0x3c23, 0xd70a}},
+		{ByteOrderCDAB, []uint16{0xd70a, 0x3c23}},
+		{ByteOrderBADC, []uint16{0x233c, 0x0ad7}},
+		{ByteOrderDCBA, []uint16{0x0ad7, 0x233c}},
+	}
+
+	for _, test := range tests {
+		f := RegsToFloat32(OrderRegs(test.regs, test.order))
+		if f[0] != 0.01 {
+			t.Errorf("Order %v failed: %v", test.order, f[0])
+		}
+
+		regs := OrderRegs(Float32ToRegs([]float32{0.01}), test.order)
+		if regs[0] != test.regs[0] || regs[1] != test.regs[1] {
+			t.Errorf("Order %v encode failed: %x", test.order, regs)
+		}
+	}
+
+	// words of 64-bit values are reversed
+	regs := OrderRegs([]uint16{1, 2, 3, 4}, ByteOrderCDAB)
+	if regs[0] != 4 || regs[3] != 1 {
+		t.Errorf("64-bit word swap failed: %v", regs)
+	}
+}
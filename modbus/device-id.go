@@ -0,0 +1,174 @@
+package modbus
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// MEIReadDeviceID is the MEI type used with FuncCodeEncapsulatedInterface
+// to read device identification
+const MEIReadDeviceID byte = 0x0e
+
+// Read device ID codes select which objects are returned. Basic, regular,
+// and extended stream objects, while specific reads one object.
+const (
+	ReadDeviceIDBasic    byte = 1
+	ReadDeviceIDRegular  byte = 2
+	ReadDeviceIDExtended byte = 3
+	ReadDeviceIDSpecific byte = 4
+)
+
+// Device identification object IDs. Objects 0-2 are basic and must be
+// supported by devices that implement FC43. Objects 3-0x7f are regular and
+// 0x80-0xff are extended (device specific).
+const (
+	DeviceIDVendorName          byte = 0
+	DeviceIDProductCode         byte = 1
+	DeviceIDMajorMinorRevision  byte = 2
+	DeviceIDVendorURL           byte = 3
+	DeviceIDProductName         byte = 4
+	DeviceIDModelName           byte = 5
+	DeviceIDUserApplicationName byte = 6
+)
+
+// maxDeviceIDData is the max number of object bytes in one response. The
+// response header is 7 bytes, so this keeps the PDU under 253 bytes.
+const maxDeviceIDData = 240
+
+// DeviceID contains device identification objects indexed by object ID
+type DeviceID map[byte]string
+
+// String returns the basic objects (vendor, product code, and revision)
+// separated by spaces
+func (d DeviceID) String() string {
+	var ret []string
+	for _, id := range []byte{DeviceIDVendorName, DeviceIDProductCode,
+		DeviceIDMajorMinorRevision} {
+		if v := d[id]; v != "" {
+			ret = append(ret, v)
+		}
+	}
+
+	return strings.Join(ret, " ")
+}
+
+// DeviceIDProvider is implemented by register providers that can respond
+// to read device identification requests. Regs implements this.
+type DeviceIDProvider interface {
+	DeviceID() DeviceID
+}
+
+// ReadDeviceID creates a PDU to read device identification objects (FC43,
+// MEI type 14) starting at objectID
+func ReadDeviceID(readCode, objectID byte) PDU {
+	return PDU{
+		FunctionCode: FuncCodeEncapsulatedInterface,
+		Data:         []byte{MEIReadDeviceID, readCode, objectID},
+	}
+}
+
+// RespReadDeviceID reads device identification objects from a response
+// PDU. If more objects follow, more is set and next is the object ID to
+// request next.
+func (p *PDU) RespReadDeviceID() (objects DeviceID, more bool, next byte, err error) {
+	if p.FunctionCode != FuncCodeEncapsulatedInterface {
+		return nil, false, 0, errors.New("invalid function code to read device ID")
+	}
+
+	if len(p.Data) < 6 || p.Data[0] != MEIReadDeviceID {
+		return nil, false, 0, errors.New("invalid read device ID response")
+	}
+
+	more = p.Data[3] == 0xff
+	next = p.Data[4]
+	count := int(p.Data[5])
+
+	objects = make(DeviceID)
+	d := p.Data[6:]
+	for i := 0; i < count; i++ {
+		if len(d) < 2 || len(d) < 2+int(d[1]) {
+			return nil, false, 0, errors.New("RespReadDeviceID not enough data")
+		}
+		objects[d[0]] = string(d[2 : 2+int(d[1])])
+		d = d[2+int(d[1]):]
+	}
+
+	return objects, more, next, nil
+}
+
+// processReadDeviceID builds the response to a read device identification
+// request
+func (p *PDU) processReadDeviceID(regs RegProvider) (PDU, error) {
+	provider, ok := regs.(DeviceIDProvider)
+	if !ok || p.Data[0] != MEIReadDeviceID {
+		return PDU{}, ExcIllegalFunction
+	}
+
+	objects := provider.DeviceID()
+	readCode := p.Data[1]
+	objectID := p.Data[2]
+
+	var ids []byte
+	conformity := byte(0x81)
+	for id := range objects {
+		ids = append(ids, id)
+		if id >= 0x80 {
+			conformity = 0x83
+		} else if id > DeviceIDMajorMinorRevision && conformity < 0x82 {
+			conformity = 0x82
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var maxID byte
+	switch readCode {
+	case ReadDeviceIDBasic:
+		maxID = DeviceIDMajorMinorRevision
+	case ReadDeviceIDRegular:
+		maxID = 0x7f
+	case ReadDeviceIDExtended:
+		maxID = 0xff
+	case ReadDeviceIDSpecific:
+		if _, ok := objects[objectID]; !ok {
+			return PDU{}, ExcIllegalAddress
+		}
+		ids = []byte{objectID}
+		maxID = objectID
+	default:
+		return PDU{}, ExcIllegalValue
+	}
+
+	resp := PDU{
+		FunctionCode: FuncCodeEncapsulatedInterface,
+		Data:         []byte{MEIReadDeviceID, readCode, conformity, 0, 0, 0},
+	}
+
+	count := 0
+	for _, id := range ids {
+		if id < objectID || id > maxID {
+			continue
+		}
+
+		v := objects[id]
+		if len(v) > maxDeviceIDData-2 {
+			v = v[:maxDeviceIDData-2]
+		}
+
+		if len(resp.Data)-6+2+len(v) > maxDeviceIDData {
+			// does not fit, client must request the rest
+			resp.Data[3] = 0xff
+			resp.Data[4] = id
+			break
+		}
+
+		resp.Data = append(resp.Data, id, byte(len(v)))
+		resp.Data = append(resp.Data, v...)
+		count++
+	}
+
+	resp.Data[5] = byte(count)
+
+	return resp, nil
+}
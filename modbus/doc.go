@@ -1,2 +1,2 @@
-// Package modbus contains modbus RTU/TCP client/server code.
+// Package modbus contains modbus RTU/ASCII/TCP client/server code.
 package modbus
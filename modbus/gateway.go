@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/test"
+)
+
+// GatewayHandler handles a request for a unit ID and returns the response.
+// An ExceptionCode error is returned to the TCP client as an exception
+// response. Any other error is returned as a gateway target failed to
+// respond exception. Requests to unit ID 0 are broadcasts: the handler
+// should not wait for a response, and nothing is returned to the TCP
+// client.
+type GatewayHandler func(id byte, req PDU) (PDU, error)
+
+// TCPGateway is a Modbus TCP server that passes all requests to a handler
+// instead of a register map. This is typically used to forward requests
+// to RTU devices by unit ID, so that TCP clients can reach serial devices.
+type TCPGateway struct {
+	// config
+	maxClients int
+	port       string
+	handler    GatewayHandler
+	debug      int
+
+	// state
+	listener net.Listener
+	conns    []net.Conn
+	lock     sync.Mutex
+	stopped  bool
+}
+
+// NewTCPGateway starts listening for Modbus TCP connections on port
+func NewTCPGateway(maxClients int, port string, handler GatewayHandler, debug int) (*TCPGateway, error) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCPGateway{
+		maxClients: maxClients,
+		port:       port,
+		handler:    handler,
+		listener:   listener,
+		debug:      debug,
+	}, nil
+}
+
+// Addr returns the address the gateway is listening on
+func (g *TCPGateway) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+// Listen accepts connections and handles requests. This function does not
+// return until the gateway is closed. The changes callback is not used,
+// and is only present so that the gateway has the same Listen signature
+// as the other servers.
+func (g *TCPGateway) Listen(errorCallback func(error), _ func(), done func()) {
+	for {
+		sock, err := g.listener.Accept()
+		if err != nil {
+			if g.isStopped() {
+				if g.debug > 0 {
+					log.Println("Modbus TCP gateway, stopping listen")
+				}
+				done()
+				return
+			}
+			log.Println("Modbus TCP gateway: failed to accept connection: ", err)
+			continue
+		}
+
+		if g.debug > 0 {
+			log.Println("New Modbus TCP gateway connection")
+		}
+
+		g.lock.Lock()
+		if len(g.conns) < g.maxClients {
+			g.conns = append(g.conns, sock)
+			go g.serve(sock, errorCallback)
+		} else {
+			log.Println("Modbus TCP gateway: warning reached max conn")
+			sock.Close()
+		}
+		g.lock.Unlock()
+	}
+}
+
+// serve handles requests from one TCP client until it disconnects
+func (g *TCPGateway) serve(sock net.Conn, errorCallback func(error)) {
+	// TCP clients may be idle for a long time, so use a long timeout and
+	// ignore timeout errors
+	transport := NewTCP(sock, time.Minute, TransportServer)
+
+	defer func() {
+		transport.Close()
+		g.lock.Lock()
+		for i := range g.conns {
+			if g.conns[i] == sock {
+				g.conns[i] = g.conns[len(g.conns)-1]
+				g.conns = g.conns[:len(g.conns)-1]
+				break
+			}
+		}
+		g.lock.Unlock()
+	}()
+
+	buf := make([]byte, maxFrameSize)
+
+	for {
+		cnt, err := transport.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			if err != io.EOF && !g.isStopped() {
+				errorCallback(err)
+			}
+
+			if g.debug > 0 {
+				log.Println("Modbus TCP gateway client disconnected")
+			}
+			return
+		}
+
+		if g.debug >= 9 {
+			fmt.Println("Modbus gateway rx: ", test.HexDump(buf[:cnt]))
+		}
+
+		id, req, err := transport.Decode(buf[:cnt])
+		if err != nil {
+			errorCallback(err)
+			continue
+		}
+
+		resp, err := g.handler(id, req)
+		if id == 0 {
+			// broadcasts are not answered
+			if err != nil {
+				errorCallback(err)
+			}
+			continue
+		}
+
+		if err != nil {
+			var exc ExceptionCode
+			if !errors.As(err, &exc) {
+				errorCallback(err)
+				exc = ExcGatewayTargetFailedToRespond
+			}
+			resp = PDU{
+				FunctionCode: req.FunctionCode | 0x80,
+				Data:         []byte{byte(exc)},
+			}
+		}
+
+		packet, err := transport.Encode(id, resp)
+		if err != nil {
+			errorCallback(err)
+			continue
+		}
+
+		if g.debug >= 9 {
+			fmt.Println("Modbus gateway tx: ", test.HexDump(packet))
+		}
+
+		_, err = transport.Write(packet)
+		if err != nil {
+			errorCallback(err)
+		}
+	}
+}
+
+func (g *TCPGateway) isStopped() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.stopped
+}
+
+// Close stops the gateway and closes all connections
+func (g *TCPGateway) Close() error {
+	if g.debug > 0 {
+		log.Println("Modbus TCP gateway closing ...")
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.stopped = true
+
+	for _, c := range g.conns {
+		c.Close()
+	}
+
+	return g.listener.Close()
+}
@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/respreader"
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestTCPGateway(t *testing.T) {
+	id := byte(2)
+
+	// RTU device
+	a, b := test.NewIoSim()
+	portA := respreader.NewReadWriteCloser(a, time.Second*2,
+		5*time.Millisecond)
+	regs := &Regs{}
+	regs.AddReg(2, 1)
+	err := regs.WriteReg(2, 0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave := NewServer(id, NewRTU(portA), regs, 0)
+	go slave.Listen(func(err error) {
+		log.Println("modbus server listen error: ", err)
+	}, func() {}, func() {})
+
+	// gateway forwards TCP requests to the RTU bus
+	portB := respreader.NewReadWriteCloser(b, time.Millisecond*200,
+		5*time.Millisecond)
+	rtuClient := NewClient(NewRTU(portB), 0)
+
+	gw, err := NewTCPGateway(2, "0", rtuClient.Request, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	go gw.Listen(func(err error) {
+		log.Println("modbus gateway error: ", err)
+	}, func() {}, func() {})
+
+	// TCP client
+	sock, err := net.Dial("tcp", gw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(NewTCP(sock, time.Second, TransportClient), 0)
+	defer client.Close()
+
+	hr, err := client.ReadHoldingRegs(id, 2, 1)
+	if err != nil {
+		t.Fatal("read holding regs returned err: ", err)
+	}
+
+	if len(hr) != 1 || hr[0] != 0x1234 {
+		t.Fatalf("wrong value: %x", hr)
+	}
+
+	err = client.WriteSingleReg(id, 2, 0x55)
+	if err != nil {
+		t.Fatal("write reg returned err: ", err)
+	}
+
+	v, _ := regs.ReadReg(2)
+	if v != 0x55 {
+		t.Fatalf("write was not forwarded: %x", v)
+	}
+
+	// exceptions from the device are returned to the TCP client
+	_, err = client.ReadHoldingRegs(id, 10, 1)
+	if err != ExcIllegalAddress {
+		t.Fatal("expected illegal address exception, got: ", err)
+	}
+
+	// no device with ID 3
+	_, err = client.ReadHoldingRegs(3, 2, 1)
+	if err != ExcGatewayTargetFailedToRespond {
+		t.Fatal("expected gateway target exception, got: ", err)
+	}
+}
+
+func TestTCPGatewayBroadcast(t *testing.T) {
+	reqs := make(chan byte, 10)
+
+	gw, err := NewTCPGateway(2, "0", func(id byte, req PDU) (PDU, error) {
+		reqs <- id
+		if id == 0 {
+			return PDU{}, nil
+		}
+		return PDU{FunctionCode: req.FunctionCode, Data: req.Data}, nil
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	go gw.Listen(func(err error) {
+		log.Println("modbus gateway error: ", err)
+	}, func() {}, func() {})
+
+	sock, err := net.Dial("tcp", gw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(NewTCP(sock, time.Second, TransportClient), 0)
+	defer client.Close()
+
+	err = client.Broadcast(WriteSingleReg(2, 0x55))
+	if err != nil {
+		t.Fatal("broadcast returned err: ", err)
+	}
+
+	select {
+	case id := <-reqs:
+		if id != 0 {
+			t.Fatal("wrong id: ", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast was not forwarded")
+	}
+
+	// the broadcast is not answered, so the next response is for the
+	// next request
+	err = client.WriteSingleReg(2, 2, 0x56)
+	if err != nil {
+		t.Fatal("write reg returned err: ", err)
+	}
+}
@@ -21,6 +21,9 @@ const (
 	FuncCodeReadWriteMultipleRegisters FunctionCode = 23
 	FuncCodeMaskWriteRegister          FunctionCode = 22
 	FuncCodeReadFIFOQueue              FunctionCode = 24
+
+	// Encapsulated interface transport, used to read device identification
+	FuncCodeEncapsulatedInterface FunctionCode = 43
 )
 
 // ExceptionCode represents a modbus exception code
@@ -45,6 +48,11 @@ const (
 	WriteCoilValueOff uint16 = 0
 )
 
+// maxFrameSize is the max size of a packet returned by a transport Read.
+// This is the size of the largest ASCII frame, which is hex encoded, so is
+// larger than the max RTU (256) or TCP (260) ADU.
+const maxFrameSize = asciiMaxSize
+
 // minRequestLen is the minimum number of PDU bytes for a request with
 // the given function code (not including slave address or checksum,
 // which are part of the ADU).
@@ -60,6 +68,7 @@ var minRequestLen = map[FunctionCode]int{
 	FuncCodeReadWriteMultipleRegisters: 12,
 	FuncCodeMaskWriteRegister:          7,
 	FuncCodeReadFIFOQueue:              3,
+	FuncCodeEncapsulatedInterface:      4,
 }
 
 func (e ExceptionCode) Error() string {
@@ -50,18 +50,18 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
 		address := binary.BigEndian.Uint16(p.Data[:2])
 		count := binary.BigEndian.Uint16(p.Data[2:4])
+		var read = regs.ReadCoils
+		if p.FunctionCode == FuncCodeReadDiscreteInputs {
+			read = regs.ReadDiscreteInputs
+		}
+		values, err := read(int(address), int(count))
+		if err != nil {
+			return p.handleError(err)
+		}
 		bytes := byte((count + 7) / 8)
 		resp.Data = make([]byte, 1+bytes)
 		resp.Data[0] = bytes
-		var read = regs.ReadCoil
-		if p.FunctionCode == FuncCodeReadDiscreteInputs {
-			read = regs.ReadDiscreteInput
-		}
-		for i := 0; i < int(count); i++ {
-			v, err := read(int(address) + i)
-			if err != nil {
-				return p.handleError(err)
-			}
+		for i, v := range values {
 			if v {
 				resp.Data[1+i/8] |= 1 << (i % 8)
 			}
@@ -70,20 +70,19 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 		address := binary.BigEndian.Uint16(p.Data[:2])
 		count := binary.BigEndian.Uint16(p.Data[2:4])
 
-		resp.Data = make([]byte, 1+2*count)
-		resp.Data[0] = uint8(count * 2)
-		var read = regs.ReadReg
+		var read = regs.ReadRegs
 		if p.FunctionCode == FuncCodeReadInputRegisters {
-			read = regs.ReadInputReg
+			read = regs.ReadInputRegs
+		}
+		values, err := read(int(address), int(count))
+		if err != nil {
+			return p.handleError(err)
 		}
-		for i := 0; i < int(count); i++ {
-			v, err := read(int(address) + i)
-			if err != nil {
-				return p.handleError(err)
-			}
 
+		resp.Data = make([]byte, 1+2*count)
+		resp.Data[0] = uint8(count * 2)
+		for i, v := range values {
 			binary.BigEndian.PutUint16(resp.Data[1+i*2:], v)
-
 		}
 
 	case FuncCodeWriteSingleCoil:
@@ -99,7 +98,7 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 			return p.handleError(ExcIllegalValue)
 		}
 
-		err := regs.WriteCoil(int(address), vBool)
+		err := regs.ClientWriteCoils(int(address), []bool{vBool})
 		if err != nil {
 			return p.handleError(err)
 		}
@@ -113,11 +112,12 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 		if len(p.Data) != 5+((int(quantity)+7)/8) {
 			return p.handleError(ExcIllegalValue)
 		}
-		for i := 0; i < int(quantity); i++ {
-			value := (p.Data[5+i/8]>>(i%8))&1 == 1
-			if err := regs.WriteCoil(int(address)+i, value); err != nil {
-				return p.handleError(err)
-			}
+		values := make([]bool, quantity)
+		for i := range values {
+			values[i] = (p.Data[5+i/8]>>(i%8))&1 == 1
+		}
+		if err := regs.ClientWriteCoils(int(address), values); err != nil {
+			return p.handleError(err)
 		}
 		resp.Data = make([]byte, 4)
 		binary.BigEndian.PutUint16(resp.Data[:2], address)
@@ -128,7 +128,7 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 		address := binary.BigEndian.Uint16(p.Data[:2])
 		v := binary.BigEndian.Uint16(p.Data[2:4])
 
-		err := regs.WriteReg(int(address), v)
+		err := regs.ClientWriteRegs(int(address), []uint16{v})
 		if err != nil {
 			return p.handleError(err)
 		}
@@ -142,17 +142,77 @@ func (p *PDU) ProcessRequest(regs RegProvider) (bool, PDU, error) {
 		if len(p.Data) != 5+(int(quantity)*2) {
 			return p.handleError(ExcIllegalValue)
 		}
-		for i := 0; i < int(quantity); i++ {
-			value := binary.BigEndian.Uint16(p.Data[5+i*2 : 5+i*2+2])
-			if err := regs.WriteReg(int(address)+i, value); err != nil {
-				return p.handleError(err)
-			}
+		values := make([]uint16, quantity)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(p.Data[5+i*2 : 5+i*2+2])
+		}
+		if err := regs.ClientWriteRegs(int(address), values); err != nil {
+			return p.handleError(err)
 		}
 		resp.Data = make([]byte, 4)
 		binary.BigEndian.PutUint16(resp.Data[:2], address)
 		binary.BigEndian.PutUint16(resp.Data[2:4], quantity)
 		regsChanged = true
 
+	case FuncCodeMaskWriteRegister:
+		address := binary.BigEndian.Uint16(p.Data[:2])
+		andMask := binary.BigEndian.Uint16(p.Data[2:4])
+		orMask := binary.BigEndian.Uint16(p.Data[4:6])
+
+		v, err := regs.ReadRegs(int(address), 1)
+		if err != nil {
+			return p.handleError(err)
+		}
+
+		v[0] = (v[0] & andMask) | (orMask & ^andMask)
+
+		err = regs.ClientWriteRegs(int(address), v)
+		if err != nil {
+			return p.handleError(err)
+		}
+
+		resp = *p
+		regsChanged = true
+
+	case FuncCodeReadWriteMultipleRegisters:
+		readAddress := binary.BigEndian.Uint16(p.Data[:2])
+		readCount := binary.BigEndian.Uint16(p.Data[2:4])
+		writeAddress := binary.BigEndian.Uint16(p.Data[4:6])
+		writeCount := binary.BigEndian.Uint16(p.Data[6:8])
+		if len(p.Data) != 9+(int(writeCount)*2) || readCount > 125 {
+			return p.handleError(ExcIllegalValue)
+		}
+
+		// the write is done before the read
+		if writeCount > 0 {
+			values := make([]uint16, writeCount)
+			for i := range values {
+				values[i] = binary.BigEndian.Uint16(p.Data[9+i*2 : 9+i*2+2])
+			}
+			if err := regs.ClientWriteRegs(int(writeAddress), values); err != nil {
+				return p.handleError(err)
+			}
+			regsChanged = true
+		}
+
+		values, err := regs.ReadRegs(int(readAddress), int(readCount))
+		if err != nil {
+			return p.handleError(err)
+		}
+
+		resp.Data = make([]byte, 1+2*readCount)
+		resp.Data[0] = uint8(readCount * 2)
+		for i, v := range values {
+			binary.BigEndian.PutUint16(resp.Data[1+i*2:], v)
+		}
+
+	case FuncCodeEncapsulatedInterface:
+		var err error
+		resp, err = p.processReadDeviceID(regs)
+		if err != nil {
+			return p.handleError(err)
+		}
+
 	default:
 		return p.handleError(ExcIllegalFunction)
 	}
@@ -166,6 +226,17 @@ func (p *PDU) RespReadBits() ([]bool, error) {
 	if len(p.Data) < 2 {
 		return []bool{}, errors.New("not enough data")
 	}
+
+	return p.respReadBits(int(p.Data[0]))
+}
+
+// respReadBits reads count coils or discrete inputs from a response PDU.
+// The response only contains the byte count, so the number of bits
+// requested must be provided.
+func (p *PDU) respReadBits(count int) ([]bool, error) {
+	if len(p.Data) < 2 {
+		return []bool{}, errors.New("not enough data")
+	}
 	switch p.FunctionCode {
 	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
 		// ok
@@ -173,18 +244,14 @@ func (p *PDU) RespReadBits() ([]bool, error) {
 		return []bool{}, errors.New("invalid function code to read bits")
 	}
 
-	count := p.Data[0]
+	if len(p.Data) < 1+(count+7)/8 {
+		return []bool{}, errors.New("RespReadBits not enough data")
+	}
+
 	ret := make([]bool, count)
-	byteIndex := 0
-	bitIndex := uint(0)
-
-	for i := byte(0); i < count; i++ {
-		ret[i] = ((p.Data[byteIndex+1] >> bitIndex) & 0x1) == 0x1
-		bitIndex++
-		if bitIndex >= 8 {
-			byteIndex++
-			bitIndex = 0
-		}
+
+	for i := 0; i < count; i++ {
+		ret[i] = ((p.Data[1+i/8] >> (i % 8)) & 0x1) == 0x1
 	}
 
 	return ret, nil
@@ -197,7 +264,8 @@ func (p *PDU) RespReadRegs() ([]uint16, error) {
 		return []uint16{}, errors.New("not enough data")
 	}
 	switch p.FunctionCode {
-	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
 		// ok
 	default:
 		return []uint16{}, errors.New("invalid function code to read regs")
@@ -258,6 +326,62 @@ func WriteSingleReg(address, value uint16) PDU {
 	}
 }
 
+// WriteMultipleCoils creates PDU to write consecutive coils
+func WriteMultipleCoils(address uint16, values []bool) PDU {
+	count := len(values)
+	bytes := (count + 7) / 8
+	data := make([]byte, 5+bytes)
+	binary.BigEndian.PutUint16(data[0:], address)
+	binary.BigEndian.PutUint16(data[2:], uint16(count))
+	data[4] = byte(bytes)
+	for i, v := range values {
+		if v {
+			data[5+i/8] |= 1 << (i % 8)
+		}
+	}
+
+	return PDU{
+		FunctionCode: FuncCodeWriteMultipleCoils,
+		Data:         data,
+	}
+}
+
+// WriteMultipleRegs creates PDU to write consecutive holding regs
+func WriteMultipleRegs(address uint16, values []uint16) PDU {
+	data := PutUint16Array(address, uint16(len(values)))
+	data = append(data, byte(len(values)*2))
+	data = append(data, PutUint16Array(values...)...)
+
+	return PDU{
+		FunctionCode: FuncCodeWriteMultipleRegisters,
+		Data:         data,
+	}
+}
+
+// ReadWriteMultipleRegs creates PDU to write holding regs and then read
+// holding regs in one transaction
+func ReadWriteMultipleRegs(readAddress, readCount, writeAddress uint16,
+	values []uint16) PDU {
+	data := PutUint16Array(readAddress, readCount, writeAddress,
+		uint16(len(values)))
+	data = append(data, byte(len(values)*2))
+	data = append(data, PutUint16Array(values...)...)
+
+	return PDU{
+		FunctionCode: FuncCodeReadWriteMultipleRegisters,
+		Data:         data,
+	}
+}
+
+// MaskWriteReg creates PDU to modify bits in a holding reg. The reg is set
+// to (current AND andMask) OR (orMask AND NOT andMask).
+func MaskWriteReg(address, andMask, orMask uint16) PDU {
+	return PDU{
+		FunctionCode: FuncCodeMaskWriteRegister,
+		Data:         PutUint16Array(address, andMask, orMask),
+	}
+}
+
 // ReadHoldingRegs creates a PDU to read a holding regs
 func ReadHoldingRegs(address uint16, count uint16) PDU {
 	return PDU{
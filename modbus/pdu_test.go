@@ -8,7 +8,7 @@ import (
 
 func TestPduReadCoils(t *testing.T) {
 	regs := Regs{}
-	regs.AddCoil(128) // add register 8 for coil 128
+	regs.AddCoil(128)
 	_ = regs.WriteCoil(128, true)
 
 	pdu := ReadCoils(128, 1)
@@ -36,7 +36,7 @@ func TestPduReadCoils(t *testing.T) {
 
 func TestPduWriteSingleCoil(t *testing.T) {
 	regs := Regs{}
-	regs.AddCoil(128) // add register 8 for coil 128
+	regs.AddCoil(128)
 	_ = regs.WriteCoil(128, true)
 
 	pdu := WriteSingleCoil(128, false)
@@ -62,7 +62,7 @@ func TestPduWriteSingleCoil(t *testing.T) {
 
 func TestPduWriteSingleCoilError(t *testing.T) {
 	regs := Regs{}
-	regs.AddCoil(128) // add register 8 for coil 128
+	regs.AddCoil(128)
 	_ = regs.WriteCoil(128, true)
 
 	pdu := WriteSingleCoil(64, false)
@@ -109,12 +109,21 @@ func TestPduReadHoldingRegs(t *testing.T) {
 }
 
 func TestProcessRequest(t *testing.T) {
+	// coils and discrete inputs 128-159 and registers 8-9 are set up with
+	// the same values, so the bit and register reads match
 	regs := Regs{}
-	regs.AddCoil(128) // add register 8 for coil 128
-	_ = regs.WriteCoil(128, true)
-	_ = regs.WriteCoil(130, true)
-	_ = regs.WriteCoil(132, true)
-	regs.AddCoil(144) // add register 9 for coil 144
+	for i := 128; i < 160; i++ {
+		regs.AddCoil(i)
+		regs.AddDiscreteInput(i)
+	}
+	for _, i := range []int{128, 130, 132} {
+		_ = regs.WriteCoil(i, true)
+		_ = regs.WriteDiscreteInput(i, true)
+	}
+	regs.AddReg(8, 2)
+	_ = regs.WriteReg(8, 0x15)
+	regs.AddInputReg(8, 2)
+	_ = regs.WriteInputReg(8, 0x15)
 
 	for _, test := range []struct {
 		name string
@@ -149,6 +158,12 @@ func TestProcessRequest(t *testing.T) {
 		{"WriteMultipleRegisters/missing", []byte{0x10, 0, 7, 0, 2, 4, 9, 10, 11, 12}, []byte{0x90, 2}},
 		{"WriteMultipleRegisters/wronglen", []byte{0x10, 0, 7, 0, 2, 4, 9, 10}, []byte{0x90, 3}},
 		{"WriteMultipleRegisters/readback", []byte{3, 0, 8, 0, 2}, []byte{3, 4, 0, 8, 10, 15}},
+		{"MaskWriteRegister/present", []byte{22, 0, 8, 0, 0xF2, 0, 0x25}, []byte{22, 0, 8, 0, 0xF2, 0, 0x25}},
+		{"MaskWriteRegister/missing", []byte{22, 0, 7, 0, 0xF2, 0, 0x25}, []byte{0x96, 2}},
+		{"MaskWriteRegister/readback", []byte{3, 0, 8, 0, 1}, []byte{3, 2, 0, 5}},
+		{"ReadWriteMultipleRegisters/one", []byte{23, 0, 8, 0, 2, 0, 9, 0, 1, 2, 0x12, 0x34}, []byte{23, 4, 0, 5, 0x12, 0x34}},
+		{"ReadWriteMultipleRegisters/missing", []byte{23, 0, 8, 0, 1, 0, 7, 0, 1, 2, 0, 1}, []byte{0x97, 2}},
+		{"ReadWriteMultipleRegisters/wronglen", []byte{23, 0, 8, 0, 1, 0, 9, 0, 2, 2, 0, 1}, []byte{0x97, 3}},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			pdu := &PDU{
@@ -173,3 +188,31 @@ func TestProcessRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestPduBuilders(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		pdu  PDU
+		out  []byte
+	}{
+		{"WriteMultipleCoils", WriteMultipleCoils(0x13, []bool{true, false, true, true, false, false, true, true, true, false}),
+			[]byte{15, 0, 0x13, 0, 10, 2, 0xCD, 0x01}},
+		{"WriteMultipleRegs", WriteMultipleRegs(1, []uint16{0x000A, 0x0102}),
+			[]byte{16, 0, 1, 0, 2, 4, 0, 0x0A, 1, 2}},
+		{"ReadWriteMultipleRegs", ReadWriteMultipleRegs(3, 6, 0x0E, []uint16{0x00FF, 0x00FF, 0x00FF}),
+			[]byte{23, 0, 3, 0, 6, 0, 0x0E, 0, 3, 6, 0, 0xFF, 0, 0xFF, 0, 0xFF}},
+		{"MaskWriteReg", MaskWriteReg(4, 0xF2, 0x25),
+			[]byte{22, 0, 4, 0, 0xF2, 0, 0x25}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			want := PDU{
+				FunctionCode: FunctionCode(test.out[0]),
+				Data:         test.out[1:],
+			}
+
+			if diff := cmp.Diff(test.pdu, want); diff != "" {
+				t.Errorf("unexpected PDU: got(-), want(+):\n%s", diff)
+			}
+		})
+	}
+}
@@ -4,232 +4,435 @@ import (
 	"sync"
 )
 
-// Reg defines a Modbus register
-type Reg struct {
-	Address uint16
-	Value   uint16
+// Table identifies one of the four Modbus data tables
+type Table int
+
+// Defined data tables
+const (
+	TableCoils Table = iota + 1
+	TableDiscreteInputs
+	TableInputRegs
+	TableHoldingRegs
+)
+
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscreteInputs:
+		return "discrete inputs"
+	case TableInputRegs:
+		return "input registers"
+	case TableHoldingRegs:
+		return "holding registers"
+	}
+	return "unknown table"
+}
+
+// RegChange describes a range of coils or holding registers written by a
+// client request
+type RegChange struct {
+	Table   Table
+	Address int
+	Count   int
+}
+
+// Overlaps returns true if any of count coils or registers starting at
+// address were changed
+func (c RegChange) Overlaps(address, count int) bool {
+	return address < c.Address+c.Count && c.Address < address+count
 }
 
-// RegProvider is the interface for a register provider.
-// Regs is the canonical implementation.
+// RegProvider is the interface used to process client (master) requests.
+// Reads and writes operate on a range, and a range is only written if all
+// of the addresses in it exist and are writable. Regs is the canonical
+// implementation.
 type RegProvider interface {
-	ReadReg(address int) (uint16, error)
-	WriteReg(address int, value uint16) error
-	ReadInputReg(address int) (uint16, error)
-	ReadDiscreteInput(num int) (bool, error)
-	ReadCoil(num int) (bool, error)
-	WriteCoil(num int, value bool) error
+	ReadCoils(num, count int) ([]bool, error)
+	ReadDiscreteInputs(num, count int) ([]bool, error)
+	ReadInputRegs(address, count int) ([]uint16, error)
+	ReadRegs(address, count int) ([]uint16, error)
+	ClientWriteCoils(num int, values []bool) error
+	ClientWriteRegs(address int, values []uint16) error
 }
 
-// Regs represents all registers in a modbus device and provides functions
-// to read/write 16-bit and bit values. This register module assumes all
-// register types map into one address space
-// as described in the modbus spec
-// (http://www.modbus.org/docs/Modbus_Application_Protocol_V1_1b3.pdf)
-// on page 6 and 7.
-// All operations on Regs are threadsafe and protected by a mutex.
-type Regs struct {
-	regs []Reg
-	lock sync.RWMutex
+// table stores the values of one data table. Bits are stored as 0 or 1.
+type table struct {
+	values   map[uint16]uint16
+	readOnly map[uint16]bool
 }
 
-// AddReg is used to add a modbus register to the server.
-// the callback function is called when the reg is updated
-// The register can be updated by word or bit operations.
-func (r *Regs) AddReg(address int, count int) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	// first check if reg already exists
+func (t *table) add(address, count int) {
+	if t.values == nil {
+		t.values = make(map[uint16]uint16)
+		t.readOnly = make(map[uint16]bool)
+	}
+
 	for i := 0; i < count; i++ {
-		found := false
-		adr := address + i
-		for _, reg := range r.regs {
-			if reg.Address == uint16(adr) {
-				found = true
-			}
-		}
-		if !found {
-			r.regs = append(r.regs, Reg{uint16(adr), 0})
+		adr := uint16(address + i)
+		if _, ok := t.values[adr]; !ok {
+			t.values[adr] = 0
 		}
 	}
 }
 
-func (r *Regs) readReg(address int) (uint16, error) {
-	for _, reg := range r.regs {
-		if reg.Address == uint16(address) {
-			return reg.Value, nil
+// check returns ExcIllegalAddress if any address in the range does not
+// exist, or is read-only and writable is set
+func (t *table) check(address, count int, writable bool) error {
+	if address < 0 || count < 0 || address+count > 0x10000 {
+		return ExcIllegalAddress
+	}
+
+	for i := 0; i < count; i++ {
+		adr := uint16(address + i)
+		if _, ok := t.values[adr]; !ok {
+			return ExcIllegalAddress
+		}
+		if writable && t.readOnly[adr] {
+			return ExcIllegalAddress
 		}
 	}
 
-	return 0, ExcIllegalAddress
+	return nil
 }
 
-// ReadReg is used to read a modbus holding register
-func (r *Regs) ReadReg(address int) (uint16, error) {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
-	v, err := r.readReg(address)
-	return v, err
+func (t *table) read(address, count int) ([]uint16, error) {
+	if err := t.check(address, count, false); err != nil {
+		return nil, err
+	}
+
+	ret := make([]uint16, count)
+	for i := range ret {
+		ret[i] = t.values[uint16(address+i)]
+	}
+
+	return ret, nil
 }
 
-// ReadInputReg is used to read a modbus input register
-func (r *Regs) ReadInputReg(address int) (uint16, error) {
-	return r.ReadReg(address)
+func (t *table) write(address int, values []uint16, checkReadOnly bool) error {
+	if err := t.check(address, len(values), checkReadOnly); err != nil {
+		return err
+	}
+
+	for i, v := range values {
+		t.values[uint16(address+i)] = v
+	}
+
+	return nil
+}
+
+func (t *table) setReadOnly(address, count int, readOnly bool) error {
+	if err := t.check(address, count, false); err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		t.readOnly[uint16(address+i)] = readOnly
+	}
+
+	return nil
 }
 
-func (r *Regs) writeReg(address int, value uint16) error {
-	for i, reg := range r.regs {
-		if reg.Address == uint16(address) {
-			(r.regs)[i].Value = value
-			return nil
+func bitsToValues(bits []bool) []uint16 {
+	ret := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			ret[i] = 1
 		}
 	}
+	return ret
+}
 
-	return ExcIllegalAddress
+func valuesToBits(values []uint16) []bool {
+	ret := make([]bool, len(values))
+	for i, v := range values {
+		ret[i] = v != 0
+	}
+	return ret
 }
 
-// WriteReg is used to write a modbus register
-func (r *Regs) WriteReg(address int, value uint16) error {
+// Regs represents all registers in a modbus device and provides functions
+// to read/write 16-bit and bit values. Coils, discrete inputs, input
+// registers, and holding registers are separate tables as described in the
+// modbus spec
+// (http://www.modbus.org/docs/Modbus_Application_Protocol_V1_1b3.pdf)
+// on page 6 and 7. Addresses must be added before they can be accessed.
+//
+// Coils and holding registers can be marked read-only, in which case
+// client writes return ExcIllegalAddress. The application can always write
+// values with the non-client functions (WriteCoil, WriteReg, etc), and the
+// write callback is only called for client writes.
+//
+// All operations on Regs are threadsafe and protected by a mutex.
+type Regs struct {
+	coils          table
+	discreteInputs table
+	inputRegs      table
+	holdingRegs    table
+	deviceID       DeviceID
+	writeCallback  func(RegChange)
+	lock           sync.RWMutex
+}
+
+// SetWriteCallback sets a function that is called after a client request
+// writes coils or holding registers. The callback is called from the server
+// goroutine, after the write is complete.
+func (r *Regs) SetWriteCallback(callback func(RegChange)) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	return r.writeReg(address, value)
+	r.writeCallback = callback
 }
 
-// AddCoil is used to add a discrete io to the register map.
-// Note coils are aliased on top of other registers, so coil 20
-// would be register 1 bit 4 (16 + 4 = 20).
-func (r *Regs) AddCoil(num int) {
-	regAddress := num / 16
-	r.AddReg(regAddress, 1)
-}
+func (r *Regs) changed(change RegChange) {
+	r.lock.RLock()
+	callback := r.writeCallback
+	r.lock.RUnlock()
 
-// ReadCoil gets a coil value
-func (r *Regs) ReadCoil(num int) (bool, error) {
-	regAddress := (num / 16)
-	regValue, err := r.ReadReg(regAddress)
-	if err != nil {
-		return false, err
+	if callback != nil {
+		callback(change)
 	}
+}
 
-	bitPos := uint16(num % 16)
-	ret := (regValue & (1 << bitPos)) != 0
-	return ret, nil
+// SetDeviceID sets the objects returned for read device identification
+// requests (FC43)
+func (r *Regs) SetDeviceID(objects DeviceID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.deviceID = make(DeviceID, len(objects))
+	for k, v := range objects {
+		r.deviceID[k] = v
+	}
 }
 
-// ReadDiscreteInput gets a discrete input
-func (r *Regs) ReadDiscreteInput(num int) (bool, error) {
-	return r.ReadCoil(num)
+// DeviceID returns the device identification objects
+func (r *Regs) DeviceID() DeviceID {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ret := make(DeviceID, len(r.deviceID))
+	for k, v := range r.deviceID {
+		ret[k] = v
+	}
+	return ret
 }
 
-// WriteCoil writes a coil value
-func (r *Regs) WriteCoil(num int, value bool) error {
+// AddReg is used to add count holding registers starting at address. Adding
+// a register that already exists does not change its value.
+func (r *Regs) AddReg(address int, count int) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	r.holdingRegs.add(address, count)
+}
 
-	regAddress := (num / 16)
-	regValue, err := r.readReg(regAddress)
-	if err != nil {
-		return err
-	}
-
-	bitPos := uint16(num % 16)
+// AddInputReg is used to add count input registers starting at address
+func (r *Regs) AddInputReg(address int, count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.inputRegs.add(address, count)
+}
 
-	if value {
-		regValue |= 1 << bitPos
-	} else {
-		regValue &= ^(1 << bitPos)
-	}
+// AddCoil is used to add a coil
+func (r *Regs) AddCoil(num int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.coils.add(num, 1)
+}
 
-	return r.writeReg(regAddress, regValue)
+// AddDiscreteInput is used to add a discrete input
+func (r *Regs) AddDiscreteInput(num int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.discreteInputs.add(num, 1)
 }
 
-// ReadRegUint32 reads a uint32 from regs
-func (r *Regs) ReadRegUint32(address int) (uint32, error) {
+// SetRegsReadOnly sets or clears read-only for count holding registers
+// starting at address. The registers must already be added.
+func (r *Regs) SetRegsReadOnly(address, count int, readOnly bool) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return r.holdingRegs.setReadOnly(address, count, readOnly)
+}
 
-	regs := make([]uint16, 2)
+// SetCoilsReadOnly sets or clears read-only for count coils starting at
+// num. The coils must already be added.
+func (r *Regs) SetCoilsReadOnly(num, count int, readOnly bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.coils.setReadOnly(num, count, readOnly)
+}
 
-	var err error
-	regs[0], err = r.readReg(address)
+// ReadReg is used to read a modbus holding register
+func (r *Regs) ReadReg(address int) (uint16, error) {
+	v, err := r.ReadRegs(address, 1)
 	if err != nil {
 		return 0, err
 	}
-	regs[1], err = r.readReg(address + 1)
+	return v[0], nil
+}
+
+// WriteReg is used to write a modbus holding register
+func (r *Regs) WriteReg(address int, value uint16) error {
+	return r.WriteRegs(address, []uint16{value})
+}
+
+// ReadInputReg is used to read a modbus input register
+func (r *Regs) ReadInputReg(address int) (uint16, error) {
+	v, err := r.ReadInputRegs(address, 1)
 	if err != nil {
 		return 0, err
 	}
+	return v[0], nil
+}
 
-	return RegsToUint32(regs)[0], nil
+// WriteInputReg is used to write a modbus input register
+func (r *Regs) WriteInputReg(address int, value uint16) error {
+	return r.WriteInputRegs(address, []uint16{value})
 }
 
-// WriteRegUint32 writes a uint32 to regs
-func (r *Regs) WriteRegUint32(address int, value uint32) error {
+// ReadCoil gets a coil value
+func (r *Regs) ReadCoil(num int) (bool, error) {
+	v, err := r.ReadCoils(num, 1)
+	if err != nil {
+		return false, err
+	}
+	return v[0], nil
+}
+
+// WriteCoil writes a coil value
+func (r *Regs) WriteCoil(num int, value bool) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return r.coils.write(num, bitsToValues([]bool{value}), false)
+}
 
-	regs := Uint32ToRegs([]uint32{value})
-
-	for i, reg := range regs {
-		err := r.writeReg(address+i, reg)
-		if err != nil {
-			return err
-		}
+// ReadDiscreteInput gets a discrete input
+func (r *Regs) ReadDiscreteInput(num int) (bool, error) {
+	v, err := r.ReadDiscreteInputs(num, 1)
+	if err != nil {
+		return false, err
 	}
-
-	return nil
+	return v[0], nil
 }
 
-// ReadRegInt32 reads a int32 from regs
-func (r *Regs) ReadRegInt32(address int) (int32, error) {
+// WriteDiscreteInput writes a discrete input
+func (r *Regs) WriteDiscreteInput(num int, value bool) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return r.discreteInputs.write(num, bitsToValues([]bool{value}), false)
+}
 
-	regs := make([]uint16, 2)
-
-	var err error
-	regs[0], err = r.readReg(address)
+// ReadCoils reads count consecutive coils
+func (r *Regs) ReadCoils(num, count int) ([]bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	v, err := r.coils.read(num, count)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	regs[1], err = r.readReg(address + 1)
+	return valuesToBits(v), nil
+}
+
+// ReadDiscreteInputs reads count consecutive discrete inputs
+func (r *Regs) ReadDiscreteInputs(num, count int) ([]bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	v, err := r.discreteInputs.read(num, count)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return valuesToBits(v), nil
+}
 
-	return RegsToInt32(regs)[0], nil
+// ReadRegs reads count consecutive holding registers
+func (r *Regs) ReadRegs(address, count int) ([]uint16, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.holdingRegs.read(address, count)
 }
 
-// WriteRegInt32 writes a int32 to regs
-func (r *Regs) WriteRegInt32(address int, value int32) error {
+// WriteRegs writes consecutive holding registers starting at address
+func (r *Regs) WriteRegs(address int, values []uint16) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return r.holdingRegs.write(address, values, false)
+}
+
+// ReadInputRegs reads count consecutive input registers
+func (r *Regs) ReadInputRegs(address, count int) ([]uint16, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.inputRegs.read(address, count)
+}
 
-	regs := Int32ToRegs([]int32{value})
+// WriteInputRegs writes consecutive input registers starting at address
+func (r *Regs) WriteInputRegs(address int, values []uint16) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.inputRegs.write(address, values, false)
+}
 
-	for i, reg := range regs {
-		err := r.writeReg(address+i, reg)
-		if err != nil {
-			return err
-		}
+// ClientWriteCoils writes coils for a client request. ExcIllegalAddress is
+// returned if any of the coils do not exist or are read-only, in which case
+// no coils are written.
+func (r *Regs) ClientWriteCoils(num int, values []bool) error {
+	r.lock.Lock()
+	err := r.coils.write(num, bitsToValues(values), true)
+	r.lock.Unlock()
+	if err != nil {
+		return err
 	}
 
+	r.changed(RegChange{Table: TableCoils, Address: num, Count: len(values)})
 	return nil
 }
 
-// ReadRegFloat32 reads a float32 from regs
-func (r *Regs) ReadRegFloat32(address int) (float32, error) {
+// ClientWriteRegs writes holding registers for a client request.
+// ExcIllegalAddress is returned if any of the registers do not exist or are
+// read-only, in which case no registers are written.
+func (r *Regs) ClientWriteRegs(address int, values []uint16) error {
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	err := r.holdingRegs.write(address, values, true)
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
 
-	regs := make([]uint16, 2)
+	r.changed(RegChange{Table: TableHoldingRegs, Address: address, Count: len(values)})
+	return nil
+}
 
-	var err error
-	regs[0], err = r.readReg(address)
+// ReadRegUint32 reads a uint32 from holding regs
+func (r *Regs) ReadRegUint32(address int) (uint32, error) {
+	regs, err := r.ReadRegs(address, 2)
 	if err != nil {
 		return 0, err
 	}
-	regs[1], err = r.readReg(address + 1)
+
+	return RegsToUint32(regs)[0], nil
+}
+
+// WriteRegUint32 writes a uint32 to holding regs
+func (r *Regs) WriteRegUint32(address int, value uint32) error {
+	return r.WriteRegs(address, Uint32ToRegs([]uint32{value}))
+}
+
+// ReadRegInt32 reads a int32 from holding regs
+func (r *Regs) ReadRegInt32(address int) (int32, error) {
+	regs, err := r.ReadRegs(address, 2)
+	if err != nil {
+		return 0, err
+	}
+
+	return RegsToInt32(regs)[0], nil
+}
+
+// WriteRegInt32 writes a int32 to holding regs
+func (r *Regs) WriteRegInt32(address int, value int32) error {
+	return r.WriteRegs(address, Int32ToRegs([]int32{value}))
+}
+
+// ReadRegFloat32 reads a float32 from holding regs
+func (r *Regs) ReadRegFloat32(address int) (float32, error) {
+	regs, err := r.ReadRegs(address, 2)
 	if err != nil {
 		return 0, err
 	}
@@ -237,19 +440,36 @@ func (r *Regs) ReadRegFloat32(address int) (float32, error) {
 	return RegsToFloat32(regs)[0], nil
 }
 
-// WriteRegFloat32 writes a float32 to regs
+// WriteRegFloat32 writes a float32 to holding regs
 func (r *Regs) WriteRegFloat32(address int, value float32) error {
+	return r.WriteRegs(address, Float32ToRegs([]float32{value}))
+}
+
+// writeBit sets or clears one bit (0-15) in a register of a table
+func (r *Regs) writeBit(t *table, address, bit int, value bool) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	regs := Float32ToRegs([]float32{value})
+	regs, err := t.read(address, 1)
+	if err != nil {
+		return err
+	}
 
-	for i, reg := range regs {
-		err := r.writeReg(address+i, reg)
-		if err != nil {
-			return err
-		}
+	if value {
+		regs[0] |= 1 << uint(bit)
+	} else {
+		regs[0] &= ^(1 << uint(bit))
 	}
 
-	return nil
+	return t.write(address, regs, false)
+}
+
+// WriteRegBit sets or clears one bit (0-15) in a holding register
+func (r *Regs) WriteRegBit(address, bit int, value bool) error {
+	return r.writeBit(&r.holdingRegs, address, bit, value)
+}
+
+// WriteInputRegBit sets or clears one bit (0-15) in an input register
+func (r *Regs) WriteInputRegBit(address, bit int, value bool) error {
+	return r.writeBit(&r.inputRegs, address, bit, value)
 }
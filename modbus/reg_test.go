@@ -4,19 +4,19 @@ import "testing"
 
 func TestCoil(t *testing.T) {
 	regs := Regs{}
-	regs.AddCoil(128) // add register 8 for coil 128
+	regs.AddCoil(128)
 	err := regs.WriteCoil(128, true)
 	if err != nil {
 		t.Error("Error writing coil")
 	}
 
-	reg, err := regs.ReadReg(8)
+	v, err := regs.ReadCoil(128)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if reg&0x1 != 0x1 {
-		t.Error("expected bit 1 to be high")
+	if !v {
+		t.Error("expected coil to be high")
 	}
 
 	err = regs.WriteCoil(128, false)
@@ -24,12 +24,140 @@ func TestCoil(t *testing.T) {
 		t.Error("Error writing coil")
 	}
 
-	reg, err = regs.ReadReg(8)
+	v, err = regs.ReadCoil(128)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if reg != 0 {
-		t.Errorf("Expected reg to be 0, got 0x%x\n", reg)
+	if v {
+		t.Error("expected coil to be low")
+	}
+
+	if err := regs.WriteCoil(129, true); err != ExcIllegalAddress {
+		t.Error("expected illegal address for missing coil, got: ", err)
+	}
+}
+
+func TestRegTables(t *testing.T) {
+	regs := Regs{}
+	regs.AddCoil(8)
+	regs.AddDiscreteInput(8)
+	regs.AddInputReg(8, 1)
+	regs.AddReg(8, 1)
+
+	_ = regs.WriteCoil(8, true)
+	_ = regs.WriteInputReg(8, 2)
+	_ = regs.WriteReg(8, 3)
+
+	// the same address in each table is a different value
+	if v, _ := regs.ReadDiscreteInput(8); v {
+		t.Error("discrete input should not alias coil")
+	}
+
+	if v, _ := regs.ReadInputReg(8); v != 2 {
+		t.Error("wrong input reg value: ", v)
+	}
+
+	if v, _ := regs.ReadReg(8); v != 3 {
+		t.Error("wrong holding reg value: ", v)
+	}
+
+	if _, err := regs.ReadReg(9); err != ExcIllegalAddress {
+		t.Error("expected illegal address, got: ", err)
+	}
+}
+
+func TestRegsReadOnly(t *testing.T) {
+	regs := Regs{}
+	regs.AddReg(0, 4)
+	regs.AddCoil(0)
+
+	var changes []RegChange
+	regs.SetWriteCallback(func(c RegChange) {
+		changes = append(changes, c)
+	})
+
+	err := regs.SetRegsReadOnly(2, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = regs.SetCoilsReadOnly(0, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := regs.SetRegsReadOnly(3, 2, true); err != ExcIllegalAddress {
+		t.Error("expected illegal address for missing reg, got: ", err)
+	}
+
+	err = regs.ClientWriteRegs(0, []uint16{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the range includes a read-only reg, so nothing is written
+	err = regs.ClientWriteRegs(1, []uint16{5, 5})
+	if err != ExcIllegalAddress {
+		t.Error("expected illegal address, got: ", err)
+	}
+
+	if err := regs.ClientWriteCoils(0, []bool{true}); err != ExcIllegalAddress {
+		t.Error("expected illegal address for read-only coil, got: ", err)
+	}
+
+	v, _ := regs.ReadRegs(0, 4)
+	if v[0] != 1 || v[1] != 2 || v[2] != 0 {
+		t.Error("wrong values: ", v)
+	}
+
+	// the application can write read-only regs
+	if err := regs.WriteReg(2, 7); err != nil {
+		t.Error("application write failed: ", err)
+	}
+
+	if len(changes) != 1 {
+		t.Fatal("expected 1 change, got: ", changes)
+	}
+
+	exp := RegChange{Table: TableHoldingRegs, Address: 0, Count: 2}
+	if changes[0] != exp {
+		t.Error("wrong change: ", changes[0])
+	}
+
+	if !exp.Overlaps(1, 4) || exp.Overlaps(2, 2) {
+		t.Error("Overlaps failed")
+	}
+}
+
+func TestPduReadOnly(t *testing.T) {
+	regs := &Regs{}
+	regs.AddReg(8, 2)
+	_ = regs.SetRegsReadOnly(9, 1, true)
+
+	var change RegChange
+	regs.SetWriteCallback(func(c RegChange) {
+		change = c
+	})
+
+	req := WriteMultipleRegs(8, []uint16{1, 2})
+	changed, resp, err := req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed || resp.FunctionCode != FuncCodeWriteMultipleRegisters|0x80 ||
+		ExceptionCode(resp.Data[0]) != ExcIllegalAddress {
+		t.Error("expected illegal address exception: ", resp)
+	}
+
+	req = WriteSingleReg(8, 5)
+	changed, _, err = req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed || change.Address != 8 || change.Count != 1 {
+		t.Error("wrong change: ", change)
 	}
 }
@@ -83,3 +83,74 @@ func TestRtuEndToEnd(t *testing.T) {
 		t.Fatalf("read holding reg returned wrong value: 0x%x", hr[0])
 	}
 }
+
+func TestRtuEndToEndWrite(t *testing.T) {
+	id := byte(1)
+
+	a, b := test.NewIoSim()
+
+	portA := respreader.NewReadWriteCloser(a, time.Second*2,
+		5*time.Millisecond)
+	regs := &Regs{}
+	slave := NewServer(id, NewRTU(portA), regs, 0)
+	for i := 128; i <= 130; i++ {
+		regs.AddCoil(i)
+	}
+	regs.AddReg(2, 4)
+
+	go slave.Listen(func(err error) {
+		log.Println("modbus server listen error: ", err)
+	}, func() {}, func() {})
+
+	portB := respreader.NewReadWriteCloser(b, time.Second*2,
+		5*time.Millisecond)
+	master := NewClient(NewRTU(portB), 0)
+
+	err := master.WriteMultipleCoils(id, 128, []bool{true, false, true})
+	if err != nil {
+		t.Fatal("write multiple coils returned err: ", err)
+	}
+
+	coils, err := master.ReadCoils(id, 128, 3)
+	if err != nil {
+		t.Fatal("read coils returned err: ", err)
+	}
+
+	if len(coils) != 3 || !coils[0] || coils[1] || !coils[2] {
+		t.Fatal("wrong coil values: ", coils)
+	}
+
+	err = master.WriteMultipleRegs(id, 2, Float32ToRegs([]float32{12.5}))
+	if err != nil {
+		t.Fatal("write multiple regs returned err: ", err)
+	}
+
+	v, err := regs.ReadRegFloat32(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 12.5 {
+		t.Fatal("wrong float value: ", v)
+	}
+
+	err = master.MaskWriteReg(id, 4, 0xF2, 0x25)
+	if err != nil {
+		t.Fatal("mask write reg returned err: ", err)
+	}
+
+	hr, err := master.ReadWriteMultipleRegs(id, 4, 2, 5, []uint16{0x1234})
+	if err != nil {
+		t.Fatal("read/write multiple regs returned err: ", err)
+	}
+
+	if len(hr) != 2 || hr[0] != 0x05 || hr[1] != 0x1234 {
+		t.Fatalf("wrong read/write regs values: %x", hr)
+	}
+
+	// address 10 does not exist
+	err = master.WriteMultipleRegs(id, 10, []uint16{1})
+	if err != ExcIllegalAddress {
+		t.Fatal("expected illegal address exception, got: ", err)
+	}
+}
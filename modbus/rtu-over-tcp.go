@@ -0,0 +1,111 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// RTUOverTCP defines a RTU connection over TCP. Many serial to Ethernet
+// converters send RTU packets (address, PDU, and CRC) over TCP without
+// the Modbus TCP header.
+type RTUOverTCP struct {
+	RTU
+	sock    net.Conn
+	timeout time.Duration
+}
+
+// NewRTUOverTCP creates a new RTU over TCP transport
+func NewRTUOverTCP(sock net.Conn, timeout time.Duration) *RTUOverTCP {
+	return &RTUOverTCP{
+		RTU:     RTU{port: sock},
+		sock:    sock,
+		timeout: timeout,
+	}
+}
+
+// rtuResponseLen returns the length of the RTU response that starts with
+// buf, or 0 if more data is needed to tell. -1 is returned if the length
+// is not known from the function code.
+func rtuResponseLen(buf []byte) int {
+	if len(buf) < 2 {
+		return 0
+	}
+
+	fc := FunctionCode(buf[1])
+	if fc&0x80 != 0 {
+		// address, function code, exception code, CRC
+		return 5
+	}
+
+	switch fc {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		if len(buf) < 3 {
+			return 0
+		}
+		return 3 + int(buf[2]) + 2
+	case FuncCodeReadFIFOQueue:
+		if len(buf) < 4 {
+			return 0
+		}
+		return 4 + int(binary.BigEndian.Uint16(buf[2:])) + 2
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		return 8
+	case FuncCodeMaskWriteRegister:
+		return 10
+	default:
+		return -1
+	}
+}
+
+// Read reads one RTU packet. A packet may arrive in several TCP segments,
+// so data is read until the packet length given by the function code and
+// byte count is received, and then the CRC is checked. For function codes
+// without a known length, data is read until the CRC is valid. Data is
+// read until the timeout expires.
+func (r *RTUOverTCP) Read(p []byte) (int, error) {
+	err := r.sock.SetReadDeadline(time.Now().Add(r.timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for count < len(p) {
+		cnt, err := r.sock.Read(p[count:])
+		count += cnt
+
+		l := rtuResponseLen(p[:count])
+		if l > len(p) {
+			break
+		}
+		if l > 0 && count >= l {
+			return l, CheckRtuCrc(p[:l])
+		}
+		if l < 0 && CheckRtuCrc(p[:count]) == nil {
+			return count, nil
+		}
+
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, errors.New("RTU packet larger than read buffer")
+}
+
+func (r *RTUOverTCP) Write(p []byte) (int, error) {
+	err := r.sock.SetWriteDeadline(time.Now().Add(r.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return r.sock.Write(p)
+}
+
+// Type returns TransportType
+func (r *RTUOverTCP) Type() TransportType {
+	return TransportTypeRTUOverTCP
+}
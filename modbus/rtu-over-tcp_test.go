@@ -0,0 +1,96 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRTUOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	regs := &Regs{}
+	regs.AddReg(2, 2)
+	err = regs.WriteRegFloat32(2, 12.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a serial to Ethernet converter that sends the response in
+	// two TCP segments
+	go func() {
+		sock, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer sock.Close()
+
+		rtu := NewRTU(sock)
+		buf := make([]byte, 256)
+		cnt, err := sock.Read(buf)
+		if err != nil {
+			return
+		}
+
+		_, req, err := rtu.Decode(buf[:cnt])
+		if err != nil {
+			return
+		}
+
+		_, resp, err := req.ProcessRequest(regs)
+		if err != nil {
+			return
+		}
+
+		packet, _ := rtu.Encode(1, resp)
+		_, _ = sock.Write(packet[:3])
+		time.Sleep(20 * time.Millisecond)
+		_, _ = sock.Write(packet[3:])
+	}()
+
+	sock, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(NewRTUOverTCP(sock, time.Second), 0)
+	defer client.Close()
+
+	hr, err := client.ReadHoldingRegs(1, 2, 2)
+	if err != nil {
+		t.Fatal("read holding regs returned err: ", err)
+	}
+
+	if v := RegsToFloat32(hr)[0]; v != 12.5 {
+		t.Fatal("wrong value: ", v)
+	}
+}
+
+func TestRTUResponseLen(t *testing.T) {
+	tests := []struct {
+		buf []byte
+		exp int
+	}{
+		{[]byte{1}, 0},
+		{[]byte{1, 3}, 0},
+		{[]byte{1, 3, 4}, 9},
+		{[]byte{1, 0x83}, 5},
+		{[]byte{1, 6}, 8},
+		{[]byte{1, 16}, 8},
+		{[]byte{1, 22}, 10},
+		{[]byte{1, 24, 0}, 0},
+		{[]byte{1, 24, 0, 6}, 12},
+		{[]byte{1, 43}, -1},
+	}
+
+	for _, test := range tests {
+		l := rtuResponseLen(test.buf)
+		if l != test.exp {
+			t.Errorf("%x: expected %v, got %v", test.buf, test.exp, l)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ScanResult describes a device that responded to a scan
+type ScanResult struct {
+	ID byte
+	// DeviceID is only set if requested and the device supports FC43
+	DeviceID DeviceID
+}
+
+// Probe checks if a device with the given ID is present by reading holding
+// register 0. Any response, including an exception, means the device is
+// present, except for gateway exceptions, which a gateway returns when
+// the device does not respond. Timeouts and corrupt responses (which are
+// common when the baud rate is wrong) are treated as no device.
+func (c *Client) Probe(id byte) bool {
+	_, err := c.ReadHoldingRegs(id, 0, 1)
+	if err == nil {
+		return true
+	}
+
+	var exc ExceptionCode
+	if !errors.As(err, &exc) {
+		return false
+	}
+
+	return exc != ExcGatewayPathUnavilable &&
+		exc != ExcGatewayTargetFailedToRespond
+}
+
+// Scan probes each ID and returns the devices that respond. If deviceID is
+// set, the device identification objects are read from each device found.
+// found is called as each device is found, and may be nil. The transport
+// read timeout determines how long a scan takes, as each missing device
+// times out.
+func (c *Client) Scan(ids []byte, deviceID bool, found func(ScanResult)) []ScanResult {
+	var ret []ScanResult
+
+	for _, id := range ids {
+		if !c.Probe(id) {
+			continue
+		}
+
+		r := ScanResult{ID: id}
+		if deviceID {
+			// many devices do not support FC43, so errors are ignored
+			r.DeviceID, _ = c.ReadDeviceID(id)
+		}
+
+		ret = append(ret, r)
+
+		if found != nil {
+			found(r)
+		}
+	}
+
+	return ret
+}
+
+// ParseIDs parses a list of device IDs and ID ranges, for example
+// "1-10,20,30-32". IDs must be in the range 1-247. An empty string returns
+// all valid IDs.
+func ParseIDs(s string) ([]byte, error) {
+	if strings.TrimSpace(s) == "" {
+		s = "1-247"
+	}
+
+	var ret []byte
+
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		first, last, isRange := strings.Cut(f, "-")
+
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID: %v", f)
+		}
+
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil {
+				return nil, fmt.Errorf("invalid ID range: %v", f)
+			}
+		}
+
+		if start < 1 || end > 247 || start > end {
+			return nil, fmt.Errorf("invalid ID range, must be 1-247: %v", f)
+		}
+
+		for id := start; id <= end; id++ {
+			ret = append(ret, byte(id))
+		}
+	}
+
+	return ret, nil
+}
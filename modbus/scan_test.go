@@ -0,0 +1,165 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/respreader"
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestParseIDs(t *testing.T) {
+	ids, err := ParseIDs("1-3, 10,20-21")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []byte{1, 2, 3, 10, 20, 21}
+	if len(ids) != len(exp) {
+		t.Fatalf("exp %v, got %v", exp, ids)
+	}
+	for i := range exp {
+		if ids[i] != exp[i] {
+			t.Fatalf("exp %v, got %v", exp, ids)
+		}
+	}
+
+	ids, err = ParseIDs("")
+	if err != nil || len(ids) != 247 {
+		t.Error("default IDs failed: ", len(ids), err)
+	}
+
+	for _, s := range []string{"0", "248", "5-2", "a", "1-b"} {
+		if _, err := ParseIDs(s); err == nil {
+			t.Error("expected error for: ", s)
+		}
+	}
+}
+
+func TestReadDeviceIDPDU(t *testing.T) {
+	regs := &Regs{}
+
+	req := ReadDeviceID(ReadDeviceIDBasic, 0)
+	_, resp, err := req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no objects set, so the response is empty
+	objects, more, _, err := resp.RespReadDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 || more {
+		t.Error("expected no objects: ", objects)
+	}
+
+	regs.SetDeviceID(DeviceID{
+		DeviceIDVendorName:         "SIOT",
+		DeviceIDProductCode:        "TEST",
+		DeviceIDMajorMinorRevision: "1.2",
+		DeviceIDModelName:          "model",
+	})
+
+	_, resp, err = req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, _, _, err = resp.RespReadDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// model name is not a basic object
+	if len(objects) != 3 || objects.String() != "SIOT TEST 1.2" {
+		t.Error("wrong basic objects: ", objects)
+	}
+
+	req = ReadDeviceID(ReadDeviceIDSpecific, DeviceIDModelName)
+	_, resp, err = req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, _, _, err = resp.RespReadDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 1 || objects[DeviceIDModelName] != "model" {
+		t.Error("wrong specific object: ", objects)
+	}
+
+	req = ReadDeviceID(ReadDeviceIDSpecific, DeviceIDVendorURL)
+	_, resp, err = req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.FunctionCode != FuncCodeEncapsulatedInterface|0x80 ||
+		ExceptionCode(resp.Data[0]) != ExcIllegalAddress {
+		t.Error("expected illegal address exception: ", resp)
+	}
+}
+
+func TestReadDeviceIDMoreFollows(t *testing.T) {
+	regs := &Regs{}
+
+	long := string(make([]byte, 200))
+	regs.SetDeviceID(DeviceID{
+		DeviceIDVendorName:  "SIOT",
+		DeviceIDProductName: long,
+		DeviceIDModelName:   long,
+	})
+
+	req := ReadDeviceID(ReadDeviceIDRegular, 0)
+	_, resp, err := req.ProcessRequest(regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, more, next, err := resp.RespReadDeviceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 2 || !more || next != DeviceIDModelName {
+		t.Errorf("wrong response, objects: %v, more: %v, next: %v",
+			len(objects), more, next)
+	}
+}
+
+func TestScan(t *testing.T) {
+	a, b := test.NewIoSim()
+
+	regs := &Regs{}
+	regs.SetDeviceID(DeviceID{
+		DeviceIDVendorName:         "SIOT",
+		DeviceIDProductCode:        "TEST",
+		DeviceIDMajorMinorRevision: "1.2",
+	})
+
+	// the device does not have register 0, so it responds to the probe
+	// with an exception
+	server := NewServer(5, NewRTU(respreader.NewReadWriteCloser(a,
+		time.Second, 5*time.Millisecond)), regs, 0)
+	go server.Listen(func(error) {}, func() {}, func() {})
+	defer server.Close()
+
+	client := NewClient(NewRTU(respreader.NewReadWriteCloser(b,
+		50*time.Millisecond, 5*time.Millisecond)), 0)
+
+	var found []byte
+	results := client.Scan([]byte{3, 4, 5, 6}, true, func(r ScanResult) {
+		found = append(found, r.ID)
+	})
+
+	if len(results) != 1 || results[0].ID != 5 || len(found) != 1 {
+		t.Fatal("expected to find device 5: ", results)
+	}
+
+	if results[0].DeviceID.String() != "SIOT TEST 1.2" {
+		t.Error("wrong device ID: ", results[0].DeviceID)
+	}
+}
@@ -9,9 +9,8 @@ import (
 	"github.com/simpleiot/simpleiot/test"
 )
 
-// Server defines a server (slave)
-// Current Server only supports Modbus RTU,
-// but could be expanded to do ASCII and TCP.
+// Server defines a server (slave). RTU and ASCII servers use NewServer,
+// TCP servers use NewTCPServer.
 type Server struct {
 	id        byte
 	transport Transport
@@ -58,12 +57,12 @@ func (s *Server) Listen(errorCallback func(error),
 			return
 		default:
 		}
-		buf := make([]byte, 200)
+		buf := make([]byte, maxFrameSize)
 		cnt, err := s.transport.Read(buf)
 		if err != nil {
-			if err != io.EOF && s.transport.Type() == TransportTypeRTU {
-				// only print errors for RTU for now as we get timeout
-				// errors with TCP
+			if err != io.EOF && s.transport.Type() != TransportTypeTCP {
+				// only print errors for serial transports for now as
+				// we get timeout errors with TCP
 				log.Println("Error reading modbus port: ", err)
 			}
 
@@ -122,6 +122,7 @@ type TCPServer struct {
 	port       string
 	regs       *Regs
 	debug      int
+	capture    *CaptureWriter
 
 	// state
 	listener net.Listener
@@ -147,6 +148,15 @@ func NewTCPServer(id, maxClients int, port string, regs *Regs, debug int) (*TCPS
 	}, nil
 }
 
+// SetCapture records the traffic of connections accepted after this call
+// to a capture. Connections that are already open are not captured, so
+// this is typically called before Listen.
+func (ts *TCPServer) SetCapture(capture *CaptureWriter) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.capture = capture
+}
+
 // Listen starts the server and listens for modbus requests
 // this function does not return unless an error occurs
 // The listen function supports various debug levels:
@@ -173,7 +183,10 @@ func (ts *TCPServer) Listen(errorCallback func(error),
 
 		ts.lock.Lock()
 		if len(ts.servers) < ts.maxClients {
-			transport := NewTCP(sock, 500*time.Millisecond, TransportServer)
+			var transport Transport = NewTCP(sock, 500*time.Millisecond, TransportServer)
+			if ts.capture != nil {
+				transport = NewCaptureTransport(transport, ts.capture)
+			}
 			server := NewServer(byte(ts.id), transport, ts.regs, ts.debug)
 			ts.servers = append(ts.servers, server)
 			go server.Listen(errorCallback,
@@ -9,8 +9,11 @@ type TransportType string
 
 // define valid transport types
 const (
-	TransportTypeTCP TransportType = "tcp"
-	TransportTypeRTU TransportType = "rtu"
+	TransportTypeTCP   TransportType = "tcp"
+	TransportTypeRTU   TransportType = "rtu"
+	TransportTypeASCII TransportType = "ascii"
+	// RTU packets sent over a TCP connection
+	TransportTypeRTUOverTCP TransportType = "rtuovertcp"
 )
 
 // TransportClientServer defines if transport is being used for a client or server
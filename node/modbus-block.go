@@ -0,0 +1,200 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+)
+
+// max number of registers and bits that can be read in one request
+const (
+	modbusMaxReadRegs = 125
+	modbusMaxReadBits = 2000
+)
+
+// modbusBlock is a group of client IOs with the same slave ID and IO type
+// that are read in one request
+type modbusBlock struct {
+	id      int
+	ioType  string
+	address int
+	count   int
+	ios     []*ModbusIO
+}
+
+// ioCount returns the number of registers or bits used by an IO
+func ioCount(io *ModbusIONode) int {
+	switch io.modbusIOType {
+	case data.PointValueModbusHoldingRegister, data.PointValueModbusInputRegister:
+		return regCount(io)
+	default:
+		return 1
+	}
+}
+
+// modbusBlocks groups IOs into contiguous blocks. An IO is added to the
+// current block if it has the same slave ID and IO type, the number of
+// unused registers between it and the previous IO is <= gap, and the block
+// does not grow larger than max registers (or bits).
+func modbusBlocks(ios []*ModbusIO, max, gap int) []modbusBlock {
+	sorted := make([]*ModbusIO, len(ios))
+	copy(sorted, ios)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].ioNode, sorted[j].ioNode
+		if a.id != b.id {
+			return a.id < b.id
+		}
+		if a.modbusIOType != b.modbusIOType {
+			return a.modbusIOType < b.modbusIOType
+		}
+		return a.address < b.address
+	})
+
+	var ret []modbusBlock
+
+	for _, io := range sorted {
+		n := io.ioNode
+		end := n.address + ioCount(n)
+
+		maxCount := max
+		switch n.modbusIOType {
+		case data.PointValueModbusCoil, data.PointValueModbusDiscreteInput:
+			if maxCount > modbusMaxReadBits {
+				maxCount = modbusMaxReadBits
+			}
+		default:
+			if maxCount > modbusMaxReadRegs {
+				maxCount = modbusMaxReadRegs
+			}
+		}
+
+		if len(ret) > 0 {
+			blk := &ret[len(ret)-1]
+			blkEnd := blk.address + blk.count
+			if blk.id == n.id && blk.ioType == n.modbusIOType &&
+				n.address-blkEnd <= gap {
+				newEnd := blkEnd
+				if end > newEnd {
+					newEnd = end
+				}
+				if newEnd-blk.address <= maxCount {
+					blk.count = newEnd - blk.address
+					blk.ios = append(blk.ios, io)
+					continue
+				}
+			}
+		}
+
+		ret = append(ret, modbusBlock{
+			id:      n.id,
+			ioType:  n.modbusIOType,
+			address: n.address,
+			count:   end - n.address,
+			ios:     []*ModbusIO{io},
+		})
+	}
+
+	return ret
+}
+
+// ReadBusBlock reads all the IOs in a block with one request and updates
+// their values. If the device responds with an exception (for instance
+// because the block includes registers that do not exist), the IOs are
+// read individually. Errors reading individual IOs are logged.
+func (b *Modbus) ReadBusBlock(blk modbusBlock) error {
+	if b.client == nil {
+		return errors.New("client is not set up")
+	}
+
+	if len(blk.ios) == 1 {
+		// nothing to gain from a block read
+		return b.readBlockIO(blk.ios[0])
+	}
+
+	id, address, count := byte(blk.id), uint16(blk.address), uint16(blk.count)
+
+	var err error
+	var regs []uint16
+	var bits []bool
+
+	switch blk.ioType {
+	case data.PointValueModbusHoldingRegister:
+		regs, err = b.client.ReadHoldingRegs(id, address, count)
+	case data.PointValueModbusInputRegister:
+		regs, err = b.client.ReadInputRegs(id, address, count)
+	case data.PointValueModbusCoil:
+		bits, err = b.client.ReadCoils(id, address, count)
+	case data.PointValueModbusDiscreteInput:
+		bits, err = b.client.ReadDiscreteInputs(id, address, count)
+	default:
+		return fmt.Errorf("unhandled modbus io type: %v", blk.ioType)
+	}
+
+	if err != nil {
+		var exc modbus.ExceptionCode
+		if errors.As(err, &exc) {
+			if b.busNode.debugLevel >= 1 {
+				log.Printf("Modbus block read ID: %v, address: %v, count: %v, exception: %v, reading IOs individually\n",
+					blk.id, blk.address, blk.count, err)
+			}
+			for _, io := range blk.ios {
+				err := b.readBlockIO(io)
+				if err != nil {
+					log.Println("Error reading modbus IO: ", err)
+				}
+			}
+			return nil
+		}
+
+		for _, io := range blk.ios {
+			err := b.LogError(io.ioNode, err)
+			if err != nil {
+				log.Println("Error logging modbus error: ", err)
+			}
+		}
+		return nil
+	}
+
+	for _, io := range blk.ios {
+		offset := io.ioNode.address - blk.address
+		if regs != nil {
+			// a short response leaves no regs for this IO, and
+			// decodeRegs returns the short data error
+			if offset > len(regs) {
+				offset = len(regs)
+			}
+			err = b.UpdateRegValue(io, regs[offset:])
+		} else if offset < len(bits) {
+			err = b.updateValue(io, data.BoolToFloat(bits[offset]))
+		} else {
+			err = errors.New("Did not receive enough data")
+		}
+
+		if err == nil {
+			err = b.ClientWrite(io)
+		}
+
+		if err != nil {
+			err := b.LogError(io.ioNode, err)
+			if err != nil {
+				log.Println("Error logging modbus error: ", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readBlockIO reads and writes a single IO, and logs any error
+func (b *Modbus) readBlockIO(io *ModbusIO) error {
+	err := b.ClientIO(io)
+	if err != nil {
+		return b.LogError(io.ioNode, err)
+	}
+	return nil
+}
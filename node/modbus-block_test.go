@@ -0,0 +1,87 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModbusBlocks(t *testing.T) {
+	newIO := func(desc string, id, address int, ioType, dataType string) *ModbusIO {
+		return &ModbusIO{ioNode: &ModbusIONode{
+			description:    desc,
+			id:             id,
+			address:        address,
+			modbusIOType:   ioType,
+			modbusDataType: dataType,
+		}}
+	}
+
+	hr := data.PointValueModbusHoldingRegister
+	ir := data.PointValueModbusInputRegister
+	coil := data.PointValueModbusCoil
+
+	ios := []*ModbusIO{
+		newIO("a", 1, 0, hr, data.PointValueUINT16),
+		newIO("b", 1, 1, hr, data.PointValueFLOAT32),
+		// gap of 2 regs
+		newIO("c", 1, 5, hr, data.PointValueUINT16),
+		// gap of 3 regs
+		newIO("d", 1, 9, hr, data.PointValueINT32),
+		// different type
+		newIO("e", 1, 2, ir, data.PointValueUINT16),
+		// different ID
+		newIO("f", 2, 0, hr, data.PointValueUINT16),
+		newIO("g", 1, 100, coil, ""),
+		newIO("h", 1, 101, coil, ""),
+		// exceeds max of 10
+		newIO("i", 1, 110, coil, ""),
+	}
+
+	type block struct {
+		address, count int
+		ios            string
+	}
+
+	tests := []struct {
+		max, gap int
+		exp      []block
+	}{
+		// blocks are sorted by ID, IO type, and address
+		{10, 2, []block{{100, 2, "gh"}, {110, 1, "i"}, {0, 6, "abc"}, {9, 2, "d"},
+			{2, 1, "e"}, {0, 1, "f"}}},
+		{10, 3, []block{{100, 2, "gh"}, {110, 1, "i"}, {0, 6, "abc"}, {9, 2, "d"},
+			{2, 1, "e"}, {0, 1, "f"}}},
+		{20, 3, []block{{100, 2, "gh"}, {110, 1, "i"}, {0, 11, "abcd"},
+			{2, 1, "e"}, {0, 1, "f"}}},
+		{20, 8, []block{{100, 11, "ghi"}, {0, 11, "abcd"}, {2, 1, "e"}, {0, 1, "f"}}},
+		{20, 0, []block{{100, 2, "gh"}, {110, 1, "i"}, {0, 3, "ab"}, {5, 1, "c"},
+			{9, 2, "d"}, {2, 1, "e"}, {0, 1, "f"}}},
+	}
+
+	for _, test := range tests {
+		blocks := modbusBlocks(ios, test.max, test.gap)
+		var got []block
+		for _, blk := range blocks {
+			b := block{address: blk.address, count: blk.count}
+			for _, io := range blk.ios {
+				b.ios += io.ioNode.description
+			}
+			got = append(got, b)
+		}
+
+		if len(got) != len(test.exp) {
+			t.Errorf("max %v, gap %v: exp %+v, got %+v", test.max, test.gap,
+				test.exp, got)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != test.exp[i] {
+				t.Errorf("max %v, gap %v: exp %+v, got %+v", test.max, test.gap,
+					test.exp, got)
+				break
+			}
+		}
+	}
+}
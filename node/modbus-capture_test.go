@@ -0,0 +1,88 @@
+package node_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+	"github.com/simpleiot/simpleiot/node"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestModbusCapture(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("SIOT_DATA", dataDir)
+
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	regs := &modbus.Regs{}
+	regs.AddReg(0, 1)
+
+	busNode, chPoints, stopBus := startModbusScanBus(t, nc, regs)
+	defer stopBus()
+
+	// enabling capture sets up the port
+	err = client.SendNodePoint(nc, busNode.ID, data.Point{Type: data.PointTypeCapture,
+		Value: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a scan generates a known sequence of requests
+	startModbusScan(t, nc, busNode.ID, node.ModbusScanRequest{IDs: "1-3"})
+
+	timeout := time.After(5 * time.Second)
+
+done:
+	for {
+		select {
+		case p := <-chPoints:
+			if p.Type == data.PointTypeScanning && p.Value == 0 {
+				break done
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for scan")
+		}
+	}
+
+	typ, frames, err := modbus.ReadCaptureFile(filepath.Join(dataDir,
+		"modbus-capture", busNode.ID+".pcap"))
+	if err != nil {
+		t.Fatal("Error reading capture: ", err)
+	}
+
+	if typ != modbus.TransportTypeTCP {
+		t.Error("wrong transport type: ", typ)
+	}
+
+	// a probe request and response for each ID
+	if len(frames) != 6 {
+		t.Fatal("expected 6 frames, got: ", frames)
+	}
+
+	for i, f := range frames {
+		exp := modbus.DirectionTx
+		if i%2 == 1 {
+			exp = modbus.DirectionRx
+		}
+		if f.Direction != exp {
+			t.Error("wrong direction for frame: ", f)
+		}
+	}
+
+	// replaying the capture reproduces the scan without the gateway
+	c := modbus.NewClient(modbus.NewReplay(modbus.NewTCP(nil, 0,
+		modbus.TransportClient), frames), 0)
+	found := c.Scan([]byte{1, 2, 3}, false, nil)
+	if len(found) != 1 || found[0].ID != 2 {
+		t.Error("wrong replayed scan result: ", found)
+	}
+}
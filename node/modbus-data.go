@@ -0,0 +1,132 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+)
+
+// regCount returns the number of registers used by a register IO
+func regCount(io *ModbusIONode) int {
+	switch io.modbusDataType {
+	case data.PointValueUINT16, data.PointValueINT16, data.PointValueBit:
+		return 1
+	case data.PointValueUINT32, data.PointValueINT32,
+		data.PointValueFLOAT32:
+		return 2
+	case data.PointValueUINT64, data.PointValueINT64,
+		data.PointValueFLOAT64:
+		return 4
+	case data.PointValueString:
+		if io.count > 0 {
+			return io.count
+		}
+		return 1
+	default:
+		log.Println("regCount, unknown data type: ", io.modbusDataType)
+		// be conservative
+		return 2
+	}
+}
+
+// stringByteOrder returns the byte order used for strings. Word order does
+// not apply to strings, so only the byte swap is used.
+func stringByteOrder(order string) string {
+	switch order {
+	case modbus.ByteOrderBADC, modbus.ByteOrderDCBA:
+		return modbus.ByteOrderBADC
+	default:
+		return modbus.ByteOrderABCD
+	}
+}
+
+// decodeRegs decodes the value of a register IO. regs starts at the IO
+// address. Numeric values are scaled, bits are returned as 0 or 1, and
+// strings are returned in text.
+func decodeRegs(io *ModbusIONode, regs []uint16) (float64, string, error) {
+	count := regCount(io)
+	if len(regs) < count {
+		return 0, "", errors.New("Did not receive enough data")
+	}
+	regs = regs[:count]
+
+	switch io.modbusDataType {
+	case data.PointValueBit:
+		return data.BoolToFloat(regs[0]&(1<<uint(io.bit)) != 0), "", nil
+	case data.PointValueString:
+		regs = modbus.OrderRegs(regs, stringByteOrder(io.byteOrder))
+		return 0, modbus.RegsToString(regs), nil
+	}
+
+	regs = modbus.OrderRegs(regs, io.byteOrder)
+
+	var valueUnscaled float64
+	switch io.modbusDataType {
+	case data.PointValueUINT16:
+		valueUnscaled = float64(regs[0])
+	case data.PointValueINT16:
+		valueUnscaled = float64(int16(regs[0]))
+	case data.PointValueUINT32:
+		valueUnscaled = float64(modbus.RegsToUint32(regs)[0])
+	case data.PointValueINT32:
+		valueUnscaled = float64(modbus.RegsToInt32(regs)[0])
+	case data.PointValueFLOAT32:
+		valueUnscaled = float64(modbus.RegsToFloat32(regs)[0])
+	case data.PointValueUINT64:
+		valueUnscaled = float64(modbus.RegsToUint64(regs)[0])
+	case data.PointValueINT64:
+		valueUnscaled = float64(modbus.RegsToInt64(regs)[0])
+	case data.PointValueFLOAT64:
+		valueUnscaled = modbus.RegsToFloat64(regs)[0]
+	default:
+		return 0, "", fmt.Errorf("unhandled data type: %v",
+			io.modbusDataType)
+	}
+
+	return valueUnscaled*io.scale + io.offset, "", nil
+}
+
+// encodeRegs encodes a value for a register IO. The value is unscaled
+// before it is encoded, and text is used for strings. Bit IOs are not
+// handled here as they only modify part of a register.
+func encodeRegs(io *ModbusIONode, value float64, text string) ([]uint16, error) {
+	if io.modbusDataType == data.PointValueString {
+		regs := modbus.StringToRegs(text, regCount(io))
+		return modbus.OrderRegs(regs, stringByteOrder(io.byteOrder)), nil
+	}
+
+	unscaledValue := (value - io.offset) / io.scale
+
+	// round integer types so that scaling errors do not truncate values
+	// (for instance 23.5 / 0.1 = 234.99999)
+	rounded := math.Round(unscaledValue)
+
+	var regs []uint16
+	switch io.modbusDataType {
+	case data.PointValueUINT16:
+		regs = []uint16{uint16(rounded)}
+	case data.PointValueINT16:
+		regs = []uint16{uint16(int16(rounded))}
+	case data.PointValueUINT32:
+		regs = modbus.Uint32ToRegs([]uint32{uint32(rounded)})
+	case data.PointValueINT32:
+		regs = modbus.Int32ToRegs([]int32{int32(rounded)})
+	case data.PointValueFLOAT32:
+		regs = modbus.Float32ToRegs([]float32{float32(unscaledValue)})
+	case data.PointValueUINT64:
+		regs = modbus.Uint64ToRegs([]uint64{uint64(rounded)})
+	case data.PointValueINT64:
+		regs = modbus.Int64ToRegs([]int64{int64(rounded)})
+	case data.PointValueFLOAT64:
+		regs = modbus.Float64ToRegs([]float64{unscaledValue})
+	default:
+		return nil, fmt.Errorf("unhandled data type: %v",
+			io.modbusDataType)
+	}
+
+	return modbus.OrderRegs(regs, io.byteOrder), nil
+}
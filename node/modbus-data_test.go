@@ -0,0 +1,138 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+)
+
+func TestModbusEncodeDecode(t *testing.T) {
+	tests := []struct {
+		dataType string
+		order    string
+		value    float64
+		text     string
+	}{
+		{data.PointValueUINT16, "", 1234, ""},
+		{data.PointValueINT16, modbus.ByteOrderBADC, -1234, ""},
+		{data.PointValueUINT32, modbus.ByteOrderCDAB, 123456789, ""},
+		{data.PointValueINT32, modbus.ByteOrderDCBA, -123456789, ""},
+		{data.PointValueFLOAT32, modbus.ByteOrderCDAB, 12.5, ""},
+		{data.PointValueUINT64, modbus.ByteOrderABCD, 1 << 40, ""},
+		{data.PointValueINT64, modbus.ByteOrderCDAB, -(1 << 40), ""},
+		{data.PointValueFLOAT64, modbus.ByteOrderDCBA, 1.23456789e-100, ""},
+		{data.PointValueString, modbus.ByteOrderBADC, 0, "SN-1234"},
+	}
+
+	for _, test := range tests {
+		io := &ModbusIONode{
+			modbusDataType: test.dataType,
+			byteOrder:      test.order,
+			scale:          1,
+			count:          5,
+		}
+
+		regs, err := encodeRegs(io, test.value, test.text)
+		if err != nil {
+			t.Fatal("encode error: ", err)
+		}
+
+		if len(regs) != regCount(io) {
+			t.Errorf("%v: expected %v regs, got %v", test.dataType,
+				regCount(io), len(regs))
+		}
+
+		v, text, err := decodeRegs(io, regs)
+		if err != nil {
+			t.Fatal("decode error: ", err)
+		}
+
+		if v != test.value || text != test.text {
+			t.Errorf("%v/%v: expected %v %q, got %v %q", test.dataType,
+				test.order, test.value, test.text, v, text)
+		}
+	}
+}
+
+func TestModbusDecodeScaleBit(t *testing.T) {
+	io := &ModbusIONode{
+		modbusDataType: data.PointValueINT16,
+		scale:          0.1,
+		offset:         -10,
+	}
+
+	v, _, err := decodeRegs(io, []uint16{0xff9c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -100 * 0.1 - 10
+	if v != -20 {
+		t.Error("expected -20, got: ", v)
+	}
+
+	regs, err := encodeRegs(io, 13.5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if regs[0] != 235 {
+		t.Error("expected 235, got: ", regs[0])
+	}
+
+	io = &ModbusIONode{modbusDataType: data.PointValueBit, bit: 3}
+
+	for _, test := range []struct {
+		reg uint16
+		exp float64
+	}{{0x0008, 1}, {0xfff7, 0}} {
+		v, _, err := decodeRegs(io, []uint16{test.reg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != test.exp {
+			t.Errorf("reg %x, expected %v, got %v", test.reg, test.exp, v)
+		}
+	}
+}
+
+func TestModbusRegChanged(t *testing.T) {
+	float := &ModbusIONode{
+		modbusIOType:   data.PointValueModbusHoldingRegister,
+		modbusDataType: data.PointValueFLOAT32,
+		address:        10,
+	}
+
+	coil := &ModbusIONode{
+		modbusIOType: data.PointValueModbusCoil,
+		address:      10,
+	}
+
+	input := &ModbusIONode{
+		modbusIOType:   data.PointValueModbusInputRegister,
+		modbusDataType: data.PointValueUINT16,
+		address:        10,
+	}
+
+	tests := []struct {
+		io     *ModbusIONode
+		change modbus.RegChange
+		exp    bool
+	}{
+		{float, modbus.RegChange{Table: modbus.TableHoldingRegs, Address: 11, Count: 1}, true},
+		{float, modbus.RegChange{Table: modbus.TableHoldingRegs, Address: 8, Count: 2}, false},
+		{float, modbus.RegChange{Table: modbus.TableCoils, Address: 10, Count: 1}, false},
+		{coil, modbus.RegChange{Table: modbus.TableCoils, Address: 8, Count: 3}, true},
+		{coil, modbus.RegChange{Table: modbus.TableHoldingRegs, Address: 10, Count: 1}, false},
+		// input registers can't be written by clients
+		{input, modbus.RegChange{Table: modbus.TableHoldingRegs, Address: 10, Count: 1}, false},
+	}
+
+	for i, test := range tests {
+		if got := regChanged(test.io, test.change); got != test.exp {
+			t.Errorf("test %v: expected %v, got %v", i, test.exp, got)
+		}
+	}
+}
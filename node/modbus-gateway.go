@@ -0,0 +1,104 @@
+package node
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/modbus"
+)
+
+// modbusGatewayTimeout is how long a gateway request waits for the bus
+const modbusGatewayTimeout = 5 * time.Second
+
+// modbusGatewayRequest is a request received by the TCP gateway that is
+// forwarded to a device on the bus
+type modbusGatewayRequest struct {
+	id   byte
+	req  modbus.PDU
+	resp chan modbusGatewayResponse
+}
+
+type modbusGatewayResponse struct {
+	pdu modbus.PDU
+	err error
+}
+
+// setupGateway starts a Modbus TCP server that forwards requests to
+// devices on a client bus by unit ID
+func (b *Modbus) setupGateway() error {
+	if b.busNode.gatewayPort == "" {
+		return nil
+	}
+
+	var err error
+	b.gateway, err = modbus.NewTCPGateway(5, b.busNode.gatewayPort,
+		b.gatewayRequest, b.busNode.debugLevel)
+	if err != nil {
+		b.gateway = nil
+		return err
+	}
+
+	go b.gateway.Listen(func(err error) {
+		if b.busNode.debugLevel >= 1 {
+			log.Println("Modbus gateway error: ", err)
+		}
+	}, func() {}, func() {
+		if b.busNode.debugLevel > 0 {
+			log.Println("Modbus gateway done")
+		}
+	})
+
+	return nil
+}
+
+// gatewayRequest is called by the gateway for each TCP request. Requests
+// are passed to the bus goroutine so they are interleaved with polling.
+func (b *Modbus) gatewayRequest(id byte, req modbus.PDU) (modbus.PDU, error) {
+	r := modbusGatewayRequest{
+		id:   id,
+		req:  req,
+		resp: make(chan modbusGatewayResponse, 1),
+	}
+
+	timeout := time.NewTimer(modbusGatewayTimeout)
+	defer timeout.Stop()
+
+	select {
+	case b.chGateway <- r:
+	case <-timeout.C:
+		return modbus.PDU{}, modbus.ExcServerDeviceBusy
+	}
+
+	select {
+	case resp := <-r.resp:
+		return resp.pdu, resp.err
+	case <-timeout.C:
+		return modbus.PDU{}, errors.New("timeout waiting for bus")
+	}
+}
+
+// handleGateway forwards a gateway request to the bus. Broadcasts (ID 0)
+// are sent without waiting for a response.
+func (b *Modbus) handleGateway(r modbusGatewayRequest) {
+	if b.client == nil {
+		r.resp <- modbusGatewayResponse{err: modbus.ExcGatewayPathUnavilable}
+		return
+	}
+
+	if r.id == 0 {
+		// devices don't respond to a broadcast, so there is nothing to
+		// wait for
+		start := time.Now()
+		err := b.client.Broadcast(r.req)
+		b.busTime += time.Since(start)
+		r.resp <- modbusGatewayResponse{err: err}
+		return
+	}
+
+	start := time.Now()
+	pdu, err := b.client.Request(r.id, r.req)
+	b.busTime += time.Since(start)
+
+	r.resp <- modbusGatewayResponse{pdu: pdu, err: err}
+}
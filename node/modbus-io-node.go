@@ -14,11 +14,17 @@ type ModbusIONode struct {
 	address            int
 	modbusIOType       string
 	modbusDataType     string
+	byteOrder          string
+	bit                int
+	count              int
 	readOnly           bool
 	scale              float64
 	offset             float64
 	value              float64
 	valueSet           float64
+	valueText          string
+	valueSetText       string
+	pollPeriod         int
 	disable            bool
 	errorCount         int
 	errorCountCRC      int
@@ -63,18 +69,36 @@ func NewModbusIONode(busType string, node *data.NodeEdge) (*ModbusIONode, error)
 		if !ok {
 			return nil, errors.New("Data format must be specified")
 		}
-		ret.scale, ok = node.Points.Value(data.PointTypeScale, "")
-		if !ok {
-			return nil, errors.New("Must define modbus scale")
-		}
-		ret.offset, ok = node.Points.Value(data.PointTypeOffset, "")
-		if !ok {
-			return nil, errors.New("Must define modbus offset")
+		ret.byteOrder, _ = node.Points.Text(data.PointTypeByteOrder, "")
+
+		switch ret.modbusDataType {
+		case data.PointValueString:
+			ret.count, _ = node.Points.ValueInt(data.PointTypeCount, "")
+			if ret.count <= 0 {
+				return nil, errors.New("Must define string register count")
+			}
+		case data.PointValueBit:
+			ret.bit, _ = node.Points.ValueInt(data.PointTypeBit, "")
+			if ret.bit < 0 || ret.bit > 15 {
+				return nil, errors.New("Bit must be 0-15")
+			}
+		default:
+			ret.scale, ok = node.Points.Value(data.PointTypeScale, "")
+			if !ok {
+				return nil, errors.New("Must define modbus scale")
+			}
+			ret.offset, ok = node.Points.Value(data.PointTypeOffset, "")
+			if !ok {
+				return nil, errors.New("Must define modbus offset")
+			}
 		}
 	}
 
 	ret.value, _ = node.Points.Value(data.PointTypeValue, "")
 	ret.valueSet, _ = node.Points.Value(data.PointTypeValueSet, "")
+	ret.valueText, _ = node.Points.Text(data.PointTypeValue, "")
+	ret.valueSetText, _ = node.Points.Text(data.PointTypeValueSet, "")
+	ret.pollPeriod, _ = node.Points.ValueInt(data.PointTypePollPeriod, "")
 	ret.disable, _ = node.Points.ValueBool(data.PointTypeDisable, "")
 	ret.errorCount, _ = node.Points.ValueInt(data.PointTypeErrorCount, "")
 	ret.errorCountCRC, _ = node.Points.ValueInt(data.PointTypeErrorCountCRC, "")
@@ -93,10 +117,15 @@ func (io *ModbusIONode) Changed(newIO *ModbusIONode) bool {
 		io.address != newIO.address ||
 		io.modbusIOType != newIO.modbusIOType ||
 		io.modbusDataType != newIO.modbusDataType ||
+		io.byteOrder != newIO.byteOrder ||
+		io.bit != newIO.bit ||
+		io.count != newIO.count ||
 		io.scale != newIO.scale ||
 		io.offset != newIO.offset ||
 		io.value != newIO.value ||
 		io.valueSet != newIO.valueSet ||
+		io.valueText != newIO.valueText ||
+		io.valueSetText != newIO.valueSetText ||
 		io.errorCountReset != newIO.errorCountReset ||
 		io.errorCountCRCReset != newIO.errorCountCRCReset ||
 		io.errorCountEOFReset != newIO.errorCountEOFReset {
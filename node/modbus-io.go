@@ -13,6 +13,8 @@ type ModbusIO struct {
 	ioNode   *ModbusIONode
 	sub      *nats.Subscription
 	lastSent time.Time
+	// nextPoll is when the IO is due to be read on a client bus
+	nextPoll time.Time
 }
 
 // NewModbusIO creates a new modbus IO
@@ -17,11 +17,23 @@ type ModbusManager struct {
 
 // NewModbusManager creates a new modbus manager
 func NewModbusManager(nc *nats.Conn, rootNodeID string) *ModbusManager {
-	return &ModbusManager{
+	mm := &ModbusManager{
 		nc:         nc,
 		busses:     make(map[string]*Modbus),
 		rootNodeID: rootNodeID,
 	}
+
+	_, err := nc.Subscribe(client.SubjectModbusTemplates(), mm.handleTemplates)
+	if err != nil {
+		log.Println("Error subscribing to modbus templates: ", err)
+	}
+
+	_, err = nc.Subscribe(client.SubjectModbusTemplate("*"), mm.handleTemplate)
+	if err != nil {
+		log.Println("Error subscribing to modbus template requests: ", err)
+	}
+
+	return mm
 }
 
 // Update queries DB for modbus nodes and synchronizes
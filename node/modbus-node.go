@@ -14,12 +14,16 @@ type ModbusNode struct {
 	busType            string
 	protocol           string
 	uri                string
+	gatewayPort        string
 	id                 int // only used for server
 	portName           string
 	debugLevel         int
 	baud               int
 	pollPeriod         int
+	blockReadMax       int
+	blockReadGap       int
 	disable            bool
+	capture            bool
 	errorCount         int
 	errorCountCRC      int
 	errorCountEOF      int
@@ -46,7 +50,7 @@ func NewModbusNode(node data.NodeEdge) (*ModbusNode, error) {
 		return nil, errors.New("Must define modbus protocol")
 	}
 
-	if ret.protocol == data.PointValueRTU {
+	if ret.protocol == data.PointValueRTU || ret.protocol == data.PointValueASCII {
 		ret.portName, ok = node.Points.Text(data.PointTypePort, "")
 		if !ok {
 			return nil, errors.New("Must define modbus port name")
@@ -65,6 +69,16 @@ func NewModbusNode(node data.NodeEdge) (*ModbusNode, error) {
 		}
 	}
 
+	if ret.protocol == data.PointValueRTUOverTCP {
+		if ret.busType != data.PointValueClient {
+			return nil, errors.New("RTU over TCP is only supported for clients")
+		}
+		ret.uri, ok = node.Points.Text(data.PointTypeURI, "")
+		if !ok {
+			return nil, errors.New("Must define modbus URI")
+		}
+	}
+
 	if ret.protocol == data.PointValueTCP {
 		switch ret.busType {
 		case data.PointValueClient:
@@ -87,8 +101,12 @@ func NewModbusNode(node data.NodeEdge) (*ModbusNode, error) {
 		return nil, errors.New("Must define modbus polling period for client devices")
 	}
 
+	ret.gatewayPort, _ = node.Points.Text(data.PointTypeGatewayPort, "")
+	ret.blockReadMax, _ = node.Points.ValueInt(data.PointTypeBlockReadMax, "")
+	ret.blockReadGap, _ = node.Points.ValueInt(data.PointTypeBlockReadGap, "")
 	ret.debugLevel, _ = node.Points.ValueInt(data.PointTypeDebug, "")
 	ret.disable, _ = node.Points.ValueBool(data.PointTypeDisable, "")
+	ret.capture, _ = node.Points.ValueBool(data.PointTypeCapture, "")
 	ret.errorCount, _ = node.Points.ValueInt(data.PointTypeErrorCount, "")
 	ret.errorCountCRC, _ = node.Points.ValueInt(data.PointTypeErrorCountCRC, "")
 	ret.errorCountEOF, _ = node.Points.ValueInt(data.PointTypeErrorCountEOF, "")
@@ -0,0 +1,273 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+)
+
+// ModbusScanRequest is sent to start a scan for devices on a client bus
+type ModbusScanRequest struct {
+	// IDs to probe, for example "1-10,20". Defaults to 1-247.
+	IDs string `json:"ids"`
+	// Baud rates to scan on serial buses. Defaults to the bus baud rate,
+	// and is ignored for TCP buses.
+	Bauds []int `json:"bauds"`
+	// Read device identification (FC43) from each device found
+	DeviceID bool `json:"deviceId"`
+}
+
+type modbusScanRequest struct {
+	req  ModbusScanRequest
+	resp chan error
+}
+
+// modbusBusScan is the state of a scan in progress. One ID is probed each
+// time the scan timer fires, so points and gateway requests are still
+// handled while a scan is running. IO polling is suspended during a scan.
+type modbusBusScan struct {
+	ids       []byte
+	bauds     []int
+	deviceID  bool
+	baudIndex int
+	idIndex   int
+}
+
+// isSerial returns true if the bus uses a serial port
+func (b *Modbus) isSerial() bool {
+	return b.busNode.protocol == data.PointValueRTU ||
+		b.busNode.protocol == data.PointValueASCII
+}
+
+// handleScanRequest is called by NATS when a scan is requested. The
+// request is passed to the bus goroutine, and an empty response is sent if
+// the scan was started, otherwise the error text.
+func (b *Modbus) handleScanRequest(msg *nats.Msg) {
+	err := func() error {
+		var req ModbusScanRequest
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			return fmt.Errorf("error decoding request: %w", err)
+		}
+
+		r := modbusScanRequest{req: req, resp: make(chan error, 1)}
+
+		timeout := time.NewTimer(modbusGatewayTimeout)
+		defer timeout.Stop()
+
+		select {
+		case b.chScan <- r:
+		case <-timeout.C:
+			return errors.New("timeout waiting for bus")
+		}
+
+		return <-r.resp
+	}()
+
+	var ret string
+	if err != nil {
+		ret = err.Error()
+	}
+
+	err = msg.Respond([]byte(ret))
+	if err != nil {
+		log.Println("Error responding to modbus scan request: ", err)
+	}
+}
+
+// startScan validates a scan request and starts the scan
+func (b *Modbus) startScan(req ModbusScanRequest) error {
+	if b.busNode.busType != data.PointValueClient {
+		return errors.New("scans are only supported on client buses")
+	}
+
+	if b.busNode.disable {
+		return errors.New("bus is disabled")
+	}
+
+	if b.busScan != nil {
+		return errors.New("scan already in progress")
+	}
+
+	ids, err := modbus.ParseIDs(req.IDs)
+	if err != nil {
+		return err
+	}
+
+	bauds := []int{0}
+	if b.isSerial() {
+		bauds = req.Bauds
+		if len(bauds) == 0 {
+			bauds = []int{b.busNode.baud}
+		}
+		for _, baud := range bauds {
+			if baud <= 0 {
+				return fmt.Errorf("invalid baud: %v", baud)
+			}
+		}
+	} else if b.client == nil {
+		return errors.New("bus is not connected")
+	}
+
+	// clear the results of the last scan
+	for _, p := range b.node.Points {
+		if p.Type != data.PointTypeScanDevice || p.Tombstone%2 == 1 {
+			continue
+		}
+		p.Tombstone++
+		p.Time = time.Now()
+		b.sendScanPoint(p)
+	}
+
+	b.busScan = &modbusBusScan{
+		ids:      ids,
+		bauds:    bauds,
+		deviceID: req.DeviceID,
+	}
+
+	if b.busNode.debugLevel >= 1 {
+		log.Printf("Modbus bus %v: scanning IDs %v, bauds %v\n",
+			b.busNode.portName, req.IDs, bauds)
+	}
+
+	b.sendScanPoint(data.Point{Type: data.PointTypeScanning, Value: 1})
+	b.setScanTimer()
+
+	return nil
+}
+
+// scanStep probes the next ID of a scan in progress
+func (b *Modbus) scanStep() {
+	s := b.busScan
+
+	if b.busNode.busType != data.PointValueClient || b.busNode.disable {
+		b.stopScan()
+		return
+	}
+
+	baud := s.bauds[s.baudIndex]
+
+	if s.idIndex == 0 && b.isSerial() {
+		// reopen the port at the baud rate being scanned
+		b.ClosePort()
+		transport, port, err := modbusSerialTransport(b.busNode.portName,
+			b.busNode.protocol, baud)
+		if err != nil {
+			log.Println("Modbus scan: ", err)
+			b.endScan()
+			return
+		}
+		b.serialPort = port
+		b.client = modbus.NewClient(b.captureTransport(transport),
+			b.busNode.debugLevel)
+	}
+
+	if b.client == nil {
+		log.Println("Modbus scan: bus is not connected")
+		b.endScan()
+		return
+	}
+
+	id := s.ids[s.idIndex]
+
+	start := time.Now()
+	if b.client.Probe(id) {
+		var deviceID modbus.DeviceID
+		if s.deviceID {
+			// many devices do not support FC43, so errors are ignored
+			deviceID, _ = b.client.ReadDeviceID(id)
+		}
+
+		if b.busNode.debugLevel >= 1 {
+			log.Printf("Modbus scan found ID %v, baud %v: %v\n", id, baud,
+				deviceID)
+		}
+
+		p := data.Point{
+			Type:  data.PointTypeScanDevice,
+			Key:   strconv.Itoa(int(id)),
+			Value: float64(baud),
+			Text:  deviceID.String(),
+		}
+
+		// tombstones only increase, so a device cleared by a previous scan
+		// needs the next even tombstone to be visible again
+		if last, ok := b.node.Points.Find(p.Type, p.Key); ok {
+			p.Tombstone = last.Tombstone + last.Tombstone%2
+		}
+
+		b.sendScanPoint(p)
+	}
+	b.busTime += time.Since(start)
+
+	s.idIndex++
+	if s.idIndex >= len(s.ids) {
+		s.idIndex = 0
+		s.baudIndex++
+		if s.baudIndex >= len(s.bauds) {
+			b.endScan()
+		}
+	}
+}
+
+// stopScan clears the scan state
+func (b *Modbus) stopScan() {
+	if b.busScan == nil {
+		return
+	}
+
+	b.busScan = nil
+	b.sendScanPoint(data.Point{Type: data.PointTypeScanning, Value: 0})
+
+	if b.busNode.debugLevel >= 1 {
+		log.Println("Modbus scan done: ", b.busNode.portName)
+	}
+}
+
+// endScan stops the scan and restores the port settings of the bus
+func (b *Modbus) endScan() {
+	b.stopScan()
+
+	if b.isSerial() {
+		err := b.SetupPort()
+		if err != nil {
+			log.Println("Error setting up modbus port after scan: ", err)
+		}
+	}
+}
+
+func (b *Modbus) sendScanPoint(p data.Point) {
+	err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, false)
+	if err != nil {
+		log.Println("Error sending modbus scan point: ", err)
+	}
+}
+
+// ModbusScan starts a scan for devices on a client bus. Devices found are
+// reported as scanDevice points on the bus node, and the scanning point is
+// set to 0 when the scan is done.
+func ModbusScan(nc *nats.Conn, busID string, req ModbusScanRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	msg, err := nc.Request(client.SubjectModbusScan(busID), b, time.Second*20)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Data) > 0 {
+		return errors.New(string(msg.Data))
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package node_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+	"github.com/simpleiot/simpleiot/node"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+// startModbusScanBus starts a Modbus TCP gateway with one device (ID 2)
+// backed by regs, and a client bus node connected to it. Scan points sent
+// by the bus are written to the returned channel. The port is not set up
+// until a port setting changes.
+func startModbusScanBus(t *testing.T, nc *nats.Conn, regs *modbus.Regs) (data.NodeEdge, <-chan data.Point, func()) {
+	t.Helper()
+
+	gw, err := modbus.NewTCPGateway(1, "0", func(id byte, req modbus.PDU) (modbus.PDU, error) {
+		if id != 2 {
+			return modbus.PDU{}, modbus.ExcGatewayTargetFailedToRespond
+		}
+		_, resp, err := req.ProcessRequest(regs)
+		return resp, err
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go gw.Listen(func(error) {}, func() {}, func() {})
+
+	busNode := data.NodeEdge{
+		ID:   uuid.New().String(),
+		Type: data.NodeTypeModbus,
+		Points: data.Points{
+			{Type: data.PointTypeClientServer, Text: data.PointValueClient},
+			{Type: data.PointTypeProtocol, Text: data.PointValueTCP},
+			{Type: data.PointTypeURI, Text: gw.Addr().String()},
+			{Type: data.PointTypePollPeriod, Value: 1000},
+		},
+	}
+
+	chPoints := make(chan data.Point, 20)
+	sub, err := nc.Subscribe(client.SubjectNodePoints(busNode.ID), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, p := range points {
+			if p.Type == data.PointTypeScanDevice || p.Type == data.PointTypeScanning {
+				chPoints <- p
+			}
+		}
+	})
+	if err != nil {
+		gw.Close()
+		t.Fatal(err)
+	}
+
+	bus, err := node.NewModbus(nc, busNode)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		gw.Close()
+		t.Fatal(err)
+	}
+
+	return busNode, chPoints, func() {
+		bus.Stop()
+		_ = sub.Unsubscribe()
+		gw.Close()
+	}
+}
+
+// startModbusScan starts a scan, retrying until the bus is ready
+func startModbusScan(t *testing.T, nc *nats.Conn, busID string, req node.ModbusScanRequest) {
+	t.Helper()
+
+	var err error
+	for i := 0; i < 50; i++ {
+		err = node.ModbusScan(nc, busID, req)
+		if err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatal("Error starting scan: ", err)
+}
+
+func TestModbusScan(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	regs := &modbus.Regs{}
+	regs.SetDeviceID(modbus.DeviceID{
+		modbus.DeviceIDVendorName:         "SIOT",
+		modbus.DeviceIDProductCode:        "TEST",
+		modbus.DeviceIDMajorMinorRevision: "1.0",
+	})
+
+	busNode, chPoints, stopBus := startModbusScanBus(t, nc, regs)
+	defer stopBus()
+
+	// the port is set up when a port setting changes
+	uri, _ := busNode.Points.Text(data.PointTypeURI, "")
+	err = client.SendNodePoint(nc, busNode.ID, data.Point{Type: data.PointTypeURI,
+		Text: uri}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startModbusScan(t, nc, busNode.ID, node.ModbusScanRequest{IDs: "1-3", DeviceID: true})
+
+	var found []data.Point
+	timeout := time.After(5 * time.Second)
+
+done:
+	for {
+		select {
+		case p := <-chPoints:
+			if p.Type == data.PointTypeScanDevice {
+				found = append(found, p)
+			} else if p.Value == 0 {
+				break done
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for scan")
+		}
+	}
+
+	if len(found) != 1 {
+		t.Fatal("expected 1 device, got: ", found)
+	}
+
+	if found[0].Key != "2" || found[0].Text != "SIOT TEST 1.0" {
+		t.Error("wrong device: ", found[0])
+	}
+}
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// modbusMinPollPeriod keeps the scheduler from spinning if a poll period
+// is not set
+const modbusMinPollPeriod = 10 * time.Millisecond
+
+// pollPeriod returns the poll period of an IO. If the IO does not have a
+// poll period, the bus poll period is used.
+func (b *Modbus) pollPeriod(io *ModbusIO) time.Duration {
+	period := time.Millisecond * time.Duration(b.busNode.pollPeriod)
+	if io.ioNode.pollPeriod > 0 {
+		period = time.Millisecond * time.Duration(io.ioNode.pollPeriod)
+	}
+
+	if period < modbusMinPollPeriod {
+		period = modbusMinPollPeriod
+	}
+
+	return period
+}
+
+// dueIOs returns the client IOs that are due to be polled and schedules
+// their next poll. If a poll was missed because the bus is busy, the IO is
+// scheduled one period from now so that slow IOs do not pile up.
+func (b *Modbus) dueIOs(now time.Time) []*ModbusIO {
+	var ret []*ModbusIO
+	for _, io := range b.ios {
+		if io.ioNode.disable || io.nextPoll.After(now) {
+			continue
+		}
+
+		ret = append(ret, io)
+
+		period := b.pollPeriod(io)
+		if io.nextPoll.IsZero() {
+			io.nextPoll = now
+		}
+		io.nextPoll = io.nextPoll.Add(period)
+		if !io.nextPoll.After(now) {
+			io.nextPoll = now.Add(period)
+		}
+	}
+
+	return ret
+}
+
+// setScanTimer sets the scan timer to fire when the next IO is due
+func (b *Modbus) setScanTimer() {
+	b.scanTimer.Stop()
+
+	if b.busScan != nil {
+		// IO polling is suspended during a device scan
+		b.scanTimer.Reset(0)
+		return
+	}
+
+	if b.busNode.busType != data.PointValueClient || b.busNode.disable {
+		return
+	}
+
+	var next time.Time
+	for _, io := range b.ios {
+		if io.ioNode.disable {
+			continue
+		}
+		if next.IsZero() || io.nextPoll.Before(next) {
+			next = io.nextPoll
+		}
+	}
+
+	if next.IsZero() && len(b.ios) > 0 {
+		// IO has not been polled yet
+		next = time.Now()
+	}
+
+	if next.IsZero() {
+		// no IOs, check again in a bit
+		next = time.Now().Add(time.Millisecond * time.Duration(b.busNode.pollPeriod))
+	}
+
+	delay := time.Until(next)
+	if delay < 0 {
+		delay = 0
+	}
+
+	b.scanTimer.Reset(delay)
+}
+
+// scan polls all IOs that are due. Pending writes are handled between
+// each bus transaction so they are not delayed by a long scan.
+func (b *Modbus) scan() {
+	if b.busScan != nil {
+		b.scanStep()
+		return
+	}
+
+	if b.busNode.busType != data.PointValueClient || b.busNode.disable {
+		return
+	}
+
+	due := b.dueIOs(time.Now())
+
+	if b.busNode.blockReadMax > 0 {
+		for _, blk := range modbusBlocks(due, b.busNode.blockReadMax,
+			b.busNode.blockReadGap) {
+			b.checkWrites()
+			// IOs may have been disabled while handling points. If so,
+			// the block is regrouped so they are not read.
+			var ios []*ModbusIO
+			for _, io := range blk.ios {
+				if !io.ioNode.disable {
+					ios = append(ios, io)
+				}
+			}
+
+			blks := []modbusBlock{blk}
+			if len(ios) < len(blk.ios) {
+				blks = modbusBlocks(ios, b.busNode.blockReadMax,
+					b.busNode.blockReadGap)
+			}
+
+			for _, blk := range blks {
+				start := time.Now()
+				err := b.ReadBusBlock(blk)
+				b.busTime += time.Since(start)
+				if err != nil {
+					log.Println("Error reading modbus block: ", err)
+				}
+			}
+		}
+	} else {
+		for _, io := range due {
+			b.checkWrites()
+			// io may have been disabled while handling points
+			if io.ioNode.disable {
+				continue
+			}
+			start := time.Now()
+			err := b.ClientIO(io)
+			b.busTime += time.Since(start)
+			if err != nil {
+				err := b.LogError(io.ioNode, err)
+				if err != nil {
+					log.Println("Error logging modbus error: ", err)
+				}
+			}
+		}
+	}
+}
+
+// checkWrites handles any points that have arrived (typically valueSet
+// changes), which causes pending writes to be sent before the next read.
+// Gateway requests are also handled here so they are not delayed by a long
+// scan.
+func (b *Modbus) checkWrites() {
+	for {
+		select {
+		case point := <-b.chPoint:
+			b.handlePoint(point)
+		case r := <-b.chGateway:
+			b.handleGateway(r)
+		default:
+			return
+		}
+	}
+}
+
+// clientWrite writes the set value of an IO immediately
+func (b *Modbus) clientWrite(io *ModbusIO) {
+	if b.client == nil {
+		return
+	}
+
+	start := time.Now()
+	err := b.ClientWrite(io)
+	b.busTime += time.Since(start)
+	if err != nil {
+		err := b.LogError(io.ioNode, err)
+		if err != nil {
+			log.Println("Error logging error: ", err)
+		}
+	}
+}
+
+// reportUtilization sends the percentage of time the bus was busy since
+// the last report. This is reported once a minute.
+func (b *Modbus) reportUtilization() {
+	now := time.Now()
+	if b.busTimeStart.IsZero() {
+		b.busTimeStart = now
+		b.busTime = 0
+		return
+	}
+
+	elapsed := now.Sub(b.busTimeStart)
+	if elapsed < time.Minute {
+		return
+	}
+
+	utilization := float64(b.busTime) / float64(elapsed) * 100
+	b.busTimeStart = now
+	b.busTime = 0
+
+	if b.busNode.busType != data.PointValueClient {
+		return
+	}
+
+	p := data.Point{Type: data.PointTypeBusUtilization, Value: utilization}
+	err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, false)
+	if err != nil {
+		log.Println("Error sending modbus bus utilization: ", err)
+	}
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModbusDueIOs(t *testing.T) {
+	fast := &ModbusIO{ioNode: &ModbusIONode{nodeID: "fast", pollPeriod: 100}}
+	slow := &ModbusIO{ioNode: &ModbusIONode{nodeID: "slow"}}
+	disabled := &ModbusIO{ioNode: &ModbusIONode{nodeID: "disabled", disable: true}}
+
+	b := &Modbus{
+		busNode: &ModbusNode{pollPeriod: 1000},
+		ios: map[string]*ModbusIO{
+			"fast":     fast,
+			"slow":     slow,
+			"disabled": disabled,
+		},
+	}
+
+	start := time.Now()
+
+	count := func(now time.Time) map[string]bool {
+		ret := make(map[string]bool)
+		for _, io := range b.dueIOs(now) {
+			ret[io.ioNode.nodeID] = true
+		}
+		return ret
+	}
+
+	due := count(start)
+	if len(due) != 2 || !due["fast"] || !due["slow"] {
+		t.Fatal("expected fast and slow IOs on first scan, got: ", due)
+	}
+
+	due = count(start.Add(50 * time.Millisecond))
+	if len(due) != 0 {
+		t.Fatal("expected no IOs, got: ", due)
+	}
+
+	due = count(start.Add(100 * time.Millisecond))
+	if len(due) != 1 || !due["fast"] {
+		t.Fatal("expected fast IO, got: ", due)
+	}
+
+	// bus was busy and missed several fast polls
+	due = count(start.Add(1000 * time.Millisecond))
+	if len(due) != 2 {
+		t.Fatal("expected fast and slow IOs, got: ", due)
+	}
+
+	if !fast.nextPoll.Equal(start.Add(1100 * time.Millisecond)) {
+		t.Fatal("fast IO not rescheduled from now: ", fast.nextPoll.Sub(start))
+	}
+}
@@ -0,0 +1,97 @@
+package node_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/node"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestModbusApplyTemplate(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	// the node manager starts after the test server returns
+	var templates []node.ModbusTemplate
+	for i := 0; i < 50; i++ {
+		templates, err = node.ModbusListTemplates(nc)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatal("Error listing templates: ", err)
+	}
+
+	if len(templates) < 1 {
+		t.Fatal("no templates")
+	}
+
+	bus := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeModbus,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "bus"},
+			{Type: data.PointTypeDisable, Value: 1},
+		},
+	}
+
+	err = client.SendNode(nc, bus, "test")
+	if err != nil {
+		t.Fatal("Error creating bus: ", err)
+	}
+
+	err = node.ModbusApplyTemplate(nc, bus.ID, "eastron-sdm120", 5)
+	if err != nil {
+		t.Fatal("Error applying template: ", err)
+	}
+
+	ios, err := client.GetNodes(nc, bus.ID, "all", data.NodeTypeModbusIO, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exp int
+	for _, tmpl := range templates {
+		if tmpl.ID == "eastron-sdm120" {
+			exp = len(tmpl.IOs)
+		}
+	}
+
+	if len(ios) != exp {
+		t.Fatalf("expected %v IOs, got %v", exp, len(ios))
+	}
+
+	id, _ := ios[0].Points.ValueInt(data.PointTypeID, "")
+	if id != 5 {
+		t.Error("wrong modbus ID: ", id)
+	}
+
+	err = node.ModbusApplyTemplate(nc, bus.ID, "not-found", 5)
+	if err == nil {
+		t.Error("expected error for unknown template")
+	}
+
+	for _, id := range []int{0, 248} {
+		err = node.ModbusApplyTemplate(nc, bus.ID, "eastron-sdm120", id)
+		if err == nil {
+			t.Error("expected error for modbus ID: ", id)
+		}
+	}
+
+	err = node.ModbusApplyTemplate(nc, "unknown-bus", "eastron-sdm120", 5)
+	if err == nil {
+		t.Error("expected error for unknown bus")
+	}
+}
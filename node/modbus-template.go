@@ -0,0 +1,416 @@
+package node
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+	"gopkg.in/yaml.v2"
+)
+
+// modbusTemplateFS contains the device templates that ship with SIOT
+//
+//go:embed modbus-templates/*.yaml
+var modbusTemplateFS embed.FS
+
+// ModbusTemplate describes the IOs of a Modbus device. Templates are YAML
+// or JSON files. The template ID is the file name without the extension.
+type ModbusTemplate struct {
+	ID          string             `json:"id" yaml:"-"`
+	Name        string             `json:"name" yaml:"name"`
+	Description string             `json:"description" yaml:"description"`
+	IOs         []ModbusTemplateIO `json:"ios" yaml:"ios"`
+}
+
+// ModbusTemplateIO describes one IO in a device template. If scale is not
+// set, it defaults to 1.
+type ModbusTemplateIO struct {
+	Description string  `json:"description" yaml:"description"`
+	IOType      string  `json:"ioType" yaml:"ioType"`
+	Address     int     `json:"address" yaml:"address"`
+	DataFormat  string  `json:"dataFormat" yaml:"dataFormat"`
+	ByteOrder   string  `json:"byteOrder" yaml:"byteOrder"`
+	Bit         int     `json:"bit" yaml:"bit"`
+	Count       int     `json:"count" yaml:"count"`
+	Scale       float64 `json:"scale" yaml:"scale"`
+	Offset      float64 `json:"offset" yaml:"offset"`
+	Units       string  `json:"units" yaml:"units"`
+	ReadOnly    bool    `json:"readOnly" yaml:"readOnly"`
+	PollPeriod  int     `json:"pollPeriod" yaml:"pollPeriod"`
+}
+
+// ModbusTemplateRequest is sent to instantiate a template on a bus
+type ModbusTemplateRequest struct {
+	Template string `json:"template"`
+	// Modbus ID of the device
+	ID int `json:"id"`
+}
+
+// modbusTemplateDir returns the directory user templates are loaded from
+func modbusTemplateDir() string {
+	dataDir := os.Getenv("SIOT_DATA")
+	if dataDir == "" {
+		dataDir = "./"
+	}
+
+	return filepath.Join(dataDir, "modbus-templates")
+}
+
+// parseModbusTemplate parses and validates a template file
+func parseModbusTemplate(fileName string, b []byte) (ModbusTemplate, error) {
+	var t ModbusTemplate
+	var err error
+
+	ext := path.Ext(fileName)
+
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &t)
+	case ".json":
+		err = json.Unmarshal(b, &t)
+	default:
+		return t, fmt.Errorf("unsupported template file type: %v", fileName)
+	}
+
+	if err != nil {
+		return t, fmt.Errorf("error parsing template %v: %w", fileName, err)
+	}
+
+	t.ID = strings.TrimSuffix(path.Base(fileName), ext)
+
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+
+	for i, io := range t.IOs {
+		switch io.IOType {
+		case data.PointValueModbusCoil, data.PointValueModbusDiscreteInput:
+		case data.PointValueModbusHoldingRegister, data.PointValueModbusInputRegister:
+			switch io.DataFormat {
+			case data.PointValueUINT16, data.PointValueINT16,
+				data.PointValueUINT32, data.PointValueINT32,
+				data.PointValueFLOAT32, data.PointValueUINT64,
+				data.PointValueINT64, data.PointValueFLOAT64,
+				data.PointValueString, data.PointValueBit:
+			case "":
+				return t, fmt.Errorf("template %v, io %v: data format must be set",
+					t.ID, i)
+			default:
+				return t, fmt.Errorf("template %v, io %v: invalid data format: %v",
+					t.ID, i, io.DataFormat)
+			}
+		default:
+			return t, fmt.Errorf("template %v, io %v: invalid io type: %v",
+				t.ID, i, io.IOType)
+		}
+
+		if io.Address < 0 || io.Address > 0xffff {
+			return t, fmt.Errorf("template %v, io %v: invalid address: %v",
+				t.ID, i, io.Address)
+		}
+
+		switch io.ByteOrder {
+		case "", modbus.ByteOrderABCD, modbus.ByteOrderCDAB,
+			modbus.ByteOrderBADC, modbus.ByteOrderDCBA:
+		default:
+			return t, fmt.Errorf("template %v, io %v: invalid byte order: %v",
+				t.ID, i, io.ByteOrder)
+		}
+
+		switch io.DataFormat {
+		case data.PointValueString:
+			if io.Count <= 0 {
+				return t, fmt.Errorf("template %v, io %v: string count must be set",
+					t.ID, i)
+			}
+		case data.PointValueBit:
+			if io.Bit < 0 || io.Bit > 15 {
+				return t, fmt.Errorf("template %v, io %v: bit must be 0-15: %v",
+					t.ID, i, io.Bit)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// ModbusTemplates returns all available device templates sorted by ID.
+// Templates embedded in SIOT are loaded first, followed by user templates
+// in $SIOT_DATA/modbus-templates. A user template replaces an embedded
+// template with the same ID. Invalid user templates are logged and
+// skipped.
+func ModbusTemplates() ([]ModbusTemplate, error) {
+	templates := make(map[string]ModbusTemplate)
+
+	embedded, err := fs.Glob(modbusTemplateFS, "modbus-templates/*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range embedded {
+		b, err := modbusTemplateFS.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseModbusTemplate(f, b)
+		if err != nil {
+			return nil, err
+		}
+		templates[t.ID] = t
+	}
+
+	files, err := os.ReadDir(modbusTemplateDir())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println("Error reading modbus template dir: ", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(modbusTemplateDir(), f.Name()))
+		if err != nil {
+			log.Println("Error reading modbus template: ", err)
+			continue
+		}
+
+		t, err := parseModbusTemplate(f.Name(), b)
+		if err != nil {
+			log.Println("Modbus template: ", err)
+			continue
+		}
+		templates[t.ID] = t
+	}
+
+	ret := make([]ModbusTemplate, 0, len(templates))
+	for _, t := range templates {
+		ret = append(ret, t)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, nil
+}
+
+// nodes returns the modbusIo nodes for a device with Modbus ID id
+func (t ModbusTemplate) nodes(busID string, id int) []data.NodeEdge {
+	ret := make([]data.NodeEdge, len(t.IOs))
+
+	now := time.Now()
+
+	for i, io := range t.IOs {
+		scale := io.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		points := data.Points{
+			{Type: data.PointTypeDescription, Text: io.Description},
+			{Type: data.PointTypeID, Value: float64(id)},
+			{Type: data.PointTypeAddress, Value: float64(io.Address)},
+			{Type: data.PointTypeModbusIOType, Text: io.IOType},
+			{Type: data.PointTypeReadOnly, Value: data.BoolToFloat(io.ReadOnly)},
+		}
+
+		if io.IOType == data.PointValueModbusHoldingRegister ||
+			io.IOType == data.PointValueModbusInputRegister {
+			points = append(points,
+				data.Point{Type: data.PointTypeDataFormat, Text: io.DataFormat},
+				data.Point{Type: data.PointTypeScale, Value: scale},
+				data.Point{Type: data.PointTypeOffset, Value: io.Offset},
+			)
+		}
+
+		if io.ByteOrder != "" {
+			points = append(points,
+				data.Point{Type: data.PointTypeByteOrder, Text: io.ByteOrder})
+		}
+
+		if io.DataFormat == data.PointValueBit {
+			points = append(points,
+				data.Point{Type: data.PointTypeBit, Value: float64(io.Bit)})
+		}
+
+		if io.DataFormat == data.PointValueString {
+			points = append(points,
+				data.Point{Type: data.PointTypeCount, Value: float64(io.Count)})
+		}
+
+		if io.Units != "" {
+			points = append(points,
+				data.Point{Type: data.PointTypeUnits, Text: io.Units})
+		}
+
+		if io.PollPeriod > 0 {
+			points = append(points,
+				data.Point{Type: data.PointTypePollPeriod, Value: float64(io.PollPeriod)})
+		}
+
+		for j := range points {
+			points[j].Time = now
+		}
+
+		ret[i] = data.NodeEdge{
+			ID:     uuid.New().String(),
+			Type:   data.NodeTypeModbusIO,
+			Parent: busID,
+			Points: points,
+		}
+	}
+
+	return ret
+}
+
+// handleTemplates responds with a list of available templates
+func (mm *ModbusManager) handleTemplates(msg *nats.Msg) {
+	templates, err := ModbusTemplates()
+	if err != nil {
+		log.Println("Error loading modbus templates: ", err)
+	}
+
+	b, err := json.Marshal(templates)
+	if err != nil {
+		log.Println("Error encoding modbus templates: ", err)
+	}
+
+	err = msg.Respond(b)
+	if err != nil {
+		log.Println("Error responding to modbus templates request: ", err)
+	}
+}
+
+// handleTemplate instantiates a template as modbusIo nodes under a bus
+func (mm *ModbusManager) handleTemplate(msg *nats.Msg) {
+	err := func() error {
+		// subject is modbus.<busID>.template
+		parts := strings.Split(msg.Subject, ".")
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid subject: %v", msg.Subject)
+		}
+		busID := parts[1]
+
+		var req ModbusTemplateRequest
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			return fmt.Errorf("error decoding request: %w", err)
+		}
+
+		// 0 is the broadcast address and 248-255 are reserved
+		if req.ID < 1 || req.ID > 247 {
+			return fmt.Errorf("invalid modbus ID, must be 1-247: %v", req.ID)
+		}
+
+		nodes, err := client.GetNodes(mm.nc, "all", busID, data.NodeTypeModbus, false)
+		if err != nil {
+			return err
+		}
+
+		if len(nodes) < 1 {
+			return fmt.Errorf("modbus bus not found: %v", busID)
+		}
+
+		templates, err := ModbusTemplates()
+		if err != nil {
+			return err
+		}
+
+		for _, t := range templates {
+			if t.ID != req.Template {
+				continue
+			}
+
+			// templates are validated when loaded, so this only fails
+			// if the store does. Remove the IOs already created so a
+			// partial device is not left on the bus.
+			var created []data.NodeEdge
+			for _, n := range t.nodes(busID, req.ID) {
+				err := client.SendNode(mm.nc, n, "")
+				if err == nil {
+					created = append(created, n)
+					continue
+				}
+
+				var left []string
+				for _, c := range created {
+					desc, _ := c.Points.Text(data.PointTypeDescription, "")
+					dErr := client.DeleteNode(mm.nc, c.ID, c.Parent, "")
+					if dErr != nil {
+						log.Println("Error removing modbus template IO: ", dErr)
+						left = append(left, desc)
+					}
+				}
+
+				if len(left) > 0 {
+					return fmt.Errorf("error creating IOs: %w, IOs created: %v",
+						err, strings.Join(left, ", "))
+				}
+
+				return fmt.Errorf("error creating IOs: %w", err)
+			}
+
+			return nil
+		}
+
+		return fmt.Errorf("modbus template not found: %v", req.Template)
+	}()
+
+	var ret string
+	if err != nil {
+		log.Println("Modbus template error: ", err)
+		ret = err.Error()
+	}
+
+	err = msg.Respond([]byte(ret))
+	if err != nil {
+		log.Println("Error responding to modbus template request: ", err)
+	}
+}
+
+// ModbusListTemplates requests the available device templates
+func ModbusListTemplates(nc *nats.Conn) ([]ModbusTemplate, error) {
+	msg, err := nc.Request(client.SubjectModbusTemplates(), nil, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []ModbusTemplate
+	err = json.Unmarshal(msg.Data, &ret)
+	return ret, err
+}
+
+// ModbusApplyTemplate creates modbusIo nodes under a bus for each IO in a
+// template. id is the Modbus ID of the device.
+func ModbusApplyTemplate(nc *nats.Conn, busID, template string, id int) error {
+	req, err := json.Marshal(ModbusTemplateRequest{Template: template, ID: id})
+	if err != nil {
+		return err
+	}
+
+	msg, err := nc.Request(client.SubjectModbusTemplate(busID), req, time.Second*20)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Data) > 0 {
+		return errors.New(string(msg.Data))
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModbusTemplates(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SIOT_DATA", dir)
+
+	err := os.Mkdir(filepath.Join(dir, "modbus-templates"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		// replaces the embedded template
+		"xy-md02.json": `{"name": "custom", "ios": [{"description": "Temp",
+			"ioType": "modbusHoldingRegister", "address": 5,
+			"dataFormat": "string", "count": 4}]}`,
+		"relay.yml": `
+ios:
+  - description: Relay 1
+    ioType: modbusCoil
+    address: 0
+`,
+		// invalid, skipped
+		"bad.yaml": `
+ios:
+  - ioType: modbusHoldingRegister
+`,
+		"bad-string.yaml": `
+ios:
+  - ioType: modbusHoldingRegister
+    dataFormat: string
+`,
+		"bad-bit.json": `{"ios": [{"ioType": "modbusInputRegister",
+			"dataFormat": "bit", "bit": 16}]}`,
+		"bad-format.yaml": `
+ios:
+  - ioType: modbusHoldingRegister
+    dataFormat: float
+`,
+		"bad-order.yaml": `
+ios:
+  - ioType: modbusHoldingRegister
+    dataFormat: float32
+    byteOrder: dcab
+`,
+	}
+
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, "modbus-templates", name),
+			[]byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := ModbusTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]ModbusTemplate)
+	for _, tmpl := range templates {
+		found[tmpl.ID] = tmpl
+	}
+
+	for _, id := range []string{"eastron-sdm120", "eastron-sdm630", "relay", "xy-md02"} {
+		if _, ok := found[id]; !ok {
+			t.Error("template not found: ", id)
+		}
+	}
+
+	for _, id := range []string{"bad", "bad-string", "bad-bit", "bad-format", "bad-order"} {
+		if _, ok := found[id]; ok {
+			t.Error("invalid template should be skipped: ", id)
+		}
+	}
+
+	if found["xy-md02"].Name != "custom" {
+		t.Error("user template did not replace embedded template")
+	}
+
+	if found["relay"].Name != "relay" {
+		t.Error("name should default to ID: ", found["relay"].Name)
+	}
+
+	tmpl := found["eastron-sdm120"]
+	nodes := tmpl.nodes("bus", 3)
+
+	if len(nodes) != len(tmpl.IOs) {
+		t.Fatal("wrong number of nodes: ", len(nodes))
+	}
+
+	n := nodes[0]
+	if n.Type != data.NodeTypeModbusIO || n.Parent != "bus" || n.ID == "" {
+		t.Fatalf("wrong node: %+v", n)
+	}
+
+	io, err := NewModbusIONode(data.PointValueClient, &n)
+	if err != nil {
+		t.Fatal("template node is not a valid modbus IO: ", err)
+	}
+
+	if io.id != 3 || io.address != 0 || io.modbusDataType != data.PointValueFLOAT32 ||
+		io.scale != 1 {
+		t.Errorf("wrong IO: %+v", io)
+	}
+
+	units, _ := n.Points.Text(data.PointTypeUnits, "")
+	if units != "V" {
+		t.Error("wrong units: ", units)
+	}
+
+	n = found["xy-md02"].nodes("bus", 1)[0]
+	io, err = NewModbusIONode(data.PointValueClient, &n)
+	if err != nil {
+		t.Fatal("string IO is not valid: ", err)
+	}
+
+	if io.count != 4 {
+		t.Error("wrong string count: ", io.count)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -37,26 +39,48 @@ type Modbus struct {
 	// data associated with running the bus
 	nc           *nats.Conn
 	sub          *nats.Subscription
+	subScan      *nats.Subscription
 	regs         *modbus.Regs
 	client       *modbus.Client
 	server       server
 	serialPort   serial.Port
+	capture      *modbus.CaptureFile
 	ioErrorCount int
 
 	chDone      chan bool
 	chPoint     chan pointWID
-	chRegChange chan bool
+	chRegChange chan modbus.RegChange
+	chGateway   chan modbusGatewayRequest
+	chScan      chan modbusScanRequest
+
+	// TCP server that forwards requests to devices on a client bus
+	gateway *modbus.TCPGateway
+
+	// IOs are polled when due (see modbus-schedule.go), and the time
+	// spent on bus transactions is used to report bus utilization
+	scanTimer    *time.Timer
+	busTime      time.Duration
+	busTimeStart time.Time
+
+	// scan for devices in progress (see modbus-scan.go)
+	busScan *modbusBusScan
 }
 
 // NewModbus creates a new bus from a node
 func NewModbus(nc *nats.Conn, node data.NodeEdge) (*Modbus, error) {
+	scanTimer := time.NewTimer(time.Hour)
+	scanTimer.Stop()
+
 	bus := &Modbus{
+		scanTimer:   scanTimer,
 		nc:          nc,
 		node:        node,
 		ios:         make(map[string]*ModbusIO),
 		chDone:      make(chan bool),
 		chPoint:     make(chan pointWID),
-		chRegChange: make(chan bool),
+		chRegChange: make(chan modbus.RegChange),
+		chGateway:   make(chan modbusGatewayRequest),
+		chScan:      make(chan modbusScanRequest),
 	}
 
 	modbusNode, err := NewModbusNode(node)
@@ -86,6 +110,12 @@ func NewModbus(nc *nats.Conn, node data.NodeEdge) (*Modbus, error) {
 		return nil, err
 	}
 
+	bus.subScan, err = nc.Subscribe(client.SubjectModbusScan(bus.busNode.nodeID),
+		bus.handleScanRequest)
+	if err != nil {
+		return nil, err
+	}
+
 	go bus.Run()
 
 	return bus, nil
@@ -99,6 +129,12 @@ func (b *Modbus) Stop() {
 			log.Println("Error unsubscribing from bus: ", err)
 		}
 	}
+	if b.subScan != nil {
+		err := b.subScan.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing from bus scan: ", err)
+		}
+	}
 	for _, io := range b.ios {
 		io.Stop()
 	}
@@ -161,66 +197,63 @@ func (b *Modbus) SendPoint(nodeID, pointType string, value float64) error {
 	return client.SendNodePoint(b.nc, nodeID, p, true)
 }
 
+// sendText sends a text point
+func (b *Modbus) sendText(nodeID, pointType, text string) error {
+	p := data.Point{
+		Type: pointType,
+		Text: text,
+	}
+
+	return client.SendNodePoint(b.nc, nodeID, p, true)
+}
+
 // WriteBusHoldingReg used to write register values to bus
-// should only be used by client
+// should only be used by client. Values that span multiple registers are
+// written with one write multiple registers request so that the device
+// updates them atomically.
 func (b *Modbus) WriteBusHoldingReg(io *ModbusIONode) error {
-	unscaledValue := (io.valueSet - io.offset) / io.scale
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), uint16(unscaledValue))
-		if err != nil {
-			return err
-		}
-	case data.PointValueUINT32:
-		regs := modbus.Uint32ToRegs([]uint32{uint32(unscaledValue)})
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
-
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
-		if err != nil {
-			return err
-		}
+	if io.modbusDataType == data.PointValueBit {
+		return b.writeBusBit(io)
+	}
 
-	case data.PointValueINT32:
-		regs := modbus.Int32ToRegs([]int32{int32(unscaledValue)})
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
+	regs, err := encodeRegs(io, io.valueSet, io.valueSetText)
+	if err != nil {
+		return err
+	}
 
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
-		if err != nil {
-			return err
-		}
+	if len(regs) == 1 {
+		return b.client.WriteSingleReg(byte(io.id), uint16(io.address), regs[0])
+	}
 
-	case data.PointValueFLOAT32:
-		regs := modbus.Float32ToRegs([]float32{float32(unscaledValue)})
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
+	return b.client.WriteMultipleRegs(byte(io.id), uint16(io.address), regs)
+}
 
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
-		if err != nil {
-			return err
-		}
+// writeBusBit sets or clears one bit of a holding register with the mask
+// write register function. If the device does not support this function,
+// the register is read, modified, and written.
+func (b *Modbus) writeBusBit(io *ModbusIONode) error {
+	id, address := byte(io.id), uint16(io.address)
+	mask := uint16(1) << uint(io.bit)
+	var set uint16
+	if data.FloatToBool(io.valueSet) {
+		set = mask
+	}
 
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
+	err := b.client.MaskWriteReg(id, address, ^mask, set)
+	var exc modbus.ExceptionCode
+	if !errors.As(err, &exc) || exc != modbus.ExcIllegalFunction {
+		return err
+	}
 
+	regs, err := b.client.ReadHoldingRegs(id, address, 1)
+	if err != nil {
+		return err
+	}
+	if len(regs) < 1 {
+		return errors.New("Did not receive enough data")
 	}
 
-	return nil
+	return b.client.WriteSingleReg(id, address, regs[0]&^mask|set)
 }
 
 // ReadBusReg reads an io value from a reg from bus
@@ -235,62 +268,54 @@ func (b *Modbus) ReadBusReg(io *ModbusIO) error {
 		return fmt.Errorf("ReadBusReg: unsupported modbus IO type: %v",
 			io.ioNode.modbusIOType)
 	}
-	var valueUnscaled float64
-	switch io.ioNode.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 1)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 1 {
-			return errors.New("Did not receive enough data")
-		}
-		valueUnscaled = float64(regs[0])
 
-	case data.PointValueUINT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		v := modbus.RegsToUint32(regs)
+	count := regCount(io.ioNode)
+	regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), uint16(count))
+	if err != nil {
+		return err
+	}
 
-		valueUnscaled = float64(v[0])
+	return b.UpdateRegValue(io, regs)
+}
 
-	case data.PointValueINT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		v := modbus.RegsToInt32(regs)
+// UpdateRegValue decodes an io value from regs read from the bus, and
+// sends the value if it changed. regs starts at the io address.
+func (b *Modbus) UpdateRegValue(io *ModbusIO, regs []uint16) error {
+	value, text, err := decodeRegs(io.ioNode, regs)
+	if err != nil {
+		return err
+	}
 
-		valueUnscaled = float64(v[0])
+	if io.ioNode.modbusDataType == data.PointValueString {
+		return b.updateText(io, text)
+	}
+
+	return b.updateValue(io, value)
+}
 
-	case data.PointValueFLOAT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
+// updateValue sends an io value read from the bus if it changed, or if it
+// has not been sent for a while
+func (b *Modbus) updateValue(io *ModbusIO, value float64) error {
+	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 {
+		io.ioNode.value = value
+		err := b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, value)
 		if err != nil {
 			return err
 		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		valueUnscaled = float64(modbus.RegsToFloat32(regs)[0])
-
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.ioNode.modbusDataType)
+		io.lastSent = time.Now()
 	}
 
-	value := valueUnscaled*io.ioNode.scale + io.ioNode.offset
+	io.ioNode.value = value
 
-	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 {
-		io.ioNode.value = value
-		err := b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, value)
+	return nil
+}
+
+// updateText sends a string io value read from the bus if it changed, or
+// if it has not been sent for a while
+func (b *Modbus) updateText(io *ModbusIO, text string) error {
+	if text != io.ioNode.valueText || time.Since(io.lastSent) > time.Minute*10 {
+		io.ioNode.valueText = text
+		err := b.sendText(io.ioNode.nodeID, data.PointTypeValue, text)
 		if err != nil {
 			return err
 		}
@@ -320,21 +345,7 @@ func (b *Modbus) ReadBusBit(io *ModbusIO) error {
 		return errors.New("Did not receive enough data")
 	}
 
-	value := data.BoolToFloat(bits[0])
-
-	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 {
-		io.ioNode.value = value
-		err := b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, value)
-		if err != nil {
-			return err
-		}
-
-		io.lastSent = time.Now()
-	}
-
-	io.ioNode.value = value
-
-	return nil
+	return b.updateValue(io, data.BoolToFloat(bits[0]))
 }
 
 // ClientIO processes an IO on a client bus
@@ -346,65 +357,67 @@ func (b *Modbus) ClientIO(io *ModbusIO) error {
 
 	// read value from remote device and update regs
 	switch io.ioNode.modbusIOType {
-	case data.PointValueModbusCoil:
+	case data.PointValueModbusCoil, data.PointValueModbusDiscreteInput:
 		err := b.ReadBusBit(io)
 		if err != nil {
 			return err
 		}
 
-		if !io.ioNode.readOnly && io.ioNode.valueSet != io.ioNode.value {
-			vBool := data.FloatToBool(io.ioNode.valueSet)
-			// we need set the remote value
-			err := b.client.WriteSingleCoil(byte(io.ioNode.id), uint16(io.ioNode.address),
-				vBool)
-
-			if err != nil {
-				return err
-			}
-
-			err = b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSet)
-			if err != nil {
-				return err
-			}
-		}
-
-	case data.PointValueModbusDiscreteInput:
-		err := b.ReadBusBit(io)
-		if err != nil {
-			return err
-		}
-
-	case data.PointValueModbusHoldingRegister:
+	case data.PointValueModbusHoldingRegister, data.PointValueModbusInputRegister:
 		err := b.ReadBusReg(io)
 		if err != nil {
 			return err
 		}
 
-		if !io.ioNode.readOnly && io.ioNode.valueSet != io.ioNode.value {
-			// we need set the remote value
-			err := b.WriteBusHoldingReg(io.ioNode)
+	default:
+		return fmt.Errorf("unhandled modbus io type, io: %+v", io)
+	}
 
-			if err != nil {
-				return err
-			}
+	return b.ClientWrite(io)
+}
 
-			err = b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSet)
-			if err != nil {
-				return err
-			}
+// ClientWrite writes the set value of an IO to the bus if it differs from
+// the value last read. This should only be called from client.
+func (b *Modbus) ClientWrite(io *ModbusIO) error {
+	if io.ioNode.readOnly {
+		return nil
+	}
+
+	isString := io.ioNode.modbusDataType == data.PointValueString
+
+	if isString && io.ioNode.valueSetText == io.ioNode.valueText ||
+		!isString && io.ioNode.valueSet == io.ioNode.value {
+		return nil
+	}
+
+	switch io.ioNode.modbusIOType {
+	case data.PointValueModbusCoil:
+		vBool := data.FloatToBool(io.ioNode.valueSet)
+		// we need set the remote value
+		err := b.client.WriteSingleCoil(byte(io.ioNode.id), uint16(io.ioNode.address),
+			vBool)
+
+		if err != nil {
+			return err
 		}
 
-	case data.PointValueModbusInputRegister:
-		err := b.ReadBusReg(io)
+	case data.PointValueModbusHoldingRegister:
+		// we need set the remote value
+		err := b.WriteBusHoldingReg(io.ioNode)
+
 		if err != nil {
 			return err
 		}
 
 	default:
-		return fmt.Errorf("unhandled modbus io type, io: %+v", io)
+		return nil
 	}
 
-	return nil
+	if isString {
+		return b.sendText(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSetText)
+	}
+
+	return b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSet)
 }
 
 // ServerIO processes an IO on a server bus
@@ -412,7 +425,7 @@ func (b *Modbus) ServerIO(io *ModbusIONode) error {
 	// update regs with db value
 	switch io.modbusIOType {
 	case data.PointValueModbusDiscreteInput:
-		err := b.regs.WriteCoil(io.address, data.FloatToBool(io.value))
+		err := b.regs.WriteDiscreteInput(io.address, data.FloatToBool(io.value))
 		if err != nil {
 			return err
 		}
@@ -438,6 +451,24 @@ func (b *Modbus) ServerIO(io *ModbusIONode) error {
 		}
 
 	case data.PointValueModbusHoldingRegister:
+		if io.modbusDataType == data.PointValueString {
+			regs, err := b.regs.ReadRegs(io.address, regCount(io))
+			if err != nil {
+				return err
+			}
+
+			_, text, err := decodeRegs(io, regs)
+			if err != nil {
+				return err
+			}
+
+			if io.valueText != text {
+				return b.sendText(io.nodeID, data.PointTypeValue, text)
+			}
+
+			return nil
+		}
+
 		v, err := b.ReadReg(io)
 		if err != nil {
 			return err
@@ -457,20 +488,6 @@ func (b *Modbus) ServerIO(io *ModbusIONode) error {
 	return nil
 }
 
-func regCount(regType string) int {
-	switch regType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		return 1
-	case data.PointValueUINT32, data.PointValueINT32,
-		data.PointValueFLOAT32:
-		return 2
-	default:
-		log.Println("regCount, unknown data type: ", regType)
-		// be conservative
-		return 2
-	}
-}
-
 // InitRegs is used in server mode to initilize the internal modbus regs when a IO changes
 func (b *Modbus) InitRegs(io *ModbusIONode) {
 	if b.server == nil {
@@ -481,10 +498,10 @@ func (b *Modbus) InitRegs(io *ModbusIONode) {
 	// another device so that we preserve the last known state
 	switch io.modbusIOType {
 	case data.PointValueModbusDiscreteInput:
-		b.regs.AddCoil(io.address)
-		err := b.regs.WriteCoil(io.address, data.FloatToBool(io.value))
+		b.regs.AddDiscreteInput(io.address)
+		err := b.regs.WriteDiscreteInput(io.address, data.FloatToBool(io.value))
 		if err != nil {
-			log.Println("Error writing coil: ", err)
+			log.Println("Error writing discrete input: ", err)
 		}
 	case data.PointValueModbusCoil:
 		b.regs.AddCoil(io.address)
@@ -492,90 +509,78 @@ func (b *Modbus) InitRegs(io *ModbusIONode) {
 		if err != nil {
 			log.Println("Error writing coil: ", err)
 		}
+		// read-only coils can't be written by clients
+		err = b.regs.SetCoilsReadOnly(io.address, 1, io.readOnly)
+		if err != nil {
+			log.Println("Error setting coil read-only: ", err)
+		}
 	case data.PointValueModbusInputRegister:
-		b.regs.AddReg(io.address, regCount(io.modbusDataType))
+		b.regs.AddInputReg(io.address, regCount(io))
 		err := b.WriteReg(io)
 		if err != nil {
 			log.Println("Error writing reg: ", err)
 		}
 	case data.PointValueModbusHoldingRegister:
-		b.regs.AddReg(io.address, regCount(io.modbusDataType))
+		b.regs.AddReg(io.address, regCount(io))
 		err := b.WriteReg(io)
 		if err != nil {
 			log.Println("Error writing reg: ", err)
 		}
+		err = b.regs.SetRegsReadOnly(io.address, regCount(io), io.readOnly)
+		if err != nil {
+			log.Println("Error setting reg read-only: ", err)
+		}
+	}
+}
+
+// regChanged returns true if a client write changed the coil or registers
+// of an IO
+func regChanged(io *ModbusIONode, c modbus.RegChange) bool {
+	switch io.modbusIOType {
+	case data.PointValueModbusCoil:
+		return c.Table == modbus.TableCoils && c.Overlaps(io.address, 1)
+	case data.PointValueModbusHoldingRegister:
+		return c.Table == modbus.TableHoldingRegs &&
+			c.Overlaps(io.address, regCount(io))
 	}
+
+	return false
 }
 
 // ReadReg reads an value from a reg (internal, not bus)
 // This should only be used on server
 func (b *Modbus) ReadReg(io *ModbusIONode) (float64, error) {
-	var valueUnscaled float64
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		v, err := b.regs.ReadReg(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueUINT32:
-		v, err := b.regs.ReadRegUint32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueINT32:
-		v, err := b.regs.ReadRegInt32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueFLOAT32:
-		v, err := b.regs.ReadRegFloat32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	default:
-		return 0, fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
+	regs, err := b.regs.ReadRegs(io.address, regCount(io))
+	if err != nil {
+		return 0, err
 	}
-	return valueUnscaled*io.scale + io.offset, nil
+
+	v, _, err := decodeRegs(io, regs)
+	return v, err
 }
 
-// WriteReg writes an io value to a reg
+// WriteReg writes an io value to an input or holding reg
 // This should only be used on server
 func (b *Modbus) WriteReg(io *ModbusIONode) error {
-	unscaledValue := (io.value - io.offset) / io.scale
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		err := b.regs.WriteReg(io.address, uint16(unscaledValue))
-		if err != nil {
-			return err
-		}
-	case data.PointValueUINT32:
-		err := b.regs.WriteRegUint32(io.address,
-			uint32(unscaledValue))
-		if err != nil {
-			return err
-		}
-	case data.PointValueINT32:
-		err := b.regs.WriteRegInt32(io.address,
-			int32(unscaledValue))
-		if err != nil {
-			return err
-		}
-	case data.PointValueFLOAT32:
-		err := b.regs.WriteRegFloat32(io.address,
-			float32(unscaledValue))
-		if err != nil {
-			return err
+	input := io.modbusIOType == data.PointValueModbusInputRegister
+
+	if io.modbusDataType == data.PointValueBit {
+		if input {
+			return b.regs.WriteInputRegBit(io.address, io.bit, data.FloatToBool(io.value))
 		}
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
+		return b.regs.WriteRegBit(io.address, io.bit, data.FloatToBool(io.value))
 	}
-	return nil
+
+	regs, err := encodeRegs(io, io.value, io.valueText)
+	if err != nil {
+		return err
+	}
+
+	if input {
+		return b.regs.WriteInputRegs(io.address, regs)
+	}
+
+	return b.regs.WriteRegs(io.address, regs)
 }
 
 // LogError ...
@@ -637,6 +642,14 @@ func (b *Modbus) LogError(io *ModbusIONode, err error) error {
 
 // ClosePort closes both the server and client ports
 func (b *Modbus) ClosePort() {
+	if b.gateway != nil {
+		err := b.gateway.Close()
+		if err != nil {
+			log.Println("Error closing gateway: ", err)
+		}
+		b.gateway = nil
+	}
+
 	if b.server != nil {
 		err := b.server.Close()
 		if err != nil {
@@ -652,6 +665,87 @@ func (b *Modbus) ClosePort() {
 		}
 		b.client = nil
 	}
+
+	if b.capture != nil {
+		err := b.capture.Close()
+		if err != nil {
+			log.Println("Error closing modbus capture: ", err)
+		}
+		b.capture = nil
+	}
+}
+
+// modbusCaptureMaxSize is the size at which a bus capture is rotated. The
+// previous capture is kept, so a bus uses at most twice this on disk.
+const modbusCaptureMaxSize = 10 << 20
+
+// modbusCaptureDir returns the directory bus captures are written to
+func modbusCaptureDir() string {
+	dataDir := os.Getenv("SIOT_DATA")
+	if dataDir == "" {
+		dataDir = "./"
+	}
+
+	return filepath.Join(dataDir, "modbus-capture")
+}
+
+// openCapture opens the capture file for the bus if capture is enabled
+func (b *Modbus) openCapture(t modbus.TransportType) (*modbus.CaptureFile, error) {
+	if !b.busNode.capture {
+		return nil, nil
+	}
+
+	if b.capture != nil {
+		return b.capture, nil
+	}
+
+	err := os.MkdirAll(modbusCaptureDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	b.capture, err = modbus.OpenCaptureFile(filepath.Join(modbusCaptureDir(),
+		b.busNode.nodeID+".pcap"), t, modbusCaptureMaxSize)
+	return b.capture, err
+}
+
+// captureTransport wraps a transport so its traffic is captured, if
+// capture is enabled for the bus. Capture errors are logged, as the bus
+// should run without capture.
+func (b *Modbus) captureTransport(t modbus.Transport) modbus.Transport {
+	capture, err := b.openCapture(t.Type())
+	if err != nil {
+		log.Println("Error opening modbus capture: ", err)
+		return t
+	}
+
+	if capture == nil {
+		return t
+	}
+
+	return modbus.NewCaptureTransport(t, capture.CaptureWriter)
+}
+
+// modbusSerialTransport opens a serial port and creates a transport for it
+func modbusSerialTransport(portName, protocol string, baud int) (modbus.Transport, serial.Port, error) {
+	mode := &serial.Mode{
+		BaudRate: baud,
+	}
+
+	serialPort, err := serial.Open(portName, mode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening serial port: %w", err)
+	}
+
+	if protocol == data.PointValueASCII {
+		// ASCII frames are about twice as long as RTU frames, and
+		// the transport reassembles frames split across reads
+		port := respreader.NewReadWriteCloser(serialPort, time.Millisecond*500, time.Millisecond*20)
+		return modbus.NewASCII(port), serialPort, nil
+	}
+
+	port := respreader.NewReadWriteCloser(serialPort, time.Millisecond*100, time.Millisecond*20)
+	return modbus.NewRTU(port), serialPort, nil
 }
 
 // SetupPort sets up io for the bus
@@ -665,21 +759,13 @@ func (b *Modbus) SetupPort() error {
 	var transport modbus.Transport
 
 	switch b.busNode.protocol {
-	case data.PointValueRTU:
-		mode := &serial.Mode{
-			BaudRate: b.busNode.baud,
-		}
-
+	case data.PointValueRTU, data.PointValueASCII:
 		var err error
-		b.serialPort, err = serial.Open(b.busNode.portName, mode)
+		transport, b.serialPort, err = modbusSerialTransport(b.busNode.portName,
+			b.busNode.protocol, b.busNode.baud)
 		if err != nil {
-			b.serialPort = nil
-			return fmt.Errorf("Error opening serial port: %w", err)
+			return err
 		}
-
-		port := respreader.NewReadWriteCloser(b.serialPort, time.Millisecond*100, time.Millisecond*20)
-
-		transport = modbus.NewRTU(port)
 	case data.PointValueTCP:
 		switch b.busNode.busType {
 		case data.PointValueClient:
@@ -694,14 +780,31 @@ func (b *Modbus) SetupPort() error {
 		default:
 			log.Println("setting up modbus TCP, invalid bus type: ", b.busNode.busType)
 		}
+	case data.PointValueRTUOverTCP:
+		if b.busNode.busType != data.PointValueClient {
+			return errors.New("RTU over TCP is only supported for clients")
+		}
+		sock, err := net.DialTimeout("tcp", b.busNode.uri, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		transport = modbus.NewRTUOverTCP(sock, 500*time.Millisecond)
 
 	default:
 		return fmt.Errorf("Unsupported modbus protocol: %v", b.busNode.protocol)
 	}
 
+	if transport != nil {
+		transport = b.captureTransport(transport)
+	}
+
 	if b.busNode.busType == data.PointValueServer {
 		b.regs = &modbus.Regs{}
-		if b.busNode.protocol == data.PointValueRTU {
+		b.regs.SetWriteCallback(func(c modbus.RegChange) {
+			b.chRegChange <- c
+		})
+		if b.busNode.protocol == data.PointValueRTU ||
+			b.busNode.protocol == data.PointValueASCII {
 			b.server = modbus.NewServer(byte(b.busNode.id), transport,
 				b.regs, b.busNode.debugLevel)
 		} else if b.busNode.protocol == data.PointValueTCP {
@@ -712,6 +815,15 @@ func (b *Modbus) SetupPort() error {
 				b.server = nil
 				return err
 			}
+
+			// the capture is set before Listen, as it only applies to
+			// new connections
+			capture, err := b.openCapture(modbus.TransportTypeTCP)
+			if err != nil {
+				log.Println("Error opening modbus capture: ", err)
+			} else if capture != nil {
+				b.server.(*modbus.TCPServer).SetCapture(capture.CaptureWriter)
+			}
 		} else {
 			return errors.New("Modbus protocol not set")
 		}
@@ -719,10 +831,7 @@ func (b *Modbus) SetupPort() error {
 		go b.server.Listen(func(err error) {
 			log.Println("Modbus server error: ", err)
 		}, func() {
-			if b.busNode.debugLevel > 0 {
-				log.Println("Modbus reg change")
-			}
-			b.chRegChange <- true
+			// changes are handled by the regs write callback
 		}, func() {
 			if b.busNode.debugLevel > 0 {
 				log.Println("Modbus Listener done")
@@ -734,6 +843,10 @@ func (b *Modbus) SetupPort() error {
 		}
 	} else if b.busNode.busType == data.PointValueClient {
 		b.client = modbus.NewClient(transport, b.busNode.debugLevel)
+		err := b.setupGateway()
+		if err != nil {
+			return fmt.Errorf("Error starting modbus gateway: %w", err)
+		}
 	}
 
 	return nil
@@ -747,18 +860,7 @@ func (b *Modbus) SetupPort() error {
 // slow things like reading the database.
 func (b *Modbus) Run() {
 
-	// if we reset any error count, we set this to avoid continually resetting
-	scanTimer := time.NewTicker(24 * time.Hour)
-
-	setScanTimer := func() {
-		if b.busNode.busType == data.PointValueClient {
-			scanTimer.Reset(time.Millisecond * time.Duration(b.busNode.pollPeriod))
-		} else {
-			scanTimer.Stop()
-		}
-	}
-
-	setScanTimer()
+	b.setScanTimer()
 
 	checkIoTimer := time.NewTicker(time.Second * 10)
 
@@ -767,195 +869,21 @@ func (b *Modbus) Run() {
 	for {
 		select {
 		case point := <-b.chPoint:
-			p := point.point
-			if point.id == b.busNode.nodeID {
-				b.node.AddPoint(p)
-				var err error
-				b.busNode, err = NewModbusNode(b.node)
-				if err != nil {
-					log.Println("Error updating bus node: ", err)
-				}
-
-				switch point.point.Type {
-				case data.PointTypeClientServer,
-					data.PointTypeID,
-					data.PointTypeDebug,
-					data.PointTypePort,
-					data.PointTypeBaud,
-					data.PointTypeURI:
-					err := b.SetupPort()
-					if err != nil {
-						log.Println("Error setting up serial port: ", err)
-					}
-				case data.PointTypePollPeriod:
-					setScanTimer()
-
-				case data.PointTypeErrorCountReset:
-					if b.busNode.errorCountReset {
-						p := data.Point{Type: data.PointTypeErrorCount, Value: 0}
-						err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountReset, Value: 0}
-						err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-
-				case data.PointTypeErrorCountCRCReset:
-					if b.busNode.errorCountCRCReset {
-						p := data.Point{Type: data.PointTypeErrorCountCRC, Value: 0}
-						err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountCRCReset, Value: 0}
-						err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-
-				case data.PointTypeErrorCountEOFReset:
-					if b.busNode.errorCountEOFReset {
-						p := data.Point{Type: data.PointTypeErrorCountEOF, Value: 0}
-						err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountEOFReset, Value: 0}
-						err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-				}
-			} else {
-				io, ok := b.ios[point.id]
-				if !ok {
-					log.Println("modbus received point for unknown node: ", point.id)
-					// FIXME, we could create a new IO here
-					continue
-				}
-
-				valueModified := false
-				valueSetModified := false
-
-				// handle IO changes
-				switch p.Type {
-				case data.PointTypeID:
-					io.ioNode.id = int(p.Value)
-				case data.PointTypeDescription:
-					io.ioNode.description = p.Text
-				case data.PointTypeAddress:
-					io.ioNode.address = int(p.Value)
-					b.InitRegs(io.ioNode)
-				case data.PointTypeModbusIOType:
-					io.ioNode.modbusIOType = p.Text
-				case data.PointTypeDataFormat:
-					io.ioNode.modbusDataType = p.Text
-				case data.PointTypeReadOnly:
-					io.ioNode.readOnly = data.FloatToBool(p.Value)
-				case data.PointTypeScale:
-					io.ioNode.scale = p.Value
-				case data.PointTypeOffset:
-					io.ioNode.offset = p.Value
-				case data.PointTypeValue:
-					valueModified = true
-					io.ioNode.value = p.Value
-				case data.PointTypeValueSet:
-					valueSetModified = true
-					io.ioNode.valueSet = p.Value
-				case data.PointTypeDisable:
-					io.ioNode.disable = data.FloatToBool(p.Value)
-				case data.PointTypeErrorCount:
-					io.ioNode.errorCount = int(p.Value)
-				case data.PointTypeErrorCountEOF:
-					io.ioNode.errorCountEOF = int(p.Value)
-				case data.PointTypeErrorCountCRC:
-					io.ioNode.errorCountCRC = int(p.Value)
-				case data.PointTypeErrorCountReset:
-					io.ioNode.errorCountReset = data.FloatToBool(p.Value)
-					if io.ioNode.errorCountReset {
-						p := data.Point{Type: data.PointTypeErrorCount, Value: 0}
-						err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountReset, Value: 0}
-						err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-
-				case data.PointTypeErrorCountEOFReset:
-					io.ioNode.errorCountEOFReset = data.FloatToBool(p.Value)
-					if io.ioNode.errorCountEOFReset {
-						p := data.Point{Type: data.PointTypeErrorCountEOF, Value: 0}
-						err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountEOFReset, Value: 0}
-						err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-
-				case data.PointTypeErrorCountCRCReset:
-					io.ioNode.errorCountCRCReset = data.FloatToBool(p.Value)
-					if io.ioNode.errorCountCRCReset {
-						p := data.Point{Type: data.PointTypeErrorCountCRC, Value: 0}
-						err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-
-						p = data.Point{Type: data.PointTypeErrorCountCRCReset, Value: 0}
-						err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
-						if err != nil {
-							log.Println("Send point error: ", err)
-						}
-					}
-				default:
-					log.Println("modbus: unhandled io point: ", p)
-				}
-
-				if valueModified && b.busNode.busType == data.PointValueServer {
-					err := b.ServerIO(io.ioNode)
-					if err != nil {
-						err := b.LogError(io.ioNode, err)
-						if err != nil {
-							log.Println("Error logging error: ", err)
-						}
-					}
-				}
-
-				if valueSetModified && (b.busNode.busType == data.PointValueClient) &&
-					(io.ioNode.modbusDataType == data.PointValueModbusCoil ||
-						io.ioNode.modbusDataType == data.PointValueModbusHoldingRegister) &&
-					(io.ioNode.value != io.ioNode.valueSet) {
-					err := b.ClientIO(io)
-					if err != nil {
-						err := b.LogError(io.ioNode, err)
-						if err != nil {
-							log.Println("Error logging error: ", err)
-						}
-					}
-				}
-			}
-		case <-b.chRegChange:
+			b.handlePoint(point)
+		case r := <-b.chGateway:
+			b.handleGateway(r)
+		case r := <-b.chScan:
+			r.resp <- b.startScan(r.req)
+		case c := <-b.chRegChange:
 			// this only happens on modbus servers
+			if b.busNode.debugLevel > 0 {
+				log.Printf("Modbus %v written: %v, count: %v\n", c.Table,
+					c.Address, c.Count)
+			}
 			for _, io := range b.ios {
+				if !regChanged(io.ioNode, c) {
+					continue
+				}
 				err := b.ServerIO(io.ioNode)
 				if err != nil {
 					err := b.LogError(io.ioNode, err)
@@ -976,8 +904,9 @@ func (b *Modbus) Run() {
 			if b.busNode.disable {
 				b.ClosePort()
 			} else {
-				if (b.client == nil && b.server == nil) ||
-					b.ioErrorCount > 10 || portError != nil {
+				// a scan manages the port itself
+				if b.busScan == nil && ((b.client == nil && b.server == nil) ||
+					b.ioErrorCount > 10 || portError != nil) {
 					if b.busNode.debugLevel >= 1 {
 						log.Printf("Re-initializing modbus port, err cnt: %v, portError: %v\n", b.ioErrorCount, portError)
 					}
@@ -990,24 +919,13 @@ func (b *Modbus) Run() {
 				if err := b.CheckIOs(); err != nil {
 					log.Println("CheckIOs error: ", err)
 				}
+				b.setScanTimer()
 			}
+			b.reportUtilization()
 
-		case <-scanTimer.C:
-			if b.busNode.busType == data.PointValueClient && !b.busNode.disable {
-				for _, io := range b.ios {
-					if io.ioNode.disable {
-						continue
-					}
-					// for scanning, we only need to process client ios
-					err := b.ClientIO(io)
-					if err != nil {
-						err := b.LogError(io.ioNode, err)
-						if err != nil {
-							log.Println("Error logging modbus error: ", err)
-						}
-					}
-				}
-			}
+		case <-b.scanTimer.C:
+			b.scan()
+			b.setScanTimer()
 		case <-b.chDone:
 			log.Println("Stopping client IO for: ", b.busNode.portName)
 			b.ClosePort()
@@ -1016,6 +934,217 @@ func (b *Modbus) Run() {
 	}
 }
 
+// handlePoint handles a point for the bus or one of its IOs
+func (b *Modbus) handlePoint(point pointWID) {
+	p := point.point
+	if point.id == b.busNode.nodeID {
+		b.node.AddPoint(p)
+		var err error
+		b.busNode, err = NewModbusNode(b.node)
+		if err != nil {
+			log.Println("Error updating bus node: ", err)
+		}
+
+		// bus type, poll period, or disable may have changed
+		defer b.setScanTimer()
+
+		switch point.point.Type {
+		case data.PointTypeClientServer,
+			data.PointTypeID,
+			data.PointTypeDebug,
+			data.PointTypePort,
+			data.PointTypeBaud,
+			data.PointTypeURI,
+			data.PointTypeProtocol,
+			data.PointTypeGatewayPort,
+			data.PointTypeCapture:
+			// port settings changed, so scan results would be invalid
+			b.stopScan()
+			err := b.SetupPort()
+			if err != nil {
+				log.Println("Error setting up serial port: ", err)
+			}
+		case data.PointTypePollPeriod:
+			// reschedule IOs with the new period
+			for _, io := range b.ios {
+				io.nextPoll = time.Time{}
+			}
+
+		case data.PointTypeErrorCountReset:
+			if b.busNode.errorCountReset {
+				p := data.Point{Type: data.PointTypeErrorCount, Value: 0}
+				err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountReset, Value: 0}
+				err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+
+		case data.PointTypeErrorCountCRCReset:
+			if b.busNode.errorCountCRCReset {
+				p := data.Point{Type: data.PointTypeErrorCountCRC, Value: 0}
+				err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountCRCReset, Value: 0}
+				err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+
+		case data.PointTypeErrorCountEOFReset:
+			if b.busNode.errorCountEOFReset {
+				p := data.Point{Type: data.PointTypeErrorCountEOF, Value: 0}
+				err := client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountEOFReset, Value: 0}
+				err = client.SendNodePoint(b.nc, b.busNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+		}
+	} else {
+		io, ok := b.ios[point.id]
+		if !ok {
+			log.Println("modbus received point for unknown node: ", point.id)
+			// FIXME, we could create a new IO here
+			return
+		}
+
+		valueModified := false
+		valueSetModified := false
+
+		// handle IO changes
+		switch p.Type {
+		case data.PointTypeID:
+			io.ioNode.id = int(p.Value)
+		case data.PointTypeDescription:
+			io.ioNode.description = p.Text
+		case data.PointTypeAddress:
+			io.ioNode.address = int(p.Value)
+			b.InitRegs(io.ioNode)
+		case data.PointTypeModbusIOType:
+			io.ioNode.modbusIOType = p.Text
+			b.InitRegs(io.ioNode)
+		case data.PointTypeDataFormat:
+			io.ioNode.modbusDataType = p.Text
+			b.InitRegs(io.ioNode)
+		case data.PointTypeByteOrder:
+			io.ioNode.byteOrder = p.Text
+		case data.PointTypeBit:
+			io.ioNode.bit = int(p.Value)
+		case data.PointTypeCount:
+			io.ioNode.count = int(p.Value)
+			b.InitRegs(io.ioNode)
+		case data.PointTypeReadOnly:
+			io.ioNode.readOnly = data.FloatToBool(p.Value)
+			b.InitRegs(io.ioNode)
+		case data.PointTypeScale:
+			io.ioNode.scale = p.Value
+		case data.PointTypeOffset:
+			io.ioNode.offset = p.Value
+		case data.PointTypeValue:
+			valueModified = true
+			io.ioNode.value = p.Value
+			io.ioNode.valueText = p.Text
+		case data.PointTypeValueSet:
+			valueSetModified = true
+			io.ioNode.valueSet = p.Value
+			io.ioNode.valueSetText = p.Text
+		case data.PointTypeDisable:
+			io.ioNode.disable = data.FloatToBool(p.Value)
+		case data.PointTypePollPeriod:
+			io.ioNode.pollPeriod = int(p.Value)
+			io.nextPoll = time.Time{}
+			b.setScanTimer()
+		case data.PointTypeErrorCount:
+			io.ioNode.errorCount = int(p.Value)
+		case data.PointTypeErrorCountEOF:
+			io.ioNode.errorCountEOF = int(p.Value)
+		case data.PointTypeErrorCountCRC:
+			io.ioNode.errorCountCRC = int(p.Value)
+		case data.PointTypeErrorCountReset:
+			io.ioNode.errorCountReset = data.FloatToBool(p.Value)
+			if io.ioNode.errorCountReset {
+				p := data.Point{Type: data.PointTypeErrorCount, Value: 0}
+				err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountReset, Value: 0}
+				err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+
+		case data.PointTypeErrorCountEOFReset:
+			io.ioNode.errorCountEOFReset = data.FloatToBool(p.Value)
+			if io.ioNode.errorCountEOFReset {
+				p := data.Point{Type: data.PointTypeErrorCountEOF, Value: 0}
+				err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountEOFReset, Value: 0}
+				err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+
+		case data.PointTypeErrorCountCRCReset:
+			io.ioNode.errorCountCRCReset = data.FloatToBool(p.Value)
+			if io.ioNode.errorCountCRCReset {
+				p := data.Point{Type: data.PointTypeErrorCountCRC, Value: 0}
+				err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+
+				p = data.Point{Type: data.PointTypeErrorCountCRCReset, Value: 0}
+				err = client.SendNodePoint(b.nc, io.ioNode.nodeID, p, true)
+				if err != nil {
+					log.Println("Send point error: ", err)
+				}
+			}
+		default:
+			log.Println("modbus: unhandled io point: ", p)
+		}
+
+		if valueModified && b.busNode.busType == data.PointValueServer {
+			err := b.ServerIO(io.ioNode)
+			if err != nil {
+				err := b.LogError(io.ioNode, err)
+				if err != nil {
+					log.Println("Error logging error: ", err)
+				}
+			}
+		}
+
+		// writes are sent right away, before any reads that are
+		// due
+		if valueSetModified && b.busNode.busType == data.PointValueClient &&
+			!io.ioNode.disable {
+			b.clientWrite(io)
+		}
+	}
+}
+
 func modbusErrorToPointType(err error) string {
 	switch err {
 	case io.EOF:
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/simpleiot/simpleiot/assets/files"
 	"github.com/simpleiot/simpleiot/system"
@@ -119,6 +120,11 @@ func Args(args []string) (Options, error) {
 		}
 	}
 
+	natsTLSCA := os.Getenv("SIOT_NATS_TLS_CA")
+	natsTLSVerify := os.Getenv("SIOT_NATS_TLS_VERIFY") == "true"
+	natsTLSRevoked := splitList(os.Getenv("SIOT_NATS_TLS_REVOKED"))
+	natsNKeys := splitList(os.Getenv("SIOT_NATS_NKEYS"))
+
 	authToken := os.Getenv("SIOT_AUTH_TOKEN")
 	if *flagAuthToken != "" {
 		authToken = *flagAuthToken
@@ -161,6 +167,10 @@ func Args(args []string) (Options, error) {
 		NatsTLSCert:       natsTLSCert,
 		NatsTLSKey:        natsTLSKey,
 		NatsTLSTimeout:    natsTLSTimeout,
+		NatsTLSCA:         natsTLSCA,
+		NatsTLSVerify:     natsTLSVerify,
+		NatsTLSRevoked:    natsTLSRevoked,
+		NatsNKeys:         natsNKeys,
 		AuthToken:         authToken,
 		ParticleAPIKey:    particleAPIKey,
 		OSVersionField:    osVersionField,
@@ -170,3 +180,15 @@ func Args(args []string) (Options, error) {
 	return o, nil
 
 }
+
+// splitList splits a comma separated list and removes empty entries
+func splitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
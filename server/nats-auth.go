@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+)
+
+// natsAuth is used when client certificates or NKeys are enabled. A
+// client is allowed to connect if any of the following is true:
+//
+//   - the client sends the auth token
+//   - the client sends a SIOT user JWT as its token. The JWT signature and
+//     expiry are verified with the store key. This is how browser
+//     websocket UI clients authenticate, as they can't present a client
+//     certificate or NKey.
+//   - no auth token is configured and the client is local (loopback) and
+//     not connected over the websocket. This allows the SIOT store and
+//     clients to connect.
+//   - the client presents a certificate signed by the CA that is not
+//     revoked
+//   - the client signs the server nonce with an allowed NKey
+//
+// Each field gateway can be given its own certificate or NKey, which can
+// be revoked without affecting other gateways. NATS user JWTs (creds) are
+// discarded by the nats server when it is not in operator mode, so they
+// are never accepted here.
+type natsAuth struct {
+	token      string
+	userTokens tokenValidator
+	certs      bool
+	nkeys      map[string]bool
+	revoked    map[string]bool
+}
+
+func newNatsAuth(o natsServerOptions) *natsAuth {
+	a := &natsAuth{
+		token:      o.Auth,
+		userTokens: o.UserTokens,
+		certs:      o.TLSVerify,
+		nkeys:      make(map[string]bool),
+		revoked:    make(map[string]bool),
+	}
+
+	for _, k := range o.NKeys {
+		a.nkeys[strings.TrimSpace(k)] = true
+	}
+
+	for _, r := range o.TLSRevoked {
+		a.revoked[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+
+	return a
+}
+
+// isWebsocketClient returns true if the client connected over the
+// websocket listener. The nats server does not expose the client type to
+// custom authentication, but includes it in the connection string, which
+// is "<addr> - wid:<id>" for websocket clients.
+func isWebsocketClient(c server.ClientAuthentication) bool {
+	s, ok := c.(fmt.Stringer)
+	return ok && strings.Contains(s.String(), " - wid:")
+}
+
+// certFingerprint returns the hex encoded SHA256 fingerprint of a cert
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Check implements the server.Authentication interface
+func (a *natsAuth) Check(c server.ClientAuthentication) bool {
+	opts := c.GetOpts()
+
+	if a.token != "" && opts.Token == a.token {
+		return true
+	}
+
+	if opts.Token != "" && a.userTokens != nil {
+		if valid, _ := a.userTokens.ValidToken(opts.Token); valid {
+			return true
+		}
+	}
+
+	// websocket clients are not trusted for being local, as the HTTP
+	// server proxies remote websocket clients from localhost
+	if a.token == "" && !isWebsocketClient(c) {
+		if addr, ok := c.RemoteAddress().(*net.TCPAddr); ok && addr.IP.IsLoopback() {
+			return true
+		}
+	}
+
+	if a.certs {
+		if tls := c.GetTLSConnectionState(); tls != nil &&
+			len(tls.VerifiedChains) > 0 && len(tls.PeerCertificates) > 0 {
+			cert := tls.PeerCertificates[0]
+			return !a.revoked[certFingerprint(cert)] &&
+				!a.revoked[strings.ToLower(cert.Subject.CommonName)]
+		}
+	}
+
+	if opts.Nkey != "" && a.nkeys[opts.Nkey] {
+		pub, err := nkeys.FromPublicKey(opts.Nkey)
+		if err != nil {
+			return false
+		}
+
+		sig, err := base64.RawURLEncoding.DecodeString(opts.Sig)
+		if err != nil {
+			// older clients may use standard encoding
+			sig, err = base64.StdEncoding.DecodeString(opts.Sig)
+			if err != nil {
+				return false
+			}
+		}
+
+		return pub.Verify(c.GetNonce(), sig) == nil
+	}
+
+	return false
+}
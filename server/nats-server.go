@@ -1,8 +1,10 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
@@ -16,6 +18,24 @@ type natsServerOptions struct {
 	TLSCert    string
 	TLSKey     string
 	TLSTimeout float64
+	// TLSCA is the CA file used to verify client certificates
+	TLSCA string
+	// TLSVerify enables client certificate authentication
+	TLSVerify bool
+	// TLSRevoked is a list of revoked client certificate SHA256
+	// fingerprints or common names
+	TLSRevoked []string
+	// NKeys is a list of allowed NKey public keys
+	NKeys []string
+	// UserTokens validates SIOT user JWTs sent as the client token, so
+	// websocket UI clients can connect when certificates or NKeys are
+	// enabled.
+	UserTokens tokenValidator
+}
+
+// tokenValidator validates SIOT user JWTs. It is implemented by api.Key.
+type tokenValidator interface {
+	ValidToken(string) (bool, string)
 }
 
 // newNatsServer creates a new nats server instance
@@ -36,8 +56,8 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		tc := server.TLSConfigOpts{}
 		tc.CertFile = opts.TLSCert
 		tc.KeyFile = opts.TLSKey
-		tc.CaFile = opts.TLSCaCert
-		tc.Verify = opts.TLSVerify
+		tc.CaFile = o.TLSCA
+		tc.Verify = o.TLSVerify
 
 		var err error
 		opts.TLSConfig, err = server.GenTLSConfig(&tc)
@@ -45,6 +65,20 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Error setting up TLS: %v", err)
 		}
+
+		if o.TLSVerify {
+			// certs are verified if sent, and natsAuth decides if
+			// a client without a cert can connect
+			opts.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			opts.TLSCaCert = o.TLSCA
+		}
+	} else if o.TLSVerify {
+		return nil, fmt.Errorf("TLS cert and key are required for client cert verification")
+	}
+
+	if o.TLSVerify || len(o.NKeys) > 0 {
+		opts.CustomClientAuthentication = newNatsAuth(o)
+		opts.AlwaysEnableNonce = true
 	}
 
 	if o.WSPort != 0 {
@@ -61,10 +95,23 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		return nil, fmt.Errorf("Error create new Nats server: %v", err)
 	}
 
-	authEnabled := "no"
+	var auth []string
 
 	if o.Auth != "" {
-		authEnabled = "yes"
+		auth = append(auth, "token")
+	}
+
+	if o.TLSVerify {
+		auth = append(auth, "client certs")
+	}
+
+	if len(o.NKeys) > 0 {
+		auth = append(auth, "nkeys")
+	}
+
+	authEnabled := "no"
+	if len(auth) > 0 {
+		authEnabled = strings.Join(auth, ", ")
 	}
 
 	log.Printf("NATS server, port: %v, http port: %v, auth enabled: %v\n",
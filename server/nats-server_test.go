@@ -0,0 +1,339 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/simpleiot/simpleiot/api"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert, notAfter time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	p := filepath.Join(dir, name)
+	err := os.WriteFile(p, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNatsServerClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	ca := newTestCert(t, "ca", 1, nil, expiry)
+	srv := newTestCert(t, "server", 2, ca, expiry)
+	gw1 := newTestCert(t, "gateway1", 3, ca, expiry)
+	gw2 := newTestCert(t, "gateway2", 4, ca, expiry)
+	// signed by a different CA
+	other := newTestCert(t, "other", 5, newTestCert(t, "other-ca", 6, nil, expiry), expiry)
+
+	nkey, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nkeyPub, _ := nkey.PublicKey()
+	nkeySeed, _ := nkey.Seed()
+
+	badKey, _ := nkeys.CreateUser()
+	badSeed, _ := badKey.Seed()
+
+	ns, err := newNatsServer(natsServerOptions{
+		Port:       8930,
+		HTTPPort:   -1,
+		Auth:       "secret",
+		TLSCert:    writeTestFile(t, dir, "server.crt", srv.certPEM),
+		TLSKey:     writeTestFile(t, dir, "server.key", srv.keyPEM),
+		TLSCA:      writeTestFile(t, dir, "ca.crt", ca.certPEM),
+		TLSTimeout: 2,
+		TLSVerify:  true,
+		TLSRevoked: []string{"gateway2"},
+		NKeys:      []string{nkeyPub},
+	})
+	if err != nil {
+		t.Fatal("Error creating server: ", err)
+	}
+
+	go ns.Start()
+	defer ns.Shutdown()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("server not ready")
+	}
+
+	uri := "nats://localhost:8930"
+
+	tests := []struct {
+		name string
+		opts client.EdgeOptions
+		ok   bool
+	}{
+		{"token", client.EdgeOptions{AuthToken: "secret"}, true},
+		{"no credentials", client.EdgeOptions{}, false},
+		{"bad token", client.EdgeOptions{AuthToken: "wrong"}, false},
+		{"client cert", client.EdgeOptions{TLSCert: gw1.certPEM, TLSKey: gw1.keyPEM}, true},
+		{"revoked cert", client.EdgeOptions{TLSCert: gw2.certPEM, TLSKey: gw2.keyPEM}, false},
+		{"other CA", client.EdgeOptions{TLSCert: other.certPEM, TLSKey: other.keyPEM}, false},
+		{"nkey", client.EdgeOptions{NKeySeed: string(nkeySeed)}, true},
+		{"unknown nkey", client.EdgeOptions{NKeySeed: string(badSeed)}, false},
+	}
+
+	for _, test := range tests {
+		test.opts.URI = uri
+		test.opts.TLSCA = ca.certPEM
+
+		connected, err := testEdgeConnected(test.opts)
+		if err != nil {
+			t.Fatalf("%v: error connecting: %v", test.name, err)
+		}
+
+		if connected != test.ok {
+			t.Errorf("%v: expected connected: %v, got: %v", test.name, test.ok, connected)
+		}
+	}
+
+	exp, err := client.CertExpiry(gw1.certPEM)
+	if err != nil {
+		t.Fatal("Error getting cert expiry: ", err)
+	}
+
+	if !exp.Equal(expiry) {
+		t.Errorf("wrong cert expiry, exp %v, got %v", expiry, exp)
+	}
+}
+
+// testEdgeConnected returns whether a client can connect with opts
+func testEdgeConnected(opts client.EdgeOptions) (bool, error) {
+	opts.Connected = func() {}
+	opts.Disconnected = func() {}
+	opts.Reconnected = func() {}
+	opts.Closed = func() {}
+
+	nc, err := client.EdgeConnect(opts)
+	if err != nil {
+		return false, err
+	}
+
+	defer nc.Close()
+
+	start := time.Now()
+	for time.Since(start) < time.Second {
+		if nc.Status() == nats.CONNECTED {
+			return true, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false, nil
+}
+
+func TestNatsServerWebsocketAuth(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	ca := newTestCert(t, "ca", 1, nil, expiry)
+	srv := newTestCert(t, "server", 2, ca, expiry)
+
+	key, _ := api.NewKey([]byte("test key"))
+	otherKey, _ := api.NewKey([]byte("other key"))
+
+	ns, err := newNatsServer(natsServerOptions{
+		Port:       8931,
+		HTTPPort:   -1,
+		WSPort:     8932,
+		Auth:       "secret",
+		TLSCert:    writeTestFile(t, dir, "server.crt", srv.certPEM),
+		TLSKey:     writeTestFile(t, dir, "server.key", srv.keyPEM),
+		TLSCA:      writeTestFile(t, dir, "ca.crt", ca.certPEM),
+		TLSTimeout: 2,
+		TLSVerify:  true,
+		UserTokens: key,
+	})
+	if err != nil {
+		t.Fatal("Error creating server: ", err)
+	}
+
+	go ns.Start()
+	defer ns.Shutdown()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("server not ready")
+	}
+
+	userToken, err := key.NewToken("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherToken, err := otherKey.NewToken("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiredToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256,
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+			Issuer:    "simpleiot",
+			Id:        "user1",
+		}).SignedString([]byte("test key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"user JWT", userToken, true},
+		{"auth token", "secret", true},
+		{"no token", "", false},
+		{"expired JWT", expiredToken, false},
+		{"JWT signed with other key", otherToken, false},
+	}
+
+	for _, test := range tests {
+		connected, err := testEdgeConnected(client.EdgeOptions{
+			URI:       "ws://localhost:8932",
+			AuthToken: test.token,
+		})
+		if err != nil {
+			t.Fatalf("%v: error connecting: %v", test.name, err)
+		}
+
+		if connected != test.ok {
+			t.Errorf("%v: expected connected: %v, got: %v", test.name, test.ok, connected)
+		}
+	}
+}
+
+func TestNatsServerWebsocketNoToken(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	ca := newTestCert(t, "ca", 1, nil, expiry)
+	srv := newTestCert(t, "server", 2, ca, expiry)
+
+	key, _ := api.NewKey([]byte("test key"))
+
+	// without an auth token, local clients are trusted, but websocket
+	// clients are not, as the HTTP server proxies them from localhost
+	ns, err := newNatsServer(natsServerOptions{
+		Port:       8933,
+		HTTPPort:   -1,
+		WSPort:     8934,
+		TLSCert:    writeTestFile(t, dir, "server.crt", srv.certPEM),
+		TLSKey:     writeTestFile(t, dir, "server.key", srv.keyPEM),
+		TLSCA:      writeTestFile(t, dir, "ca.crt", ca.certPEM),
+		TLSTimeout: 2,
+		TLSVerify:  true,
+		UserTokens: key,
+	})
+	if err != nil {
+		t.Fatal("Error creating server: ", err)
+	}
+
+	go ns.Start()
+	defer ns.Shutdown()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("server not ready")
+	}
+
+	userToken, err := key.NewToken("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts client.EdgeOptions
+		ok   bool
+	}{
+		{"local client", client.EdgeOptions{URI: "nats://localhost:8933",
+			TLSCA: ca.certPEM}, true},
+		{"local websocket", client.EdgeOptions{URI: "ws://localhost:8934"}, false},
+		{"websocket user JWT", client.EdgeOptions{URI: "ws://localhost:8934",
+			AuthToken: userToken}, true},
+	}
+
+	for _, test := range tests {
+		connected, err := testEdgeConnected(test.opts)
+		if err != nil {
+			t.Fatalf("%v: error connecting: %v", test.name, err)
+		}
+
+		if connected != test.ok {
+			t.Errorf("%v: expected connected: %v, got: %v", test.name, test.ok, connected)
+		}
+	}
+}
@@ -41,6 +41,10 @@ type Options struct {
 	NatsTLSCert       string
 	NatsTLSKey        string
 	NatsTLSTimeout    float64
+	NatsTLSCA         string
+	NatsTLSVerify     bool
+	NatsTLSRevoked    []string
+	NatsNKeys         []string
 	AuthToken         string
 	ParticleAPIKey    string
 	AppVersion        string
@@ -135,6 +139,33 @@ func (s *Server) Run() error {
 	// The store will wait on this before shutting down
 	var storeWg sync.WaitGroup
 
+	// ====================================
+	// SIOT Store
+	// ====================================
+
+	// The store is created before the nats server, as the nats server
+	// validates user JWTs with the store key.
+
+	storeParams := store.Params{
+		File:      o.StoreFile,
+		AuthToken: o.AuthToken,
+		Server:    o.NatsServer,
+		Nc:        s.nc,
+		ID:        s.options.ID,
+	}
+
+	siotStore, err := store.NewStore(storeParams)
+
+	if o.ResetStore {
+		if err := siotStore.Reset(); err != nil {
+			log.Fatal("Error resetting store:", err)
+		}
+	}
+
+	if err != nil {
+		log.Fatal("Error creating store: ", err)
+	}
+
 	// ====================================
 	// Nats server
 	// ====================================
@@ -146,6 +177,14 @@ func (s *Server) Run() error {
 		TLSCert:    o.NatsTLSCert,
 		TLSKey:     o.NatsTLSKey,
 		TLSTimeout: o.NatsTLSTimeout,
+		TLSCA:      o.NatsTLSCA,
+		TLSVerify:  o.NatsTLSVerify,
+		TLSRevoked: o.NatsTLSRevoked,
+		NKeys:      o.NatsNKeys,
+	}
+
+	if v, ok := siotStore.GetAuthorizer().(tokenValidator); ok {
+		natsOptions.UserTokens = v
 	}
 
 	if !o.NatsDisableServer {
@@ -168,30 +207,6 @@ func (s *Server) Run() error {
 		})
 	}
 
-	// ====================================
-	// SIOT Store
-	// ====================================
-
-	storeParams := store.Params{
-		File:      o.StoreFile,
-		AuthToken: o.AuthToken,
-		Server:    o.NatsServer,
-		Nc:        s.nc,
-		ID:        s.options.ID,
-	}
-
-	siotStore, err := store.NewStore(storeParams)
-
-	if o.ResetStore {
-		if err := siotStore.Reset(); err != nil {
-			log.Fatal("Error resetting store:", err)
-		}
-	}
-
-	if err != nil {
-		log.Fatal("Error creating store: ", err)
-	}
-
 	siotWaitCtx, siotWaitCancel := context.WithTimeout(context.Background(), time.Second*10)
 
 	g.Add(func() error {
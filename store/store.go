@@ -107,6 +107,10 @@ func (st *Store) Run() error {
 		return fmt.Errorf("Subscribe edge points error: %w", err)
 	}
 
+	if st.subscriptions["syncBatch"], err = nc.Subscribe(client.SubjectSyncBatch(), st.handleSyncBatch); err != nil {
+		return fmt.Errorf("Subscribe sync batch error: %w", err)
+	}
+
 	if st.subscriptions["nodes"], err = nc.Subscribe("nodes.*.*", st.handleNodesRequest); err != nil {
 		return fmt.Errorf("Subscribe node error: %w", err)
 	}
@@ -321,6 +325,39 @@ func (st *Store) handleEdgePoints(msg *nats.Msg) {
 	st.reply(msg.Reply, nil)
 }
 
+// handleSyncBatch unpacks a batch of points sent by a downstream sync
+// client into normal point messages, so they are stored and seen by all
+// subscribers the same as points sent individually. Each message is acked
+// before the batch is acked.
+func (st *Store) handleSyncBatch(msg *nats.Msg) {
+	nodes, err := client.DecodeSyncBatch(msg.Data)
+	if err != nil {
+		log.Println("Error decoding sync batch: ", err)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	var retErr error
+
+	for _, n := range nodes {
+		if len(n.Points) > 0 {
+			err := client.SendNodePoints(st.nc, n.ID, n.Points, true)
+			if err != nil {
+				retErr = err
+			}
+		}
+
+		if len(n.EdgePoints) > 0 {
+			err := client.SendEdgePoints(st.nc, n.ID, n.Parent, n.EdgePoints, true)
+			if err != nil {
+				retErr = err
+			}
+		}
+	}
+
+	st.reply(msg.Reply, retErr)
+}
+
 func (st *Store) handleNodesRequest(msg *nats.Msg) {
 	start := time.Now()
 	defer func() {